@@ -0,0 +1,57 @@
+package main
+
+import (
+	"cmp"
+	"slices"
+)
+
+//--- Slave aggregates ---//
+
+// Set from AGGREGATE_SLAVES / AGGREGATE_SLAVE_ID. When enabled, every uplink
+// also gets a row under aggregateSlaveID holding the mean across slaves of
+// each sensor type/index.
+var (
+	aggregateSlaves  bool
+	aggregateSlaveID = -1
+)
+
+// Returns one mean row per sensor type/index that was reported by at least
+// two slaves. Rows are ordered by type then index.
+func aggregateSlaveRows(rows []MeasurementRow, slaveID int) []MeasurementRow {
+	type key struct{ sensorType, sensorIndex int }
+	type acc struct {
+		row    MeasurementRow
+		sum    float64
+		n      int
+		slaves map[int]bool
+	}
+	accs := map[key]*acc{}
+	for _, r := range rows {
+		k := key{r.SensorType, r.SensorIndex}
+		a, ok := accs[k]
+		if !ok {
+			a = &acc{row: r, slaves: map[int]bool{}}
+			accs[k] = a
+		}
+		a.sum += r.Value
+		a.n++
+		a.slaves[r.SlaveID] = true
+	}
+
+	var out []MeasurementRow
+	for _, a := range accs {
+		if len(a.slaves) < 2 {
+			continue
+		}
+		row := a.row
+		row.SlaveID = slaveID
+		row.Value = a.sum / float64(a.n)
+		row.RawValue = nil
+		row.MessageID = measurementMessageID(row.Time, row.StationEUI, slaveID, row.SensorType, row.SensorIndex)
+		out = append(out, row)
+	}
+	slices.SortFunc(out, func(a, b MeasurementRow) int {
+		return cmp.Or(cmp.Compare(a.SensorType, b.SensorType), cmp.Compare(a.SensorIndex, b.SensorIndex))
+	})
+	return out
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+//--- Sensor threshold alerts ---//
+
+// When set, every ingested reading is checked against the per sensor type
+// thresholds from THRESHOLD_CONFIG_PATH.
+var thresholdAlerts *thresholdAlerter
+
+// One entry of thresholds.json, keyed by sensor type ID:
+//
+//	{"1": {"low": -5, "high": 40}, "2": {"high": 95}}
+type sensorThreshold struct {
+	Low  *float64 `json:"low"`
+	High *float64 `json:"high"`
+}
+
+// SensorThresholdAlert is published to ALERT_MQTT_TOPIC when a reading
+// crosses a threshold, and again with Cleared set once it is back in range.
+type SensorThresholdAlert struct {
+	Time        time.Time `json:"time"`
+	StationEUI  string    `json:"station_eui"`
+	SlaveID     int       `json:"slave_id"`
+	SensorType  int       `json:"sensor_type"`
+	SensorIndex int       `json:"sensor_index"`
+	Value       float64   `json:"value"`
+	Threshold   float64   `json:"threshold"`
+	Direction   string    `json:"direction"` // "high" or "low"
+	Cleared     bool      `json:"cleared,omitempty"`
+}
+
+type alertKey struct {
+	eui         string
+	slave       int
+	sensorType  int
+	sensorIndex int
+}
+
+type thresholdAlerter struct {
+	log        Logger
+	thresholds map[int]sensorThreshold
+
+	mu     sync.Mutex
+	active map[alertKey]SensorThresholdAlert
+	// Called with every raised or cleared alert; empty when there is nowhere
+	// to publish (e.g. webhook mode), in which case alerts are only logged.
+	publishers []func(SensorThresholdAlert)
+}
+
+// Reads the thresholds file at path.
+func loadThresholds(path string) (map[int]sensorThreshold, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]sensorThreshold
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	out := make(map[int]sensorThreshold, len(raw))
+	for k, t := range raw {
+		id, err := strconv.Atoi(k)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: invalid sensor type %q", path, k)
+		}
+		out[id] = t
+	}
+	return out, nil
+}
+
+func newThresholdAlerter(lg Logger, thresholds map[int]sensorThreshold) *thresholdAlerter {
+	return &thresholdAlerter{log: lg, thresholds: thresholds, active: map[alertKey]SensorThresholdAlert{}}
+}
+
+// Adds a destination for alerts. Safe to call while readings are checked.
+func (a *thresholdAlerter) addPublisher(f func(SensorThresholdAlert)) {
+	a.mu.Lock()
+	a.publishers = append(a.publishers, f)
+	a.mu.Unlock()
+}
+
+// Checks every reading of p. An alert is raised once when a reading leaves
+// its range and cleared once it is back inside.
+func (a *thresholdAlerter) check(p *Parsed) {
+	for _, s := range p.Msg.DecodedPayload.Slaves {
+		for _, m := range s.Sensors {
+			th, ok := a.thresholds[m.Type]
+			if !ok {
+				continue
+			}
+			alert := SensorThresholdAlert{
+				Time: p.When, StationEUI: p.StationEUI, SlaveID: s.ID,
+				SensorType: m.Type, SensorIndex: m.Index, Value: m.Value,
+			}
+			switch {
+			case th.High != nil && m.Value > *th.High:
+				alert.Threshold, alert.Direction = *th.High, "high"
+			case th.Low != nil && m.Value < *th.Low:
+				alert.Threshold, alert.Direction = *th.Low, "low"
+			}
+			a.update(alertKey{p.StationEUI, s.ID, m.Type, m.Index}, alert)
+		}
+	}
+}
+
+func (a *thresholdAlerter) update(k alertKey, alert SensorThresholdAlert) {
+	a.mu.Lock()
+	publishers := a.publishers
+	prev, wasActive := a.active[k]
+	switch {
+	case alert.Direction != "" && (!wasActive || prev.Direction != alert.Direction):
+		a.active[k] = alert
+	case alert.Direction == "" && wasActive:
+		delete(a.active, k)
+		alert.Threshold, alert.Direction, alert.Cleared = prev.Threshold, prev.Direction, true
+	default:
+		a.mu.Unlock()
+		return
+	}
+	a.mu.Unlock()
+
+	if alert.Cleared {
+		a.log.Info("threshold alert cleared: %s slave %d type %d idx %d = %v", alert.StationEUI, alert.SlaveID, alert.SensorType, alert.SensorIndex, alert.Value)
+	} else {
+		a.log.Warn("threshold alert: %s slave %d type %d idx %d = %v (%s threshold %v)", alert.StationEUI, alert.SlaveID, alert.SensorType, alert.SensorIndex, alert.Value, alert.Direction, alert.Threshold)
+	}
+	for _, publish := range publishers {
+		publish(alert)
+	}
+}
+
+// Returns the alerts that haven't cleared yet, oldest first.
+func (a *thresholdAlerter) activeAlerts() []SensorThresholdAlert {
+	a.mu.Lock()
+	out := make([]SensorThresholdAlert, 0, len(a.active))
+	for _, alert := range a.active {
+		out = append(out, alert)
+	}
+	a.mu.Unlock()
+	slices.SortFunc(out, func(x, y SensorThresholdAlert) int {
+		return cmp.Or(x.Time.Compare(y.Time), cmp.Compare(x.StationEUI, y.StationEUI))
+	})
+	return out
+}
+
+// Publishes alerts as JSON to topic without blocking the ingest path.
+func mqttAlertPublisher(lg Logger, client mqtt.Client, topic string) func(SensorThresholdAlert) {
+	return func(alert SensorThresholdAlert) {
+		b, err := json.Marshal(alert)
+		if err != nil {
+			lg.Error("alert marshal error: %v", err)
+			return
+		}
+		token := client.Publish(topic, 1, false, b)
+		go func() {
+			if token.Wait() && token.Error() != nil {
+				lg.Error("alert publish error: %v", token.Error())
+			}
+		}()
+	}
+}
+
+// Body posted to ANOMALY_WEBHOOK_URL when a reading leaves its range.
+type anomalyWebhookAlert struct {
+	StationEUI string    `json:"station_eui"`
+	SlaveID    int       `json:"slave_id"`
+	SensorType int       `json:"sensor_type"`
+	Value      float64   `json:"value"`
+	Threshold  float64   `json:"threshold"`
+	Direction  string    `json:"direction"`
+	CrossedAt  time.Time `json:"crossed_at"`
+}
+
+// POSTs every raised alert to url in the background. Only the crossing is
+// sent, not the clearing; the alerter's in-alarm state already keeps a
+// sensor that stays out of range from firing again.
+func webhookAlertPublisher(lg Logger, url string) func(SensorThresholdAlert) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	return func(alert SensorThresholdAlert) {
+		if alert.Cleared {
+			return
+		}
+		b, err := json.Marshal(anomalyWebhookAlert{
+			StationEUI: alert.StationEUI, SlaveID: alert.SlaveID, SensorType: alert.SensorType,
+			Value: alert.Value, Threshold: alert.Threshold, Direction: alert.Direction, CrossedAt: alert.Time,
+		})
+		if err != nil {
+			lg.Error("alert marshal error: %v", err)
+			return
+		}
+		go func() {
+			resp, err := client.Post(url, "application/json", bytes.NewReader(b))
+			if err != nil {
+				lg.Error("alert webhook post error: %v", err)
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode >= 300 {
+				lg.Error("alert webhook post: webhook returned %s", resp.Status)
+			}
+		}()
+	}
+}
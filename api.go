@@ -0,0 +1,220 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//--- HTTP API ---//
+
+const selectSensorTypesSQL = `
+SELECT type_id, name, unit FROM sensor_types ORDER BY type_id;
+`
+
+// Gateway position is the average location reported in rx_metadata over the
+// window; the signal averages are the running ones from gateway_statistics.
+const selectGatewayCoverageSQL = `
+SELECT m.gateway_id, avg(m.longitude), avg(m.latitude), count(DISTINCT m.station_eui),
+       gs.avg_rssi, gs.avg_snr
+FROM measurements m
+LEFT JOIN gateway_statistics gs ON gs.gateway_id = m.gateway_id
+WHERE m.time >= now() - make_interval(hours => $1)
+  AND m.gateway_id IS NOT NULL AND m.latitude IS NOT NULL AND m.longitude IS NOT NULL
+GROUP BY m.gateway_id, gs.avg_rssi, gs.avg_snr
+ORDER BY m.gateway_id;
+`
+
+type sensorTypeJSON struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	Unit string `json:"unit"`
+}
+
+// Bearer token required by the endpoints that change state (ADMIN_API_TOKEN).
+// Without one those endpoints answer 403: they share HEALTH_PORT with
+// /metrics and /healthz, which are usually reachable by more than admins.
+var adminAPIToken string
+
+// Wraps h so it only runs for requests carrying "Authorization: Bearer
+// <ADMIN_API_TOKEN>".
+func requireAdmin(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if adminAPIToken == "" {
+			http.Error(w, "ADMIN_API_TOKEN is not configured", http.StatusForbidden)
+			return
+		}
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(adminAPIToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// Mounts the /api/v1 endpoints on mux.
+func registerAPIRoutes(mux *http.ServeMux, lg Logger, pool *pgxpool.Pool) {
+	mux.HandleFunc("GET /api/v1/sensor-types", func(w http.ResponseWriter, r *http.Request) {
+		rows, err := pool.Query(r.Context(), selectSensorTypesSQL)
+		if err != nil {
+			lg.Error("sensor types query error: %v", err)
+			http.Error(w, "query failed", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		types := []sensorTypeJSON{}
+		for rows.Next() {
+			var t sensorTypeJSON
+			var id int16
+			if err := rows.Scan(&id, &t.Name, &t.Unit); err != nil {
+				lg.Error("sensor types scan error: %v", err)
+				http.Error(w, "query failed", http.StatusInternalServerError)
+				return
+			}
+			t.ID = int(id)
+			types = append(types, t)
+		}
+		if err := rows.Err(); err != nil {
+			lg.Error("sensor types query error: %v", err)
+			http.Error(w, "query failed", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, types)
+	})
+
+	mux.HandleFunc("GET /api/v1/gateways/coverage", handleGatewayCoverage(lg, pool))
+	mux.HandleFunc("GET /api/v1/measurements", handleQueryMeasurements(lg, pool))
+	mux.HandleFunc("GET /api/v1/measurements/geojson", handleMeasurementsGeoJSON(lg, pool))
+	mux.HandleFunc("POST /api/v1/groups", requireAdmin(handleCreateGroup(lg, pool)))
+	mux.HandleFunc("PUT /api/v1/groups/{id}/stations", requireAdmin(handlePutGroupStations(lg, pool)))
+	mux.HandleFunc("GET /api/v1/groups/{id}/latest", handleGroupLatest(lg, pool))
+	mux.HandleFunc("POST /api/v1/rotate-key", requireAdmin(func(w http.ResponseWriter, _ *http.Request) {
+		if apiKeys == nil {
+			http.Error(w, "TTN_API_KEY_LIST is not configured", http.StatusConflict)
+			return
+		}
+		n, err := apiKeys.rotateAndReconnect()
+		if err != nil {
+			lg.Error("key rotation error: %v", err)
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]int{"key": n, "keys": apiKeys.size()})
+	}))
+	mux.HandleFunc("POST /api/v1/ingest", func(w http.ResponseWriter, r *http.Request) {
+		if ingestAPI == nil {
+			http.Error(w, "INGEST_API_TOKENS is not configured", http.StatusNotFound)
+			return
+		}
+		ingestAPI.ServeHTTP(w, r)
+	})
+	mux.HandleFunc("GET /api/v1/connection-history", handleConnectionHistory(lg, pool))
+	mux.HandleFunc("GET /api/v1/stats", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, writerStats.snapshot(time.Now()))
+	})
+	mux.HandleFunc("GET /api/v1/active-alerts", func(w http.ResponseWriter, _ *http.Request) {
+		alerts := []SensorThresholdAlert{}
+		if thresholdAlerts != nil {
+			alerts = thresholdAlerts.activeAlerts()
+		}
+		writeJSON(w, http.StatusOK, alerts)
+	})
+	mux.HandleFunc("PATCH /api/v1/stations/{eui}", requireAdmin(handlePatchStation(lg, pool)))
+	mux.HandleFunc("GET /api/v1/stations/{eui}/latest", handleStationLatest(lg, pool))
+	mux.HandleFunc("GET /api/v1/stations/{eui}/completeness", handleStationCompleteness(lg, pool))
+	mux.HandleFunc("GET /api/v1/stations/{eui}/metadata", handleGetMetadata(lg, pool))
+	mux.HandleFunc("PUT /api/v1/stations/{eui}/metadata", requireAdmin(handlePutMetadata(lg, pool)))
+	mux.HandleFunc("GET /api/v1/stations/{eui}/sensor-labels", handleGetSensorLabels(lg, pool))
+	mux.HandleFunc("PUT /api/v1/stations/{eui}/sensor-labels/{slave}/{type}/{index}", requireAdmin(handlePutSensorLabel(lg, pool)))
+	mux.HandleFunc("DELETE /api/v1/stations/{eui}/sensor-labels/{slave}/{type}/{index}", requireAdmin(handleDeleteSensorLabel(lg, pool)))
+	mux.HandleFunc("GET /api/v1/stations/{eui}/calibrations", handleGetCalibrations(lg, pool))
+	mux.HandleFunc("PUT /api/v1/stations/{eui}/calibrations/{slave}/{type}/{index}", requireAdmin(handlePutCalibration(lg, pool)))
+	mux.HandleFunc("DELETE /api/v1/stations/{eui}/calibrations/{slave}/{type}/{index}", requireAdmin(handleDeleteCalibration(lg, pool)))
+}
+
+type geoJSONFeatureCollection[P any] struct {
+	Type     string              `json:"type"`
+	Features []geoJSONFeature[P] `json:"features"`
+}
+
+type geoJSONFeature[P any] struct {
+	Type       string       `json:"type"`
+	Geometry   geoJSONPoint `json:"geometry"`
+	Properties P            `json:"properties"`
+}
+
+type geoJSONPoint struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"` // lon, lat
+}
+
+type coverageProps struct {
+	GatewayID    string   `json:"gateway_id"`
+	AvgRSSI      *float64 `json:"avg_rssi"`
+	AvgSNR       *float64 `json:"avg_snr"`
+	StationCount int      `json:"station_count"`
+}
+
+// GET /api/v1/gateways/coverage?hours=24: one GeoJSON point per gateway that
+// received uplinks in the window.
+func handleGatewayCoverage(lg Logger, pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hours := 24
+		if v := r.URL.Query().Get("hours"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				http.Error(w, "invalid hours", http.StatusBadRequest)
+				return
+			}
+			hours = n
+		}
+
+		rows, err := pool.Query(r.Context(), selectGatewayCoverageSQL, hours)
+		if err != nil {
+			lg.Error("coverage query error: %v", err)
+			http.Error(w, "query failed", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		fc := geoJSONFeatureCollection[coverageProps]{Type: "FeatureCollection", Features: []geoJSONFeature[coverageProps]{}}
+		for rows.Next() {
+			f := geoJSONFeature[coverageProps]{Type: "Feature", Geometry: geoJSONPoint{Type: "Point"}}
+			var stations int64
+			if err := rows.Scan(&f.Properties.GatewayID, &f.Geometry.Coordinates[0], &f.Geometry.Coordinates[1],
+				&stations, &f.Properties.AvgRSSI, &f.Properties.AvgSNR); err != nil {
+				lg.Error("coverage scan error: %v", err)
+				http.Error(w, "query failed", http.StatusInternalServerError)
+				return
+			}
+			f.Properties.StationCount = int(stations)
+			fc.Features = append(fc.Features, f)
+		}
+		if err := rows.Err(); err != nil {
+			lg.Error("coverage query error: %v", err)
+			http.Error(w, "query failed", http.StatusInternalServerError)
+			return
+		}
+		writeGeoJSON(w, fc)
+	}
+}
+
+func writeGeoJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/geo+json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
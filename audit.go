@@ -0,0 +1,275 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//--- Config audit ---//
+
+// One problem found by -config-audit.
+type auditFinding struct {
+	Level string // "error" or "warning"
+	Vars  []string
+	Msg   string
+}
+
+// Checks the configuration for settings that conflict, are ignored in the
+// chosen mode or can't work. Errors would stop the ingestor at startup (or
+// break it later); warnings are settings that likely don't do what was meant.
+func (c *Config) audit() []auditFinding {
+	var out []auditFinding
+	errorf := func(vars []string, format string, args ...any) {
+		out = append(out, auditFinding{"error", vars, fmt.Sprintf(format, args...)})
+	}
+	warnf := func(vars []string, format string, args ...any) {
+		out = append(out, auditFinding{"warning", vars, fmt.Sprintf(format, args...)})
+	}
+	vars := func(names ...string) []string { return names }
+
+	if err := errors.Join(c.validate(), c.validateIngest()); err != nil {
+		errorf(nil, "%v", err)
+	}
+
+	switch c.Mode {
+	case "mqtt":
+	case "webhook":
+		if c.MQTTHost != "" || c.MQTTTopic != "" {
+			warnf(vars("MODE", "MQTT_HOST", "MQTT_TOPIC"), "MQTT settings are ignored in webhook mode")
+		}
+		if c.WebhookSecret == "" && c.WebhookSigningKey == "" {
+			warnf(vars("WEBHOOK_SECRET"), "webhook requests are not authenticated")
+		}
+		if c.AlertMQTTTopic != "" {
+			warnf(vars("ALERT_MQTT_TOPIC", "MODE"), "alerts are only published in mqtt mode")
+		}
+		if c.WatchdogTimeoutMinutes > 0 {
+			warnf(vars("WATCHDOG_TIMEOUT_MINUTES", "MODE"), "the watchdog only runs in mqtt mode")
+		}
+	case "kafka":
+		if c.MQTTHost != "" || c.MQTTTopic != "" {
+			warnf(vars("MODE", "MQTT_HOST", "MQTT_TOPIC"), "MQTT settings are ignored in kafka mode")
+		}
+		if c.AlertMQTTTopic != "" {
+			warnf(vars("ALERT_MQTT_TOPIC", "MODE"), "alerts are only published in mqtt mode")
+		}
+		if c.WatchdogTimeoutMinutes > 0 {
+			warnf(vars("WATCHDOG_TIMEOUT_MINUTES", "MODE"), "the watchdog only runs in mqtt mode")
+		}
+		if sinkListHas(c.SinkFanout, "kafka") && c.KafkaSinkTopic == c.KafkaTopic {
+			errorf(vars("KAFKA_TOPIC", "KAFKA_SINK_TOPIC"), "the kafka sink would feed its own input")
+		}
+	case "udp":
+		if c.MQTTHost != "" || c.MQTTTopic != "" {
+			warnf(vars("MODE", "MQTT_HOST", "MQTT_TOPIC"), "MQTT settings are ignored in udp mode")
+		}
+		if c.AlertMQTTTopic != "" {
+			warnf(vars("ALERT_MQTT_TOPIC", "MODE"), "alerts are only published in mqtt mode")
+		}
+		if c.WatchdogTimeoutMinutes > 0 {
+			warnf(vars("WATCHDOG_TIMEOUT_MINUTES", "MODE"), "the watchdog only runs in mqtt mode")
+		}
+		if c.RawDecoders == "" {
+			warnf(vars("RAW_DECODERS", "MODE"), "udp uplinks carry no decoded payload; without RAW_DECODERS no readings are stored")
+		}
+	default:
+		errorf(vars("MODE"), "unknown mode %q (expecting mqtt, webhook, kafka or udp)", c.Mode)
+	}
+
+	if c.TTNAPIKeyExpiresAt != "" {
+		if t, err := time.Parse(time.RFC3339, c.TTNAPIKeyExpiresAt); err != nil {
+			errorf(vars("TTN_API_KEY_EXPIRES_AT"), "not an RFC3339 time: %v", err)
+		} else if time.Until(t) < keyExpiryLead {
+			warnf(vars("TTN_API_KEY_EXPIRES_AT"), "the key expires within %s (or already has)", keyExpiryLead)
+		}
+		if c.TTNAPIKeyList == "" {
+			warnf(vars("TTN_API_KEY_EXPIRES_AT", "TTN_API_KEY_LIST"), "no other key to rotate to before expiry")
+		}
+	}
+
+	if c.NetworkServer != "ttn" && c.NetworkServer != "thingpark" {
+		errorf(vars("NETWORK_SERVER"), "unknown network server %q (expecting ttn or thingpark)", c.NetworkServer)
+	}
+	if c.NetworkServer == "thingpark" && c.RawDecoders == "" {
+		warnf(vars("NETWORK_SERVER", "RAW_DECODERS"), "ThingPark uplinks carry only the raw payload; without RAW_DECODERS nothing is stored")
+	}
+
+	if c.Mode == "mqtt" {
+		switch c.MQTTProtocol {
+		case "mqtt", "ws":
+			if c.MQTTUseAuth && !c.MQTTAWSIoTCore {
+				warnf(vars("MQTT_PROTOCOL", "MQTT_USE_AUTH"), "credentials are sent unencrypted over %s; use mqtts or wss", c.MQTTProtocol)
+			}
+		case "mqtts", "wss":
+		default:
+			errorf(vars("MQTT_PROTOCOL"), "unknown protocol %q (expecting mqtt, mqtts, ws or wss)", c.MQTTProtocol)
+		}
+		if c.MQTTAuthMethod != "plain" {
+			errorf(vars("MQTT_AUTH_METHOD"), "only plain is supported by the MQTT 3.1.1 client")
+		}
+		if c.MQTTAWSIoTCore {
+			if c.MQTTHost != "" {
+				warnf(vars("MQTT_AWS_IOT_CORE", "MQTT_HOST"), "MQTT_HOST is ignored; AWS_IOT_ENDPOINT is used")
+			}
+			if c.MQTTUsername != "" || c.MQTTPassword != "" || c.TTNAPIKeyList != "" {
+				warnf(vars("MQTT_AWS_IOT_CORE", "MQTT_USERNAME", "MQTT_PASSWORD", "TTN_API_KEY_LIST"), "MQTT credentials are ignored; the connection is signed with the AWS keys")
+			}
+		}
+		if c.MQTTPassword != "" && c.TTNAPIKeyList != "" {
+			warnf(vars("MQTT_PASSWORD", "TTN_API_KEY_LIST"), "both set; MQTT_PASSWORD is ignored in favour of the key list")
+		}
+		if c.TTNRegionHostFallback != "" {
+			if c.MQTTAWSIoTCore || c.MultiAppConfigPath != "" {
+				warnf(vars("TTN_REGION_HOST_FALLBACK", "MQTT_AWS_IOT_CORE", "MULTI_APP_CONFIG_PATH"), "the fallback host only applies to the MQTT_HOST connection")
+			}
+			if strings.EqualFold(c.TTNRegionHostFallback, c.MQTTHost) {
+				warnf(vars("TTN_REGION_HOST_FALLBACK", "MQTT_HOST"), "fallback is the same host as MQTT_HOST")
+			}
+			if c.FallbackRetries < 1 {
+				errorf(vars("FALLBACK_RETRIES"), "must be at least 1")
+			}
+		}
+		if c.MQTTSharedSubscriptionGroup != "" {
+			warnf(vars("MQTT_SHARED_SUBSCRIPTION_GROUP"), "shared subscriptions are an MQTT 5 feature; check the broker accepts $share on 3.1.1")
+		}
+	}
+
+	if c.GRPCAddr != "" {
+		if c.GRPCToken == "" && c.GRPCTLSCertFile == "" {
+			errorf(vars("GRPC_ADDR", "GRPC_TOKEN", "GRPC_TLS_CERT_FILE"), "the gRPC stream needs a token, TLS or both")
+		}
+		if (c.GRPCTLSCertFile == "") != (c.GRPCTLSKeyFile == "") {
+			errorf(vars("GRPC_TLS_CERT_FILE", "GRPC_TLS_KEY_FILE"), "set both or neither")
+		}
+		if c.GRPCToken != "" && c.GRPCTLSCertFile == "" {
+			warnf(vars("GRPC_TOKEN", "GRPC_TLS_CERT_FILE"), "the token is sent in plaintext without TLS")
+		}
+	}
+	if c.PromEUIPrefixLen < 0 || c.PromEUIPrefixLen > 16 {
+		errorf(vars("PROM_EUI_PREFIX_LEN"), "must be between 0 (full EUI) and 16")
+	} else if c.PromEUIPrefixLen == 0 || c.PromEUIPrefixLen > 8 {
+		warnf(vars("PROM_EUI_PREFIX_LEN"), "one metric series per station (or close to it); cardinality grows with the fleet")
+	}
+
+	if c.PGPasswordFile != "" {
+		if u, err := url.Parse(c.PGDSN); err == nil {
+			if _, ok := u.User.Password(); ok {
+				warnf(vars("PG_DSN", "PG_PASSWORD_FILE"), "both carry a password; PG_PASSWORD_FILE wins")
+			}
+		}
+	}
+
+	if c.KafkaBrokers != "" && !sinkListHas(c.SinkFanout, "kafka") {
+		warnf(vars("KAFKA_BROKERS", "SINK_FANOUT"), "KAFKA_BROKERS is set but kafka is not in SINK_FANOUT")
+	}
+	for _, s := range strings.Split(c.SinkFanout, ",") {
+		if s = strings.TrimSpace(s); s != "postgres" && s != "kafka" && s != "newrelic" {
+			errorf(vars("SINK_FANOUT"), "unknown sink %q (expecting postgres, kafka or newrelic)", s)
+		}
+	}
+	if sinkListHas(c.SinkFanout, "newrelic") {
+		if _, ok := newRelicEventHosts[strings.ToUpper(c.NewRelicRegion)]; !ok {
+			errorf(vars("NEW_RELIC_REGION"), "unknown region %q (expecting US or EU)", c.NewRelicRegion)
+		}
+		if _, err := strconv.ParseUint(c.NewRelicAccountID, 10, 64); c.NewRelicAccountID != "" && err != nil {
+			errorf(vars("NEW_RELIC_ACCOUNT_ID"), "not a numeric account ID: %q", c.NewRelicAccountID)
+		}
+	}
+
+	if _, err := newEMASmoother(c.SmoothSensorTypes, c.SmoothAlpha); err != nil {
+		errorf(vars("SMOOTH_SENSOR_TYPES", "SMOOTH_ALPHA"), "%v", err)
+	}
+	if err := registerRawDecoders(c.RawDecoders); err != nil {
+		errorf(vars("RAW_DECODERS"), "%v", err)
+	}
+	if c.MultiAppConfigPath != "" {
+		if c.Mode != "mqtt" {
+			warnf(vars("MULTI_APP_CONFIG_PATH", "MODE"), "only used in mqtt mode")
+		}
+		if _, err := loadMultiAppConfig(c.MultiAppConfigPath, c.MQTTHost); err != nil {
+			errorf(vars("MULTI_APP_CONFIG_PATH"), "%v", err)
+		}
+		if c.MQTTAWSIoTCore {
+			errorf(vars("MULTI_APP_CONFIG_PATH", "MQTT_AWS_IOT_CORE"), "the applications connect to TTN directly, not through AWS IoT")
+		}
+		if c.TTNAPIKeyList != "" || c.TTNAPIKeyExpiresAt != "" {
+			warnf(vars("MULTI_APP_CONFIG_PATH", "TTN_API_KEY_LIST", "TTN_API_KEY_EXPIRES_AT"), "key rotation is not applied; each application uses its api_key")
+		}
+		if c.MQTTDownlinkTopic != "" {
+			warnf(vars("MULTI_APP_CONFIG_PATH", "MQTT_DOWNLINK_TOPIC"), "downlink events are not subscribed to with several applications")
+		}
+	}
+
+	if c.ThresholdConfigPath != "" {
+		if _, err := loadThresholds(c.ThresholdConfigPath); err != nil {
+			errorf(vars("THRESHOLD_CONFIG_PATH"), "%v", err)
+		}
+	} else {
+		if c.AlertMQTTTopic != "" {
+			warnf(vars("ALERT_MQTT_TOPIC", "THRESHOLD_CONFIG_PATH"), "no thresholds configured, so no alerts are published")
+		}
+		if c.AnomalyWebhookURL != "" {
+			warnf(vars("ANOMALY_WEBHOOK_URL", "THRESHOLD_CONFIG_PATH"), "no thresholds configured, so no alerts are posted")
+		}
+	}
+
+	if c.RetryPoolMaxConns < 1 {
+		errorf(vars("RETRY_POOL_MAX_CONNS"), "the retry queue needs at least one connection")
+	}
+	if c.BatchMaxSize*measurementColumns > math.MaxUint16 {
+		errorf(vars("BATCH_MAX_SIZE"), "at most %d rows fit in one statement", math.MaxUint16/measurementColumns)
+	}
+	if c.ErrorNotifyWebhookURL != "" && c.ErrorNotifyThreshold < 1 {
+		warnf(vars("ERROR_NOTIFY_THRESHOLD"), "a threshold below 1 notifies on every error")
+	}
+	if c.TransformEndpointURL != "" && c.TransformTimeoutMS <= 0 {
+		errorf(vars("TRANSFORM_TIMEOUT_MS"), "must be positive, or every transform times out")
+	}
+	if c.EnablePprof {
+		warnf(vars("ENABLE_PPROF"), "pprof is exposed on HEALTH_PORT; keep the port private")
+	}
+	return out
+}
+
+// Prints the audit report for cfg (loadErr holds malformed values) and
+// returns the exit code: 1 if there are errors, 0 for warnings only.
+func runConfigAudit(w io.Writer, cfg *Config, loadErr error) int {
+	var findings []auditFinding
+	if loadErr != nil {
+		for _, err := range splitJoined(loadErr) {
+			findings = append(findings, auditFinding{Level: "error", Msg: err.Error()})
+		}
+	}
+	findings = append(findings, cfg.audit()...)
+
+	errCount := 0
+	for _, f := range findings {
+		if f.Level == "error" {
+			errCount++
+		}
+		if len(f.Vars) > 0 {
+			fmt.Fprintf(w, "%-7s [%s] %s\n", strings.ToUpper(f.Level), strings.Join(f.Vars, ", "), f.Msg)
+		} else {
+			fmt.Fprintf(w, "%-7s %s\n", strings.ToUpper(f.Level), f.Msg)
+		}
+	}
+	fmt.Fprintf(w, "%d errors, %d warnings\n", errCount, len(findings)-errCount)
+	if errCount > 0 {
+		return 1
+	}
+	return 0
+}
+
+// Unwraps an errors.Join result into its parts.
+func splitJoined(err error) []error {
+	if j, ok := err.(interface{ Unwrap() []error }); ok {
+		return j.Unwrap()
+	}
+	return []error{err}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+//--- AWS IoT Core ---//
+
+// Signing name of the AWS IoT data plane.
+const awsIoTService = "iotdevicegateway"
+
+// Points opts at the AWS IoT Core endpoint over MQTT-over-WebSocket. Every
+// connection attempt presigns a fresh SigV4 URL, so reconnects keep working
+// after an earlier signature has expired.
+func applyAWSIoT(opts *mqtt.ClientOptions, cfg *Config) {
+	creds := credentials.NewStaticCredentialsProvider(cfg.AWSAccessKeyID, cfg.AWSSecretAccessKey, cfg.AWSSessionToken)
+	opts.AddBroker(mqttBrokerURL(cfg))
+	opts.SetTLSConfig(&tls.Config{MinVersion: tls.VersionTLS12})
+	opts.SetCustomOpenConnectionFn(func(_ *url.URL, o mqtt.ClientOptions) (net.Conn, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), o.ConnectTimeout)
+		defer cancel()
+		signed, err := presignAWSIoTURL(ctx, creds, cfg.AWSRegion, cfg.AWSIoTEndpoint)
+		if err != nil {
+			return nil, err
+		}
+		return mqtt.NewWebsocket(signed, o.TLSConfig, o.ConnectTimeout, o.HTTPHeaders, o.WebsocketOptions)
+	})
+}
+
+// Returns the wss:// URL for endpoint with SigV4 query authentication. IoT
+// expects the session token to be appended after signing rather than signed.
+func presignAWSIoTURL(ctx context.Context, provider aws.CredentialsProvider, region, endpoint string) (string, error) {
+	creds, err := provider.Retrieve(ctx)
+	if err != nil {
+		return "", err
+	}
+	token := creds.SessionToken
+	creds.SessionToken = ""
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+endpoint+"/mqtt", nil)
+	if err != nil {
+		return "", err
+	}
+	emptyHash := sha256.Sum256(nil)
+	signed, _, err := v4.NewSigner().PresignHTTP(ctx, creds, req, hex.EncodeToString(emptyHash[:]),
+		awsIoTService, region, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(signed)
+	if err != nil {
+		return "", err
+	}
+	u.Scheme = "wss"
+	if token != "" {
+		u.RawQuery += "&X-Amz-Security-Token=" + url.QueryEscape(token)
+	}
+	return u.String(), nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//--- Batched measurement writes ---//
+
+// When set, the Store hands measurement rows to this instead of inserting
+// them one by one.
+var batcher *MessageBatcher
+
+// Columns of insertMeasurementSQL; a batch statement repeats them per row.
+const measurementColumns = 14
+
+// Limit on one batch write, and on re-queueing its rows after a failure.
+const batchWriteTimeout = 10 * time.Second
+
+// MessageBatcher accumulates measurement rows across uplinks and writes them
+// with one multi-row INSERT once maxSize rows are pending or the oldest has
+// waited maxWait, whichever comes first. Failed batches go to the retry
+// queue row by row.
+//
+// A batch holds rows of many devices, so it is never written with the
+// context of the uplink that happened to fill it: a webhook client going away
+// must not fail everyone else's rows.
+type MessageBatcher struct {
+	log     Logger
+	pool    *pgxpool.Pool
+	maxSize int
+	maxWait time.Duration
+
+	mu    sync.Mutex
+	rows  []MeasurementRow
+	timer *time.Timer
+}
+
+func newMessageBatcher(lg Logger, pool *pgxpool.Pool, maxSize int, maxWait time.Duration) *MessageBatcher {
+	return &MessageBatcher{log: lg, pool: pool, maxSize: maxSize, maxWait: maxWait}
+}
+
+// Queues r, flushing in the caller's goroutine if the batch is full.
+func (b *MessageBatcher) add(ctx context.Context, r MeasurementRow) {
+	b.mu.Lock()
+	b.rows = append(b.rows, r)
+	if len(b.rows) == 1 {
+		b.timer = time.AfterFunc(b.maxWait, func() {
+			ctx, cancel := context.WithTimeout(context.Background(), batchWriteTimeout)
+			defer cancel()
+			b.flush(ctx)
+		})
+	}
+	var rows []MeasurementRow
+	if len(b.rows) >= b.maxSize {
+		rows = b.take()
+	}
+	b.mu.Unlock()
+
+	if rows != nil {
+		wctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), batchWriteTimeout)
+		defer cancel()
+		b.write(wctx, rows)
+	}
+}
+
+// Writes whatever is pending. Called by the timer and on shutdown, with a
+// context that outlives the ingest context.
+func (b *MessageBatcher) flush(ctx context.Context) {
+	b.mu.Lock()
+	rows := b.take()
+	b.mu.Unlock()
+	if len(rows) > 0 {
+		b.write(ctx, rows)
+	}
+}
+
+// Detaches the pending rows. Callers must hold mu.
+func (b *MessageBatcher) take() []MeasurementRow {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	rows := b.rows
+	b.rows = nil
+	return rows
+}
+
+func (b *MessageBatcher) write(ctx context.Context, rows []MeasurementRow) {
+	start := time.Now()
+	if _, err := b.pool.Exec(ctx, batchInsertMeasurementSQL(len(rows)), batchInsertMeasurementArgs(rows)...); err != nil {
+		stats.DBErrors.Add(1)
+		writerStats.recordErrors(len(rows))
+		b.log.Error("batch insert error: %v (%d rows)", err, len(rows))
+		notifyError("db", rows[0].StationEUI, err)
+		if retryQueue != nil {
+			// The write may have failed by running out of ctx's time.
+			qctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), batchWriteTimeout)
+			defer cancel()
+			for _, r := range rows {
+				retryQueue.Enqueue(qctx, r, err)
+			}
+		}
+		return
+	}
+	stats.Measurements.Add(uint64(len(rows)))
+	writerStats.recordInserts(len(rows))
+	for _, r := range rows {
+		cacheMeasurement(r)
+		broadcastMeasurement(r)
+	}
+	b.log.Debug("batch inserted %d measurements in %s", len(rows), time.Since(start))
+
+	// Anomaly checks are pipelined in one round trip as well.
+	batch := &pgx.Batch{}
+	for _, r := range rows {
+		batch.Queue(insertAnomalySQL, r.Time, r.StationEUI, r.SlaveID, r.SensorType, r.Value, anomalyZScoreThreshold)
+	}
+	res := b.pool.SendBatch(ctx, batch)
+	defer res.Close()
+	for _, r := range rows {
+		if _, err := res.Exec(); err != nil {
+			stats.DBErrors.Add(1)
+			b.log.Error("anomaly check error: %v (eui: %s slave: %d type: %d)", err, r.StationEUI, r.SlaveID, r.SensorType)
+		}
+	}
+}
+
+// insertMeasurementSQL with n rows of placeholders.
+func batchInsertMeasurementSQL(n int) string {
+	var sb strings.Builder
+	sb.WriteString(`
+INSERT INTO measurements(
+  time, station_eui, station_devid, slave_id, sensor_type, sensor_index, value, format, gateway_id, latitude, longitude, raw_value, frm_payload, message_id
+) VALUES `)
+	for i := range n {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteByte('(')
+		for c := range measurementColumns {
+			if c > 0 {
+				sb.WriteByte(',')
+			}
+			fmt.Fprintf(&sb, "$%d", i*measurementColumns+c+1)
+		}
+		sb.WriteByte(')')
+	}
+	sb.WriteString("\nON CONFLICT DO NOTHING;\n")
+	return sb.String()
+}
+
+func batchInsertMeasurementArgs(rows []MeasurementRow) []any {
+	args := make([]any, 0, len(rows)*measurementColumns)
+	for _, r := range rows {
+		args = append(args,
+			r.Time, r.StationEUI, r.StationDevID, r.SlaveID, r.SensorType, r.SensorIndex, r.Value, r.Format,
+			r.GatewayID, r.Latitude, r.Longitude, r.RawValue, r.FrmPayload, r.messageID(),
+		)
+	}
+	return args
+}
+
+// Number of rows waiting for the next flush; 0 when batching is off.
+func (b *MessageBatcher) pending() int {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.rows)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"cmp"
+	"container/list"
+	"slices"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+//--- Latest measurement cache ---//
+
+// When set (CACHE_STATIONS > 0), GET /api/v1/stations/{eui}/latest serves
+// the measurements from memory for stations it has seen.
+var measurementCache *MeasurementCache
+
+var measurementCacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "ingestor_measurement_cache_requests_total",
+	Help: "Latest-measurement lookups by result (hit or miss).",
+}, []string{"result"})
+
+func init() {
+	prometheus.MustRegister(measurementCacheRequests)
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "ingestor_measurement_cache_hit_ratio",
+		Help: "Share of latest-measurement lookups served from the cache since start.",
+	}, func() float64 {
+		if measurementCache == nil {
+			return 0
+		}
+		return measurementCache.hitRatio()
+	}))
+}
+
+// Key of one series of a station.
+type readingKey struct {
+	slaveID, sensorType, sensorIndex int
+}
+
+type cachedStation struct {
+	eui string
+	// Newest reading per series, least recently updated first; at most
+	// perStation entries.
+	readings []latestMeasurementJSON
+	// Set once the readings were seeded from the DB and none has been
+	// evicted since, so they cover every series of the station.
+	complete bool
+}
+
+// MeasurementCache keeps the newest reading of each series for the most
+// recently used stations (an LRU of at most maxStations), holding up to
+// perStation readings each. It is fed by every successful insert; a station
+// is only served from memory once it was seeded from the DB, since inserts
+// alone can't tell whether older series exist.
+type MeasurementCache struct {
+	maxStations, perStation int
+
+	mu       sync.Mutex
+	lru      *list.List // of *cachedStation, most recently used first
+	stations map[string]*list.Element
+	hits     uint64
+	misses   uint64
+}
+
+func newMeasurementCache(maxStations, perStation int) *MeasurementCache {
+	return &MeasurementCache{
+		maxStations: maxStations, perStation: perStation,
+		lru: list.New(), stations: map[string]*list.Element{},
+	}
+}
+
+// Returns the station's entry, creating it (and evicting the least recently
+// used station) if needed. Callers hold c.mu.
+func (c *MeasurementCache) station(eui string) *cachedStation {
+	if el, ok := c.stations[eui]; ok {
+		c.lru.MoveToFront(el)
+		return el.Value.(*cachedStation)
+	}
+	s := &cachedStation{eui: eui}
+	c.stations[eui] = c.lru.PushFront(s)
+	if c.lru.Len() > c.maxStations {
+		old := c.lru.Remove(c.lru.Back()).(*cachedStation)
+		delete(c.stations, old.eui)
+	}
+	return s
+}
+
+// Records a reading of s, keeping only the newest one per series.
+func (c *MeasurementCache) put(s *cachedStation, m latestMeasurementJSON) {
+	k := readingKey{m.SlaveID, m.SensorType, m.SensorIndex}
+	i := slices.IndexFunc(s.readings, func(r latestMeasurementJSON) bool {
+		return readingKey{r.SlaveID, r.SensorType, r.SensorIndex} == k
+	})
+	if i >= 0 {
+		if s.readings[i].Time.After(m.Time) {
+			return
+		}
+		s.readings = slices.Delete(s.readings, i, i+1)
+	}
+	s.readings = append(s.readings, m)
+	if len(s.readings) > c.perStation {
+		s.readings = slices.Delete(s.readings, 0, 1)
+		s.complete = false
+	}
+}
+
+// Records a stored measurements row.
+func (c *MeasurementCache) add(r MeasurementRow) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.put(c.station(r.StationEUI), latestMeasurementJSON{
+		Time: r.Time, SlaveID: r.SlaveID, SensorType: r.SensorType, SensorIndex: r.SensorIndex, Value: r.Value,
+	})
+}
+
+// Seeds a station with its latest readings as read from the DB.
+func (c *MeasurementCache) fill(eui string, ms []latestMeasurementJSON) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.station(eui)
+	s.complete = len(ms) <= c.perStation
+	for _, m := range ms {
+		c.put(s, m)
+	}
+}
+
+// Returns the station's latest readings in selectLatestMeasurementsSQL
+// order, or false if the station isn't fully cached.
+func (c *MeasurementCache) latest(eui string) ([]latestMeasurementJSON, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.stations[eui]
+	if !ok || !el.Value.(*cachedStation).complete {
+		c.misses++
+		measurementCacheRequests.WithLabelValues("miss").Inc()
+		return nil, false
+	}
+	c.hits++
+	measurementCacheRequests.WithLabelValues("hit").Inc()
+	c.lru.MoveToFront(el)
+	ms := append([]latestMeasurementJSON{}, el.Value.(*cachedStation).readings...)
+	slices.SortFunc(ms, func(a, b latestMeasurementJSON) int {
+		return cmp.Or(cmp.Compare(a.SlaveID, b.SlaveID), cmp.Compare(a.SensorType, b.SensorType),
+			cmp.Compare(a.SensorIndex, b.SensorIndex))
+	})
+	return ms, true
+}
+
+func (c *MeasurementCache) hitRatio() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.hits+c.misses == 0 {
+		return 0
+	}
+	return float64(c.hits) / float64(c.hits+c.misses)
+}
+
+// Records r in the cache, if there is one.
+func cacheMeasurement(r MeasurementRow) {
+	if measurementCache != nil {
+		measurementCache.add(r)
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//--- Sensor calibration ---//
+
+const selectCalibrationsSQL = `
+SELECT slave_id, sensor_type, sensor_index, "offset", scale, calibrated_at
+FROM sensor_calibration
+WHERE station_eui = $1
+ORDER BY slave_id, sensor_type, sensor_index;
+`
+
+const upsertCalibrationSQL = `
+INSERT INTO sensor_calibration(station_eui, slave_id, sensor_type, sensor_index, "offset", scale, calibrated_at)
+VALUES ($1,$2,$3,$4,$5,$6,$7)
+ON CONFLICT (station_eui, slave_id, sensor_type, sensor_index) DO UPDATE
+SET "offset" = EXCLUDED."offset", scale = EXCLUDED.scale, calibrated_at = EXCLUDED.calibrated_at;
+`
+
+const deleteCalibrationSQL = `
+DELETE FROM sensor_calibration
+WHERE station_eui = $1 AND slave_id = $2 AND sensor_type = $3 AND sensor_index = $4;
+`
+
+// Calibrates the readings of every uplink in ingestParsed. Set whenever the
+// ingestor has a database.
+var calibrations *calibrationCache
+
+type sensorCalibrationJSON struct {
+	SlaveID      int        `json:"slave_id"`
+	SensorType   int        `json:"sensor_type"`
+	SensorIndex  int        `json:"sensor_index"`
+	Offset       float64    `json:"offset"`
+	Scale        *float64   `json:"scale"`
+	CalibratedAt *time.Time `json:"calibrated_at"`
+}
+
+func querySensorCalibrations(ctx context.Context, pool *pgxpool.Pool, eui string) ([]sensorCalibrationJSON, error) {
+	rows, err := pool.Query(ctx, selectCalibrationsSQL, eui)
+	if err != nil {
+		return nil, err
+	}
+	cals := []sensorCalibrationJSON{}
+	var c sensorCalibrationJSON
+	var sensorType, sensorIndex int16
+	var scale float64
+	_, err = pgx.ForEachRow(rows, []any{&c.SlaveID, &sensorType, &sensorIndex, &c.Offset, &scale, &c.CalibratedAt}, func() error {
+		c.SensorType, c.SensorIndex = int(sensorType), int(sensorIndex)
+		s := scale
+		c.Scale = &s
+		cals = append(cals, c)
+		return nil
+	})
+	return cals, err
+}
+
+// Linear correction of one sensor position.
+type calibration struct {
+	offset, scale float64
+}
+
+func (c calibration) apply(raw float64) float64 {
+	return raw*c.scale + c.offset
+}
+
+type cachedCalibrations struct {
+	loadedAt time.Time
+	byKey    map[readingKey]calibration
+}
+
+// calibrationCache keeps the calibrations of each station for ttl so that
+// they are read from the database at most once per ttl per station. A ttl of
+// 0 reads them for every uplink.
+type calibrationCache struct {
+	log  Logger
+	pool *pgxpool.Pool
+	ttl  time.Duration
+
+	mu        sync.Mutex
+	byStation map[string]cachedCalibrations
+}
+
+func newCalibrationCache(lg Logger, pool *pgxpool.Pool, ttl time.Duration) *calibrationCache {
+	return &calibrationCache{log: lg, pool: pool, ttl: ttl, byStation: make(map[string]cachedCalibrations)}
+}
+
+// Replaces the Value of each reading of p that has a calibration with
+// raw * scale + offset, keeping the reading as received in Raw.
+func (c *calibrationCache) apply(ctx context.Context, p *Parsed) error {
+	cals, err := c.forStation(ctx, p.StationEUI)
+	if err != nil {
+		return err
+	}
+	for i := range p.Msg.DecodedPayload.Slaves {
+		s := &p.Msg.DecodedPayload.Slaves[i]
+		for j := range s.Sensors {
+			m := &s.Sensors[j]
+			cal, ok := cals[readingKey{s.ID, m.Type, m.Index}]
+			if !ok || m.Raw != nil {
+				continue
+			}
+			raw := m.Value
+			m.Value, m.Raw = cal.apply(raw), &raw
+		}
+	}
+	return nil
+}
+
+// Calibrations of the station, keyed by sensor position. When the database
+// can't be read, expired cached calibrations are used rather than none.
+func (c *calibrationCache) forStation(ctx context.Context, eui string) (map[readingKey]calibration, error) {
+	now := time.Now()
+	c.mu.Lock()
+	cached, ok := c.byStation[eui]
+	c.mu.Unlock()
+	if ok && now.Sub(cached.loadedAt) < c.ttl {
+		return cached.byKey, nil
+	}
+
+	cals, err := querySensorCalibrations(ctx, c.pool, eui)
+	if err != nil {
+		if ok {
+			c.log.Warn("calibration lookup error, using calibrations cached %s ago: %v (eui: %s)", now.Sub(cached.loadedAt).Round(time.Second), err, eui)
+			return cached.byKey, nil
+		}
+		return nil, err
+	}
+	byKey := make(map[readingKey]calibration, len(cals))
+	for _, cal := range cals {
+		byKey[readingKey{cal.SlaveID, cal.SensorType, cal.SensorIndex}] = calibration{offset: cal.Offset, scale: *cal.Scale}
+	}
+	if c.ttl > 0 {
+		c.mu.Lock()
+		c.byStation[eui] = cachedCalibrations{loadedAt: now, byKey: byKey}
+		c.mu.Unlock()
+	}
+	return byKey, nil
+}
+
+// Drops the cached calibrations of eui after they were changed through the
+// API, so the change applies from the next uplink on.
+func (c *calibrationCache) invalidate(eui string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	delete(c.byStation, eui)
+	c.mu.Unlock()
+}
+
+// GET /api/v1/stations/{eui}/calibrations
+func handleGetCalibrations(lg Logger, pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		eui := stationEUIParam(w, r)
+		if eui == "" {
+			return
+		}
+		cals, err := querySensorCalibrations(r.Context(), pool, eui)
+		if err != nil {
+			lg.Error("calibrations query error: %v", err)
+			http.Error(w, "query failed", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, cals)
+	}
+}
+
+// PUT /api/v1/stations/{eui}/calibrations/{slave}/{type}/{index} with
+// {"offset": -0.4, "scale": 1.02, "calibrated_at": "2025-04-01T00:00:00Z"};
+// creates or replaces the calibration of that sensor position. scale
+// defaults to 1 and calibrated_at to now.
+func handlePutCalibration(lg Logger, pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		eui := stationEUIParam(w, r)
+		if eui == "" {
+			return
+		}
+		k, ok := sensorKeyParams(w, r)
+		if !ok {
+			return
+		}
+		var c sensorCalibrationJSON
+		if !readJSONBody(w, r, maxMetadataBody, &c) {
+			return
+		}
+		if c.Scale == nil {
+			one := 1.0
+			c.Scale = &one
+		}
+		if c.CalibratedAt == nil {
+			now := time.Now().UTC()
+			c.CalibratedAt = &now
+		}
+		c.SlaveID, c.SensorType, c.SensorIndex = k.slaveID, k.sensorType, k.sensorIndex
+
+		if _, err := pool.Exec(r.Context(), upsertCalibrationSQL, eui, c.SlaveID, c.SensorType, c.SensorIndex, c.Offset, *c.Scale, c.CalibratedAt); err != nil {
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+				http.Error(w, "station not found", http.StatusNotFound)
+				return
+			}
+			lg.Error("calibration update error (eui: %s): %v", eui, err)
+			http.Error(w, "update failed", http.StatusInternalServerError)
+			return
+		}
+		calibrations.invalidate(eui)
+		writeJSON(w, http.StatusOK, c)
+	}
+}
+
+// DELETE /api/v1/stations/{eui}/calibrations/{slave}/{type}/{index}
+func handleDeleteCalibration(lg Logger, pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		eui := stationEUIParam(w, r)
+		if eui == "" {
+			return
+		}
+		k, ok := sensorKeyParams(w, r)
+		if !ok {
+			return
+		}
+		tag, err := pool.Exec(r.Context(), deleteCalibrationSQL, eui, k.slaveID, k.sensorType, k.sensorIndex)
+		if err != nil {
+			lg.Error("calibration delete error (eui: %s): %v", eui, err)
+			http.Error(w, "delete failed", http.StatusInternalServerError)
+			return
+		}
+		if tag.RowsAffected() == 0 {
+			http.Error(w, "calibration not found", http.StatusNotFound)
+			return
+		}
+		calibrations.invalidate(eui)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//--- Data completeness ---//
+
+// Days (including today) recomputed on every run, so late or retried
+// inserts are picked up.
+const completenessDays = 7
+
+// Expected uplinks per day come from stations.expected_uplink_interval_seconds;
+// stations without one get NULL expected and completeness rather than a
+// guess. Today only counts the part of the day already elapsed. Days before
+// the station was first seen are skipped.
+const refreshCompletenessSQL = `
+INSERT INTO data_completeness AS dc (station_eui, date, expected_messages, received_messages, completeness_pct, updated_at)
+SELECT s.station_eui, d.day::date, e.expected, f.received,
+       CASE WHEN e.expected > 0 THEN least(100, 100.0 * f.received / e.expected) END,
+       now()
+FROM stations s
+CROSS JOIN generate_series(current_date - ($1::int - 1), current_date, INTERVAL '1 day') AS d(day)
+CROSS JOIN LATERAL (
+  SELECT CASE WHEN s.expected_uplink_interval_seconds IS NULL THEN NULL
+         ELSE floor(extract(epoch FROM least(now(), (d.day + INTERVAL '1 day')::timestamptz) - d.day::timestamptz)
+                    / greatest(s.expected_uplink_interval_seconds, 1))::int
+         END AS expected
+) e
+CROSS JOIN LATERAL (
+  SELECT count(DISTINCT m.time)::int AS received
+  FROM measurements m
+  WHERE m.station_eui = s.station_eui
+    AND m.time >= d.day::timestamptz AND m.time < (d.day + INTERVAL '1 day')::timestamptz
+) f
+WHERE d.day::date >= s.created_at::date
+ON CONFLICT (station_eui, date) DO UPDATE
+SET expected_messages = EXCLUDED.expected_messages,
+    received_messages = EXCLUDED.received_messages,
+    completeness_pct  = EXCLUDED.completeness_pct,
+    updated_at        = EXCLUDED.updated_at;
+`
+
+const updateExpectedIntervalSQL = `
+UPDATE stations SET expected_uplink_interval_seconds = $2 WHERE station_eui = $1;
+`
+
+const selectCompletenessSQL = `
+SELECT date, expected_messages, received_messages, completeness_pct
+FROM data_completeness
+WHERE station_eui = $1 AND date > current_date - $2::int
+ORDER BY date DESC;
+`
+
+type completenessJSON struct {
+	Date            string   `json:"date"`
+	Expected        *int     `json:"expected_messages"`
+	Received        int      `json:"received_messages"`
+	CompletenessPct *float64 `json:"completeness_pct"`
+}
+
+// Every interval, recomputes data_completeness for the last completenessDays
+// days. The refresh scans measurements of every station, so it runs without
+// the PG_STATEMENT_TIMEOUT_MS cap meant for ingest statements.
+func runCompletenessUpdater(ctx context.Context, lg Logger, pool *pgxpool.Pool, interval time.Duration) {
+	refresh := func() {
+		var tag pgconn.CommandTag
+		err := pgx.BeginFunc(ctx, pool, func(tx pgx.Tx) error {
+			if _, err := tx.Exec(ctx, "SET LOCAL statement_timeout = 0"); err != nil {
+				return err
+			}
+			var err error
+			tag, err = tx.Exec(ctx, refreshCompletenessSQL, completenessDays)
+			return err
+		})
+		if err != nil {
+			lg.Error("completeness refresh error: %v", err)
+			return
+		}
+		lg.Debug("completeness refreshed: %d station days", tag.RowsAffected())
+	}
+
+	refresh()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			refresh()
+		}
+	}
+}
+
+// GET /api/v1/stations/{eui}/completeness?days=7
+func handleStationCompleteness(lg Logger, pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		eui := stationEUIParam(w, r)
+		if eui == "" {
+			return
+		}
+		days := 7
+		if v := r.URL.Query().Get("days"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				http.Error(w, "invalid days", http.StatusBadRequest)
+				return
+			}
+			days = n
+		}
+
+		rows, err := pool.Query(r.Context(), selectCompletenessSQL, eui, days)
+		if err != nil {
+			lg.Error("completeness query error: %v", err)
+			http.Error(w, "query failed", http.StatusInternalServerError)
+			return
+		}
+		out := []completenessJSON{}
+		var c completenessJSON
+		var date time.Time
+		_, err = pgx.ForEachRow(rows, []any{&date, &c.Expected, &c.Received, &c.CompletenessPct}, func() error {
+			c.Date = date.Format(time.DateOnly)
+			out = append(out, c)
+			return nil
+		})
+		if err != nil {
+			lg.Error("completeness query error: %v", err)
+			http.Error(w, "query failed", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, out)
+	}
+}
+
+type stationPatchJSON struct {
+	StationEUI                    string `json:"station_eui"`
+	ExpectedUplinkIntervalSeconds *int   `json:"expected_uplink_interval_seconds"`
+}
+
+// PATCH /api/v1/stations/{eui} with {"expected_uplink_interval_seconds": 300}
+// (null clears it). Completeness picks the change up on its next refresh.
+func handlePatchStation(lg Logger, pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		eui := stationEUIParam(w, r)
+		if eui == "" {
+			return
+		}
+		var fields map[string]json.RawMessage
+		if !readJSONBody(w, r, maxMetadataBody, &fields) {
+			return
+		}
+		raw, ok := fields["expected_uplink_interval_seconds"]
+		if !ok || len(fields) != 1 {
+			http.Error(w, "expecting only expected_uplink_interval_seconds", http.StatusBadRequest)
+			return
+		}
+		res := stationPatchJSON{StationEUI: eui}
+		if err := json.Unmarshal(raw, &res.ExpectedUplinkIntervalSeconds); err != nil ||
+			(res.ExpectedUplinkIntervalSeconds != nil && *res.ExpectedUplinkIntervalSeconds <= 0) {
+			http.Error(w, "expected_uplink_interval_seconds must be a positive integer or null", http.StatusBadRequest)
+			return
+		}
+
+		tag, err := pool.Exec(r.Context(), updateExpectedIntervalSQL, eui, res.ExpectedUplinkIntervalSeconds)
+		if err != nil {
+			lg.Error("station update error (eui: %s): %v", eui, err)
+			http.Error(w, "update failed", http.StatusInternalServerError)
+			return
+		}
+		if tag.RowsAffected() == 0 {
+			http.Error(w, "station not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, res)
+	}
+}
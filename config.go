@@ -0,0 +1,330 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//--- Configuration ---//
+
+// Config holds every env var the ingestor reads. Each field is loaded from
+// the variable named in its env tag, falling back to the default tag when
+// unset. Fields tagged secret are redacted by -dump-config; desc and required
+// (the condition under which it must be set) feed -config-template.
+type Config struct {
+	Mode          string `env:"MODE" default:"mqtt" desc:"Ingestion mode: mqtt, webhook, kafka or udp."`
+	NetworkServer string `env:"NETWORK_SERVER" default:"ttn" desc:"Uplink JSON format: ttn or thingpark (Actility DevEUI_uplink)."`
+
+	PGDSN                string `env:"PG_DSN" secret:"true" desc:"PostgreSQL/TimescaleDB connection string." required:"yes"`
+	PGPasswordFile       string `env:"PG_PASSWORD_FILE" desc:"File containing the DB password (e.g. a Docker secret), instead of embedding it in PG_DSN."`
+	PGSchema             string `env:"PG_SCHEMA" default:"public" desc:"Schema holding the ingestor tables."`
+	PGStatementTimeoutMS int    `env:"PG_STATEMENT_TIMEOUT_MS" default:"5000" desc:"statement_timeout set on every DB connection; 0 keeps the server default."`
+	PGLockTimeoutMS      int    `env:"PG_LOCK_TIMEOUT_MS" default:"0" desc:"lock_timeout set on every DB connection; 0 keeps the server default."`
+
+	MQTTHost                    string `env:"MQTT_HOST" desc:"MQTT broker host, e.g. au1.cloud.thethings.network." required:"in mqtt mode, unless MQTT_AWS_IOT_CORE is set"`
+	TTNRegionHostFallback       string `env:"TTN_REGION_HOST_FALLBACK" desc:"Broker host switched to after FALLBACK_RETRIES failed reconnects to MQTT_HOST, e.g. nam1.cloud.thethings.network; the client moves back once MQTT_HOST is reachable."`
+	FallbackRetries             int    `env:"FALLBACK_RETRIES" default:"5" desc:"Consecutive failed reconnect attempts before switching between MQTT_HOST and TTN_REGION_HOST_FALLBACK."`
+	MQTTPort                    string `env:"MQTT_PORT" default:"1883" desc:"MQTT broker port."`
+	MQTTProtocol                string `env:"MQTT_PROTOCOL" default:"mqtt" desc:"Broker URL scheme: mqtt, mqtts, ws or wss."`
+	MQTTTopic                   string `env:"MQTT_TOPIC" desc:"Uplink topic, e.g. v3/APP-ID@ttn/devices/+/up." required:"in mqtt mode"`
+	MultiAppConfigPath          string `env:"MULTI_APP_CONFIG_PATH" desc:"YAML file of TTN applications (app_id, api_key, region_host, topic) to subscribe to over one MQTT connection each, instead of MQTT_HOST/MQTT_TOPIC."`
+	MQTTDownlinkTopic           string `env:"MQTT_DOWNLINK_TOPIC" desc:"Also subscribe to downlink queued/sent events and log them to the downlinks table."`
+	MQTTUseAuth                 bool   `env:"MQTT_USE_AUTH" default:"true" desc:"Send MQTT_USERNAME/MQTT_PASSWORD when connecting."`
+	MQTTAuthMethod              string `env:"MQTT_AUTH_METHOD" default:"plain" desc:"MQTT authentication method; only plain is supported by the MQTT 3.1.1 client."`
+	MQTTUsername                string `env:"MQTT_USERNAME" desc:"MQTT username (for TTN: the application ID with @ttn)." required:"in mqtt mode with MQTT_USE_AUTH"`
+	MQTTPassword                string `env:"MQTT_PASSWORD" secret:"true" desc:"MQTT password (for TTN: an API key)." required:"in mqtt mode with MQTT_USE_AUTH, unless TTN_API_KEY_LIST is set"`
+	MQTTPingTimeoutSeconds      int    `env:"MQTT_PING_TIMEOUT_SECONDS" default:"10" desc:"Seconds to wait for a PINGRESP before treating the connection as lost."`
+	MQTTSharedSubscriptionGroup string `env:"MQTT_SHARED_SUBSCRIPTION_GROUP" desc:"Subscribe via $share/<group>/MQTT_TOPIC so replicas split the messages."`
+	MQTTPayloadGzip             bool   `env:"MQTT_PAYLOAD_GZIP" default:"false" desc:"Gunzip MQTT payloads before parsing."`
+	MQTTDiagnosticTopic         string `env:"MQTT_DIAGNOSTIC_TOPIC" desc:"Also publish WARN and ERROR log lines as JSON to this MQTT topic (mqtt mode only)."`
+	WatchdogTimeoutMinutes      int    `env:"WATCHDOG_TIMEOUT_MINUTES" default:"0" desc:"Reconnect to MQTT when no message has arrived for this many minutes (0 disables)."`
+
+	MQTTAWSIoTCore     bool   `env:"MQTT_AWS_IOT_CORE" default:"false" desc:"Connect to AWS IoT Core over WebSocket with SigV4 signing instead of MQTT_HOST; MQTT_USERNAME/PASSWORD and TTN_* are ignored."`
+	AWSIoTEndpoint     string `env:"AWS_IOT_ENDPOINT" desc:"AWS IoT Core data endpoint, e.g. xxxxxxxx-ats.iot.eu-west-1.amazonaws.com." required:"with MQTT_AWS_IOT_CORE"`
+	AWSRegion          string `env:"AWS_REGION" desc:"AWS region of the IoT Core endpoint." required:"with MQTT_AWS_IOT_CORE"`
+	AWSAccessKeyID     string `env:"AWS_ACCESS_KEY_ID" desc:"AWS access key used to sign the connection." required:"with MQTT_AWS_IOT_CORE"`
+	AWSSecretAccessKey string `env:"AWS_SECRET_ACCESS_KEY" secret:"true" desc:"AWS secret key used to sign the connection." required:"with MQTT_AWS_IOT_CORE"`
+	AWSSessionToken    string `env:"AWS_SESSION_TOKEN" secret:"true" desc:"Session token for temporary AWS credentials."`
+
+	TTNAppID           string `env:"TTN_APP_ID" desc:"TTN application ID, used to check MQTT_HOST against the application's cluster."`
+	TTNAPIKey          string `env:"TTN_API_KEY" secret:"true" desc:"TTN API key able to read the application's devices."`
+	TTNAPIKeyList      string `env:"TTN_API_KEY_LIST" secret:"true" desc:"Comma separated TTN API keys used as the MQTT password in turn, moving to the next one when a connection fails."`
+	TTNAPIKeyExpiresAt string `env:"TTN_API_KEY_EXPIRES_AT" desc:"Expiry (RFC3339) of the API key used at startup; 10 minutes before it the MQTT connection switches to the next TTN_API_KEY_LIST key."`
+	TTNIdentityServer  string `env:"TTN_IDENTITY_SERVER" default:"eu1.cloud.thethings.network" desc:"TTN identity server host."`
+
+	UDPListenAddr         string  `env:"UDP_LISTEN_ADDR" default:":1700" desc:"Listen address for Semtech UDP packet forwarder traffic in udp mode."`
+	WebhookAddr           string  `env:"WEBHOOK_ADDR" default:":7070" desc:"Listen address of the webhook server."`
+	WebhookSecret         string  `env:"WEBHOOK_SECRET" secret:"true" desc:"Expected X-Downlink-Apikey header on webhook requests; empty disables the check."`
+	WebhookSigningKey     string  `env:"WEBHOOK_SIGNING_KEY" secret:"true" desc:"Require webhook requests to carry a valid X-TTN-Signature (HMAC-SHA256 of the body) under this key."`
+	WebhookRateLimitRPS   float64 `env:"WEBHOOK_RATE_LIMIT_RPS" default:"100" desc:"Webhook requests per second allowed per client IP."`
+	WebhookRateLimitBurst int     `env:"WEBHOOK_RATE_LIMIT_BURST" default:"20" desc:"Webhook request burst allowed per client IP."`
+
+	AdminAPIToken        string  `env:"ADMIN_API_TOKEN" secret:"true" desc:"Bearer token for the API endpoints that change state (rotate-key, groups, station metadata, sensor labels, calibrations); empty disables them."`
+	IngestAPITokens      string  `env:"INGEST_API_TOKENS" secret:"true" desc:"Comma separated bearer tokens accepted by POST /api/v1/ingest; empty disables the endpoint."`
+	IngestRateLimitRPS   float64 `env:"INGEST_RATE_LIMIT_RPS" default:"100" desc:"POST /api/v1/ingest requests per second allowed per token."`
+	IngestRateLimitBurst int     `env:"INGEST_RATE_LIMIT_BURST" default:"200" desc:"POST /api/v1/ingest request burst allowed per token."`
+
+	SinkFanout         string `env:"SINK_FANOUT" default:"postgres" desc:"Comma separated sinks every uplink is written to: postgres, kafka, newrelic."`
+	KafkaBrokers       string `env:"KAFKA_BROKERS" desc:"Comma separated Kafka broker addresses." required:"in kafka mode or when SINK_FANOUT includes kafka"`
+	KafkaSinkTopic     string `env:"KAFKA_SINK_TOPIC" default:"weatherbus.uplinks" desc:"Kafka topic for the kafka sink."`
+	KafkaTopic         string `env:"KAFKA_TOPIC" desc:"Kafka topic TTN uplinks are consumed from in kafka mode." required:"in kafka mode"`
+	KafkaGroupID       string `env:"KAFKA_GROUP_ID" default:"weatherbus-lorawan-ingestor" desc:"Kafka consumer group in kafka mode; instances in the same group share the topic's partitions."`
+	NDJSONSkipDB       bool   `env:"NDJSON_SKIP_DB" default:"false" desc:"With -emit-ndjson, write uplinks only to stdout instead of the SINK_FANOUT sinks."`
+	NewRelicLicenseKey string `env:"NEW_RELIC_LICENSE_KEY" secret:"true" desc:"New Relic license (insert) key for the newrelic sink." required:"when SINK_FANOUT includes newrelic"`
+	NewRelicAccountID  string `env:"NEW_RELIC_ACCOUNT_ID" desc:"New Relic account ID the newrelic sink posts events to." required:"when SINK_FANOUT includes newrelic"`
+	NewRelicRegion     string `env:"NEW_RELIC_REGION" default:"US" desc:"New Relic data center of the account: US or EU."`
+
+	OTELServiceName  string `env:"OTEL_SERVICE_NAME" default:"weatherbus-lorawan-ingestor" desc:"service.name reported in telemetry."`
+	DeploymentEnv    string `env:"DEPLOYMENT_ENV" desc:"deployment.environment reported in telemetry (e.g. prod, staging)."`
+	PromEUIPrefixLen int    `env:"PROM_EUI_PREFIX_LEN" default:"0" desc:"Cut station_eui metric labels to this many characters to bound cardinality (0 = full EUI, one series per station)."`
+
+	HealthPort             string `env:"HEALTH_PORT" default:"8080" desc:"Port of the health, metrics and API server."`
+	GRPCAddr               string `env:"GRPC_ADDR" desc:"Listen address of the gRPC server streaming inserted measurements (WatchMeasurements); empty disables it."`
+	GRPCToken              string `env:"GRPC_TOKEN" secret:"true" desc:"Bearer token gRPC clients must send; GRPC_ADDR needs this, TLS or both."`
+	GRPCTLSCertFile        string `env:"GRPC_TLS_CERT_FILE" desc:"PEM certificate served by the gRPC server."`
+	GRPCTLSKeyFile         string `env:"GRPC_TLS_KEY_FILE" desc:"PEM private key of GRPC_TLS_CERT_FILE."`
+	EnablePprof            bool   `env:"ENABLE_PPROF" default:"false" desc:"Expose /debug/pprof on the health server."`
+	DBLatencyWarnMS        int    `env:"DB_LATENCY_WARN_MS" default:"500" desc:"DB round trip (milliseconds) above which -healthcheck-interval logs a warning."`
+	DBSlowQueryThresholdMS int    `env:"DB_SLOW_QUERY_THRESHOLD_MS" default:"200" desc:"Statements taking longer (milliseconds) are logged with their SQL and parameters; 0 disables the log."`
+
+	AnomalyZScoreThreshold       float64 `env:"ANOMALY_ZSCORE_THRESHOLD" default:"3.0" desc:"Log readings with a z-score above this (vs. the last 24h) to measurements_anomaly."`
+	OrderByFrameCounter          bool    `env:"ORDER_BY_FRAME_COUNTER" default:"false" desc:"Buffer uplinks per device and store them in FCnt order."`
+	OrderTimeoutSeconds          int     `env:"ORDER_TIMEOUT_SECONDS" default:"5" desc:"How long to wait for a missing frame before flushing the buffer."`
+	SilenceAlertIntervalMinutes  int     `env:"SILENCE_ALERT_INTERVAL_MINUTES" default:"60" desc:"How often to check for silent stations (0 disables)."`
+	SilenceAlertThresholdMinutes int     `env:"SILENCE_ALERT_THRESHOLD_MINUTES" default:"120" desc:"Minutes without an uplink before a station is reported as silent."`
+	CompletenessIntervalMinutes  int     `env:"COMPLETENESS_INTERVAL_MINUTES" default:"60" desc:"How often to recompute data_completeness for the last 7 days (0 disables)."`
+	RetryMaxAttempts             int     `env:"RETRY_MAX_ATTEMPTS" default:"5" desc:"Retries of a failed measurement insert before it is given up."`
+	RetryPoolMaxConns            int     `env:"RETRY_POOL_MAX_CONNS" default:"2" desc:"Connections of the retry queue's own pool, kept apart from the ingest pool."`
+	BatchMaxSize                 int     `env:"BATCH_MAX_SIZE" default:"500" desc:"Measurement rows written per multi-row INSERT (1 disables batching)."`
+	BatchMaxWaitMS               int     `env:"BATCH_MAX_WAIT_MS" default:"100" desc:"Longest a measurement waits for its batch to fill before it is written (0 disables batching)."`
+	FCntReplayCheck              bool    `env:"FCNT_REPLAY_CHECK" default:"false" desc:"Drop uplinks whose frame counter is not newer than the last one seen."`
+	DebugRetentionHours          int     `env:"DEBUG_RETENTION_HOURS" default:"24" desc:"Hours raw MQTT messages stored in debug_mqtt_messages with -debug are kept."`
+	ConnectionEventRetentionDays int     `env:"CONNECTION_EVENT_RETENTION" default:"30" desc:"Days MQTT connect/disconnect events are kept in connection_events."`
+	UplinkTokenDedup             bool    `env:"UPLINK_TOKEN_DEDUP" default:"true" desc:"Drop uplinks whose rx_metadata uplink_token is already in uplink_tokens."`
+	UplinkTokenTTLHours          int     `env:"UPLINK_TOKEN_TTL_HOURS" default:"24" desc:"Hours an uplink token is kept for deduplication."`
+	SensorAutodiscovery          bool    `env:"SENSOR_AUTODISCOVERY" default:"false" desc:"Store readings of unknown sensor types and record each new type in sensor_type_discoveries."`
+	RawDecoders                  string  `env:"RAW_DECODERS" desc:"fport=decoder pairs for uplinks without decoded_payload (weatherbus, temp-humidity)."`
+	CacheStations                int     `env:"CACHE_STATIONS" default:"1000" desc:"Stations whose latest readings are kept in memory for /latest; 0 disables the cache."`
+	CacheReadingsPerStation      int     `env:"CACHE_READINGS_PER_STATION" default:"20" desc:"Readings (one per slave/sensor/index) cached per station."`
+	TransformEndpointURL         string  `env:"TRANSFORM_ENDPOINT_URL" desc:"URL each parsed uplink is POSTed to; the response replaces its decoded_payload."`
+	TransformTimeoutMS           int     `env:"TRANSFORM_TIMEOUT_MS" default:"500" desc:"Timeout for TRANSFORM_ENDPOINT_URL; on timeout or error the original payload is kept."`
+	CalibrationCacheTTLSeconds   int     `env:"CALIBRATION_CACHE_TTL_SECONDS" default:"300" desc:"Seconds a station's sensor_calibration rows are cached; 0 reads them for every uplink."`
+	SmoothSensorTypes            string  `env:"SMOOTH_SENSOR_TYPES" desc:"Comma separated sensor type IDs stored as an exponential moving average."`
+	SmoothAlpha                  float64 `env:"SMOOTH_ALPHA" default:"0.3" desc:"Weight of the newest reading in the moving average, 0 < alpha <= 1."`
+	ThresholdConfigPath          string  `env:"THRESHOLD_CONFIG_PATH" desc:"JSON file of per sensor type low/high alert thresholds."`
+	AlertMQTTTopic               string  `env:"ALERT_MQTT_TOPIC" desc:"MQTT topic threshold alerts are published to (mqtt mode only)."`
+	AnomalyWebhookURL            string  `env:"ANOMALY_WEBHOOK_URL" desc:"URL a JSON alert is POSTed to when a reading first crosses a THRESHOLD_CONFIG_PATH threshold."`
+	ErrorNotifyWebhookURL        string  `env:"ERROR_NOTIFY_WEBHOOK_URL" desc:"URL a JSON notification is POSTed to when DB or parse errors pile up."`
+	ErrorNotifyThreshold         int     `env:"ERROR_NOTIFY_THRESHOLD" default:"5" desc:"Errors of one kind within the window before a notification is sent."`
+	ErrorNotifyWindowSeconds     int     `env:"ERROR_NOTIFY_WINDOW_SECONDS" default:"60" desc:"Sliding window for ERROR_NOTIFY_THRESHOLD."`
+	AggregateSlaves              bool    `env:"AGGREGATE_SLAVES" default:"false" desc:"Also store the mean across slaves of each sensor type/index."`
+	AggregateSlaveID             int     `env:"AGGREGATE_SLAVE_ID" default:"-1" desc:"Slave ID used for the AGGREGATE_SLAVES rows."`
+}
+
+// Reads the Config from the environment. Only malformed values are errors;
+// required settings are checked by validate and validateIngest.
+func loadConfig() (*Config, error) {
+	cfg := &Config{}
+	v := reflect.ValueOf(cfg).Elem()
+	t := v.Type()
+	var errs []error
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := f.Tag.Get("env")
+		raw := os.Getenv(name)
+		if raw == "" {
+			raw = f.Tag.Get("default")
+		}
+		if raw == "" {
+			continue
+		}
+		switch fv := v.Field(i); fv.Kind() {
+		case reflect.String:
+			fv.SetString(raw)
+		case reflect.Int:
+			n, err := strconv.Atoi(raw)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid env %s: %w", name, err))
+				continue
+			}
+			fv.SetInt(int64(n))
+		case reflect.Float64:
+			x, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid env %s: %w", name, err))
+				continue
+			}
+			fv.SetFloat(x)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(raw)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid env %s: %w", name, err))
+				continue
+			}
+			fv.SetBool(b)
+		default:
+			errs = append(errs, fmt.Errorf("env %s: unsupported field type %s", name, f.Type))
+		}
+	}
+	return cfg, errors.Join(errs...)
+}
+
+// Checks the settings every database mode needs, including the one-shot
+// commands (-schema-diff, -migrate-indexes, the exports, ...).
+func (c *Config) validate() error {
+	if c.PGDSN == "" {
+		return missingEnvError([]string{"PG_DSN"})
+	}
+	return nil
+}
+
+// Checks that the settings needed by the configured ingest mode and sinks
+// are set; only the ingest path needs them.
+func (c *Config) validateIngest() error {
+	var missing []string
+	need := func(name, v string) {
+		if v == "" {
+			missing = append(missing, name)
+		}
+	}
+	if c.Mode == "mqtt" {
+		missing = append(missing, c.missingMQTT()...)
+	}
+	if c.Mode == "kafka" {
+		need("KAFKA_TOPIC", c.KafkaTopic)
+	}
+	if c.Mode == "kafka" || sinkListHas(c.SinkFanout, "kafka") {
+		need("KAFKA_BROKERS", c.KafkaBrokers)
+	}
+	if sinkListHas(c.SinkFanout, "newrelic") {
+		need("NEW_RELIC_LICENSE_KEY", c.NewRelicLicenseKey)
+		need("NEW_RELIC_ACCOUNT_ID", c.NewRelicAccountID)
+	}
+	return missingEnvError(missing)
+}
+
+// Checks only the MQTT settings, for modes that don't touch the database.
+func (c *Config) validateMQTT() error {
+	return missingEnvError(c.missingMQTT())
+}
+
+func (c *Config) missingMQTT() []string {
+	var missing []string
+	need := func(name, v string) {
+		if v == "" {
+			missing = append(missing, name)
+		}
+	}
+	// Brokers, credentials and topics come from the file instead.
+	if c.MultiAppConfigPath != "" {
+		return nil
+	}
+	need("MQTT_TOPIC", c.MQTTTopic)
+	if c.MQTTAWSIoTCore {
+		need("AWS_IOT_ENDPOINT", c.AWSIoTEndpoint)
+		need("AWS_REGION", c.AWSRegion)
+		need("AWS_ACCESS_KEY_ID", c.AWSAccessKeyID)
+		need("AWS_SECRET_ACCESS_KEY", c.AWSSecretAccessKey)
+		return missing
+	}
+	need("MQTT_HOST", c.MQTTHost)
+	if c.MQTTUseAuth {
+		need("MQTT_USERNAME", c.MQTTUsername)
+		if c.TTNAPIKeyList == "" {
+			need("MQTT_PASSWORD", c.MQTTPassword)
+		}
+	}
+	return missing
+}
+
+func missingEnvError(missing []string) error {
+	if len(missing) == 0 {
+		return nil
+	}
+	return fmt.Errorf("missing env %s", strings.Join(missing, ", "))
+}
+
+func sinkListHas(list, name string) bool {
+	for _, s := range strings.Split(list, ",") {
+		if strings.TrimSpace(s) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Masks the password of a URL with userinfo; other strings are returned as is.
+func redactURL(s string) string {
+	if u, err := url.Parse(s); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	return s
+}
+
+var dsnPasswordRe = regexp.MustCompile(`(password\s*=\s*)('(?:[^'\\]|\\.)*'|\S+)`)
+
+// Masks the password of a URL or keyword/value DSN.
+func redactDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" {
+		q := u.Query()
+		if q.Has("password") {
+			q.Set("password", "xxxxx")
+			u.RawQuery = q.Encode()
+		}
+		return u.Redacted()
+	}
+	return dsnPasswordRe.ReplaceAllString(dsn, "${1}xxxxx")
+}
+
+// Writes the effective configuration as indented JSON keyed by env var name,
+// with secrets redacted. PG_DSN keeps everything but its password.
+func (c *Config) dump(w io.Writer) error {
+	out := map[string]any{}
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		val := v.Field(i).Interface()
+		switch {
+		case f.Name == "PGDSN":
+			val = redactDSN(c.PGDSN)
+		case f.Tag.Get("secret") == "true" && v.Field(i).String() != "":
+			val = "REDACTED"
+		}
+		out[f.Tag.Get("env")] = val
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// Writes a .env template listing every Config variable with its description,
+// whether it is required and its default. Required variables are left
+// uncommented so they stand out.
+func writeConfigTemplate(w io.Writer) error {
+	var b strings.Builder
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, def, req := f.Tag.Get("env"), f.Tag.Get("default"), f.Tag.Get("required")
+		fmt.Fprintf(&b, "# %s\n", f.Tag.Get("desc"))
+		switch {
+		case req != "":
+			fmt.Fprintf(&b, "# Required: %s\n%s=%s\n\n", req, name, def)
+		case def == "":
+			fmt.Fprintf(&b, "# Optional\n# %s=\n\n", name)
+		default:
+			fmt.Fprintf(&b, "# Default: %s\n# %s=%s\n\n", def, name, def)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//--- Connectivity pre-flight check ---//
+
+// Timeout of each connectivity check.
+const connectivityCheckTimeout = 5 * time.Second
+
+type connectivityCheck struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// Tries every external dependency in cfg once and prints PASS/FAIL per
+// check. Returns the exit code: 0 if all passed, 1 otherwise. Meant for
+// init containers and deployment scripts, so it never logs in to MQTT.
+func runConnectivityCheck(ctx context.Context, w io.Writer, lg Logger, cfg *Config) int {
+	broker, err := url.Parse(mqttBrokerURL(cfg))
+	if err != nil {
+		fmt.Fprintf(w, "FAIL    mqtt broker URL: %v\n", err)
+		return 1
+	}
+
+	checks := []connectivityCheck{
+		{"postgres connect + ping", func(ctx context.Context) error {
+			poolCfg, err := pgPoolConfig(lg, cfg)
+			if err != nil {
+				return err
+			}
+			pool, err := pgxpool.NewWithConfig(ctx, poolCfg)
+			if err != nil {
+				return err
+			}
+			defer pool.Close()
+			return pool.Ping(ctx)
+		}},
+		{"dns " + broker.Hostname(), func(ctx context.Context) error {
+			_, err := net.DefaultResolver.LookupHost(ctx, broker.Hostname())
+			return err
+		}},
+		{"mqtt tcp " + broker.Host, func(ctx context.Context) error {
+			var d net.Dialer
+			conn, err := d.DialContext(ctx, "tcp", broker.Host)
+			if err != nil {
+				return err
+			}
+			return conn.Close()
+		}},
+	}
+	if cfg.TransformEndpointURL != "" {
+		checks = append(checks, connectivityCheck{"http get " + redactURL(cfg.TransformEndpointURL), func(ctx context.Context) error {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, cfg.TransformEndpointURL, nil)
+			if err != nil {
+				return err
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return err
+			}
+			// Any answer means the endpoint is reachable; it may only
+			// accept POST.
+			return resp.Body.Close()
+		}})
+	}
+
+	failed := 0
+	for _, c := range checks {
+		checkCtx, cancel := context.WithTimeout(ctx, connectivityCheckTimeout)
+		start := time.Now()
+		err := c.run(checkCtx)
+		cancel()
+		if err != nil {
+			failed++
+			fmt.Fprintf(w, "FAIL    %s: %v\n", c.name, err)
+			continue
+		}
+		fmt.Fprintf(w, "PASS    %s (%s)\n", c.name, time.Since(start).Round(time.Millisecond))
+	}
+	fmt.Fprintf(w, "%d passed, %d failed\n", len(checks)-failed, failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//--- MQTT connection history ---//
+
+// How often expired connection events are deleted.
+const connectionEventCleanupInterval = time.Hour
+
+// Event types in connection_events.
+const (
+	connectionEventConnected = "connected"
+	connectionEventLost      = "connection_lost"
+)
+
+const insertConnectionEventSQL = `
+INSERT INTO connection_events(event_type, occurred_at, detail) VALUES ($1, $2, $3);
+`
+
+const deleteExpiredConnectionEventsSQL = `
+DELETE FROM connection_events WHERE occurred_at < now() - $1::interval;
+`
+
+const selectConnectionEventsSQL = `
+SELECT event_type, occurred_at, coalesce(detail, '')
+FROM connection_events
+ORDER BY occurred_at DESC
+LIMIT $1;
+`
+
+// Set in mqtt mode: every connect and connection loss is stored in
+// connection_events, to spot broker instability over time.
+var connectionEvents *connectionEventLog
+
+type connectionEventLog struct {
+	log  Logger
+	pool *pgxpool.Pool
+}
+
+// Deletes events older than retention in the background until ctx is
+// cancelled.
+func newConnectionEventLog(ctx context.Context, lg Logger, pool *pgxpool.Pool, retention time.Duration) *connectionEventLog {
+	l := &connectionEventLog{log: lg, pool: pool}
+	go l.runCleanup(ctx, retention)
+	return l
+}
+
+// Called from the MQTT client's callbacks, which have no context of their
+// own. The insert runs in the background: the callbacks block the client
+// (OnConnect runs before the subscriptions are made), and a broker that
+// reconnects while the database is slow must not wait for it.
+func (l *connectionEventLog) record(eventType, detail string) {
+	occurredAt := time.Now()
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if _, err := l.pool.Exec(ctx, insertConnectionEventSQL, eventType, occurredAt, nullIfEmpty(detail)); err != nil {
+			stats.DBErrors.Add(1)
+			l.log.Error("connection event insert error: %v (%s)", err, eventType)
+		}
+	}()
+}
+
+func (l *connectionEventLog) runCleanup(ctx context.Context, retention time.Duration) {
+	t := time.NewTicker(connectionEventCleanupInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			tag, err := l.pool.Exec(ctx, deleteExpiredConnectionEventsSQL, retention)
+			if err != nil {
+				l.log.Error("connection event cleanup error: %v", err)
+				continue
+			}
+			l.log.Debug("deleted %d expired connection events", tag.RowsAffected())
+		}
+	}
+}
+
+type connectionEventJSON struct {
+	EventType  string    `json:"event_type"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Detail     string    `json:"detail,omitempty"`
+}
+
+// GET /api/v1/connection-history?limit=50: the newest MQTT connection
+// events, newest first.
+func handleConnectionHistory(lg Logger, pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := 50
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 || n > 1000 {
+				http.Error(w, "invalid limit (1-1000)", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+
+		rows, err := pool.Query(r.Context(), selectConnectionEventsSQL, limit)
+		if err != nil {
+			lg.Error("connection history query error: %v", err)
+			http.Error(w, "query failed", http.StatusInternalServerError)
+			return
+		}
+		events := []connectionEventJSON{}
+		var ev connectionEventJSON
+		_, err = pgx.ForEachRow(rows, []any{&ev.EventType, &ev.OccurredAt, &ev.Detail}, func() error {
+			events = append(events, ev)
+			return nil
+		})
+		if err != nil {
+			lg.Error("connection history query error: %v", err)
+			http.Error(w, "query failed", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, events)
+	}
+}
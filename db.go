@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//--- DB pool configuration ---//
+
+// Builds the pool config from cfg.PGDSN. The password may instead come from
+// PG_PASSWORD_FILE, or from PGPASSWORD / PGPASSFILE which pgx reads the same
+// way libpq does.
+func pgPoolConfig(lg Logger, c *Config) (*pgxpool.Config, error) {
+	cfg, err := pgxpool.ParseConfig(c.PGDSN)
+	if err != nil {
+		return nil, fmt.Errorf("parse PG_DSN: %w", err)
+	}
+
+	if path := c.PGPasswordFile; path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read PG_PASSWORD_FILE: %w", err)
+		}
+		// Set on the parsed config rather than spliced into the DSN, so no
+		// URL or keyword escaping is needed.
+		cfg.ConnConfig.Password = strings.TrimRight(string(b), "\r\n")
+	}
+
+	// Tables are referenced unqualified, so a non-default schema is selected
+	// through search_path; public stays as a fallback for extension functions.
+	if schema := c.PGSchema; schema != "" && schema != "public" {
+		cfg.ConnConfig.RuntimeParams["search_path"] = pgx.Identifier{schema}.Sanitize() + ", public"
+	}
+
+	// Bound how long a statement (and a lock wait within it) may hold a
+	// worker; 0 leaves the server default.
+	if c.PGStatementTimeoutMS > 0 {
+		cfg.ConnConfig.RuntimeParams["statement_timeout"] = strconv.Itoa(c.PGStatementTimeoutMS)
+	}
+	if c.PGLockTimeoutMS > 0 {
+		cfg.ConnConfig.RuntimeParams["lock_timeout"] = strconv.Itoa(c.PGLockTimeoutMS)
+	}
+	lg.Info("DB statement_timeout: %dms, lock_timeout: %dms (0 = server default)", c.PGStatementTimeoutMS, c.PGLockTimeoutMS)
+
+	cfg.ConnConfig.Tracer = &slowQueryTracer{log: lg, threshold: time.Duration(c.DBSlowQueryThresholdMS) * time.Millisecond}
+
+	if cfg.ConnConfig.Password == "" {
+		lg.Error("no DB password configured: set it in PG_DSN, PG_PASSWORD_FILE, PGPASSWORD or PGPASSFILE (ignore if the server uses trust/peer auth)")
+	}
+	return cfg, nil
+}
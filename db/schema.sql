@@ -1,5 +1,21 @@
--- Enable Timescale
-CREATE EXTENSION IF NOT EXISTS timescaledb;
+-- Enable Timescale where it is installed; the schema also works on plain
+-- PostgreSQL, without hypertables and with measurements_hourly as a view.
+DO $$
+BEGIN
+  IF EXISTS (SELECT 1 FROM pg_available_extensions WHERE name = 'timescaledb') THEN
+    CREATE EXTENSION IF NOT EXISTS timescaledb;
+  ELSE
+    RAISE NOTICE 'timescaledb not available; using plain tables';
+  END IF;
+END $$;
+
+-- Applied schema versions. Bump by appending an INSERT at the end of this
+-- file whenever the schema changes.
+CREATE TABLE IF NOT EXISTS schema_migrations (
+  version     INTEGER PRIMARY KEY,
+  applied_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+  description TEXT
+);
 
 -- Sensor types table
 CREATE TABLE IF NOT EXISTS sensor_types (
@@ -26,6 +42,15 @@ INSERT INTO sensor_types (type_id, name, unit) VALUES
   (15, 'water_level_cm',              'cm')
 ON CONFLICT DO NOTHING;
 
+-- Sensor types seen in uplinks but missing from sensor_types (with
+-- SENSOR_AUTODISCOVERY), with the first sighting of each
+CREATE TABLE IF NOT EXISTS sensor_type_discoveries (
+  type_id       SMALLINT PRIMARY KEY,
+  first_seen_at TIMESTAMPTZ NOT NULL,
+  station_eui   TEXT NOT NULL,
+  sample_value  DOUBLE PRECISION
+);
+
 -- Stations table
 CREATE TABLE IF NOT EXISTS stations (
   station_eui TEXT PRIMARY KEY,              -- e.g. "70B3D57ED0069153"
@@ -33,6 +58,13 @@ CREATE TABLE IF NOT EXISTS stations (
   created_at TIMESTAMPTZ NOT NULL DEFAULT now()
 );
 
+ALTER TABLE stations ADD COLUMN IF NOT EXISTS station_devid TEXT;
+ALTER TABLE stations ADD COLUMN IF NOT EXISTS last_uplink_at TIMESTAMPTZ;
+ALTER TABLE stations ADD COLUMN IF NOT EXISTS expected_uplink_interval_seconds INT;
+-- network_ids of the latest uplink, for deployments spanning TTN tenants/clusters
+ALTER TABLE stations ADD COLUMN IF NOT EXISTS ttn_tenant_id TEXT;
+ALTER TABLE stations ADD COLUMN IF NOT EXISTS ttn_cluster_id TEXT;
+
 -- Slaves table
 CREATE TABLE IF NOT EXISTS slaves (
   station_eui TEXT NOT NULL REFERENCES stations(station_eui) ON DELETE CASCADE,
@@ -48,8 +80,105 @@ CREATE TABLE IF NOT EXISTS gateways (
   gateway_eui TEXT
 );
 
+-- Operator-defined key/value tags per station (location name, owner, ...)
+CREATE TABLE IF NOT EXISTS device_metadata (
+  station_eui TEXT NOT NULL REFERENCES stations(station_eui) ON DELETE CASCADE,
+  key         TEXT NOT NULL,
+  value       TEXT NOT NULL,
+  updated_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (station_eui, key)
+);
+CREATE INDEX IF NOT EXISTS ix_device_metadata_key_value
+  ON device_metadata (key, value);
+
+-- Field labels for sensor positions (e.g. "north probe") that the device
+-- doesn't know about
+CREATE TABLE IF NOT EXISTS slave_sensor_map (
+  station_eui  TEXT NOT NULL REFERENCES stations(station_eui) ON DELETE CASCADE,
+  slave_id     INTEGER NOT NULL,
+  sensor_type  SMALLINT NOT NULL,
+  sensor_index SMALLINT NOT NULL,
+  label        TEXT NOT NULL,
+  installed_at TIMESTAMPTZ,
+  PRIMARY KEY (station_eui, slave_id, sensor_type, sensor_index)
+);
+
+-- Linear correction per sensor position: value = raw_value * scale + offset,
+-- applied before smoothing. Cached for CALIBRATION_CACHE_TTL_SECONDS.
+CREATE TABLE IF NOT EXISTS sensor_calibration (
+  station_eui   TEXT NOT NULL REFERENCES stations(station_eui) ON DELETE CASCADE,
+  slave_id      INTEGER NOT NULL,
+  sensor_type   SMALLINT NOT NULL,
+  sensor_index  SMALLINT NOT NULL,
+  "offset"      FLOAT8 NOT NULL DEFAULT 0,
+  scale         FLOAT8 NOT NULL DEFAULT 1,
+  calibrated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (station_eui, slave_id, sensor_type, sensor_index)
+);
+
+-- Session keys of ABP devices received straight from Semtech UDP packet
+-- forwarders (MODE=udp), as hex. dev_addr is written the usual way round
+-- (e.g. "26011F4B"); several devices may share one, the MIC tells them apart.
+CREATE TABLE IF NOT EXISTS device_keys (
+  station_eui    TEXT PRIMARY KEY,
+  dev_addr       TEXT NOT NULL,
+  nwk_s_key      TEXT NOT NULL,
+  app_s_key      TEXT NOT NULL,
+  application_id TEXT
+);
+CREATE INDEX IF NOT EXISTS ix_device_keys_dev_addr
+  ON device_keys (upper(dev_addr));
+
+-- Where each station was: its own GPS fix (source 'device', from a
+-- decoded_payload with top-level latitude/longitude/altitude) or the
+-- location of the gateway that received it (source 'gateway').
+CREATE TABLE IF NOT EXISTS device_locations (
+  station_eui TEXT NOT NULL,
+  time        TIMESTAMPTZ NOT NULL,
+  latitude    DOUBLE PRECISION NOT NULL,
+  longitude   DOUBLE PRECISION NOT NULL,
+  altitude    DOUBLE PRECISION,
+  source      TEXT NOT NULL CHECK (source IN ('device', 'gateway')),
+  PRIMARY KEY (station_eui, source, time)
+);
+
+-- Station groups: several stations acting as one logical sensor array
+CREATE TABLE IF NOT EXISTS station_group_defs (
+  group_id   TEXT PRIMARY KEY,
+  name       TEXT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS station_groups (
+  group_id    TEXT NOT NULL REFERENCES station_group_defs(group_id) ON DELETE CASCADE,
+  station_eui TEXT NOT NULL REFERENCES stations(station_eui) ON DELETE CASCADE,
+  PRIMARY KEY (group_id, station_eui)
+);
+
+-- Received vs. expected uplinks per station per day, refreshed by the ingestor
+CREATE TABLE IF NOT EXISTS data_completeness (
+  station_eui       TEXT NOT NULL REFERENCES stations(station_eui) ON DELETE CASCADE,
+  date              DATE NOT NULL,
+  expected_messages INT NOT NULL,
+  received_messages INT NOT NULL,
+  completeness_pct  DOUBLE PRECISION,
+  updated_at        TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (station_eui, date)
+);
+-- NULL for stations without expected_uplink_interval_seconds
+ALTER TABLE data_completeness ALTER COLUMN expected_messages DROP NOT NULL;
+
+-- Per-gateway running message count and signal averages
+CREATE TABLE IF NOT EXISTS gateway_statistics (
+  gateway_id     TEXT PRIMARY KEY REFERENCES gateways(gateway_id) ON DELETE CASCADE,
+  msg_count      BIGINT NOT NULL DEFAULT 0,
+  avg_rssi       DOUBLE PRECISION,
+  avg_snr        DOUBLE PRECISION,
+  last_active_at TIMESTAMPTZ
+);
+
 -- Measurements hypertable
-ALTER TABLE measurements (
+CREATE TABLE IF NOT EXISTS measurements (
   time          TIMESTAMPTZ NOT NULL,
   station_eui   TEXT NOT NULL,
   station_devid TEXT,
@@ -64,14 +193,162 @@ ALTER TABLE measurements (
   UNIQUE (time, station_eui, slave_id, sensor_type, sensor_index)
 );
 
-SELECT create_hypertable('measurements', 'time', if_not_exists => TRUE);
-
--- Helpful indexes
+-- Helpful indexes. On a table that already holds data, build them with
+-- ingestor -migrate-indexes first, which doesn't block writes.
 CREATE INDEX IF NOT EXISTS ix_measurements_station_time
   ON measurements (station_eui, time DESC);
 CREATE INDEX IF NOT EXISTS ix_measurements_sensor
   ON measurements (sensor_type, time DESC);
 
+-- Unsmoothed reading for sensor types listed in SMOOTH_SENSOR_TYPES, where
+-- value holds the exponential moving average instead. NULL otherwise.
+ALTER TABLE measurements ADD COLUMN IF NOT EXISTS raw_value DOUBLE PRECISION;
+
+-- Raw base64 LoRa payload of the uplink, so rows can be re-decoded later
+ALTER TABLE measurements ADD COLUMN IF NOT EXISTS frm_payload TEXT;
+
+-- Stable ID of a reading for downstream consumers. The ingestor writes a
+-- SHA-1 name-based UUID of (time, station_eui, slave_id, sensor_type,
+-- sensor_index), the key ON CONFLICT DO NOTHING deduplicates on; rows written
+-- before this column existed get a random one. Unique indexes on a
+-- hypertable must include the time column.
+ALTER TABLE measurements ADD COLUMN IF NOT EXISTS message_id UUID DEFAULT gen_random_uuid();
+CREATE UNIQUE INDEX IF NOT EXISTS ux_measurements_message_id
+  ON measurements (message_id, time);
+
+-- Conversion from the raw sensor unit to SI: si_value = value * scale + "offset"
+CREATE TABLE IF NOT EXISTS measurement_units (
+  sensor_type SMALLINT PRIMARY KEY REFERENCES sensor_types(type_id),
+  scale       DOUBLE PRECISION NOT NULL DEFAULT 1,
+  "offset"    DOUBLE PRECISION NOT NULL DEFAULT 0,
+  si_unit     TEXT NOT NULL
+);
+
+INSERT INTO measurement_units (sensor_type, scale, "offset", si_unit) VALUES
+  (1,  1,     273.15, 'K'),
+  (3,  1,     0,      'Pa'),
+  (4,  1,     0,      'm/s'),
+  (6,  0.001, 0,      'm'),
+  (7,  1,     0,      'W/m²'),
+  (12, 1,     273.15, 'K'),
+  (13, 1,     273.15, 'K'),
+  (14, 1,     273.15, 'K'),
+  (15, 0.01,  0,      'm')
+ON CONFLICT DO NOTHING;
+
+-- A generated column can't look up another table, so si_value is filled in
+-- by a trigger on insert. Types without a measurement_units row stay NULL.
+ALTER TABLE measurements ADD COLUMN IF NOT EXISTS si_value DOUBLE PRECISION;
+
+CREATE OR REPLACE FUNCTION measurements_set_si_value() RETURNS trigger AS $$
+BEGIN
+  SELECT NEW.value * mu.scale + mu."offset" INTO NEW.si_value
+  FROM measurement_units mu WHERE mu.sensor_type = NEW.sensor_type;
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS measurements_si_value ON measurements;
+CREATE TRIGGER measurements_si_value
+  BEFORE INSERT ON measurements
+  FOR EACH ROW EXECUTE FUNCTION measurements_set_si_value();
+
+-- Measurements with sensor type name and unit resolved
+CREATE OR REPLACE VIEW measurements_named AS
+SELECT m.time, m.station_eui, m.station_devid, m.slave_id, m.sensor_type,
+       st.name AS sensor_type_name, st.unit AS sensor_type_unit,
+       m.sensor_index, m.value, m.format, m.gateway_id, m.latitude, m.longitude,
+       m.si_value, mu.si_unit, m.raw_value
+FROM measurements m
+LEFT JOIN measurement_units mu ON mu.sensor_type = m.sensor_type
+LEFT JOIN sensor_types st ON st.type_id = m.sensor_type;
+
+-- Uplinks (distinct measurement timestamps) per station per day
+CREATE OR REPLACE VIEW station_daily_frames AS
+SELECT station_eui, time::date AS day, count(DISTINCT time) AS frames
+FROM measurements
+GROUP BY station_eui, time::date;
+
+-- Last accepted frame counter per device, for replay protection
+CREATE TABLE IF NOT EXISTS device_frame_state (
+  station_eui TEXT PRIMARY KEY,
+  last_fcnt   BIGINT NOT NULL,
+  updated_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+-- TTN session key ID of the device's current session; a new one marks a
+-- rejoin, after which the frame counter starts over.
+ALTER TABLE device_frame_state ADD COLUMN IF NOT EXISTS session_key_id TEXT;
+
+-- Network layer uplink tokens already ingested, for deduplication across
+-- instances. Rows older than UPLINK_TOKEN_TTL_HOURS are deleted.
+CREATE TABLE IF NOT EXISTS uplink_tokens (
+  token       TEXT PRIMARY KEY,
+  station_eui TEXT NOT NULL,
+  received_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS ix_uplink_tokens_received_at
+  ON uplink_tokens (received_at);
+
+-- MQTT connects and connection losses (event_type 'connected' or
+-- 'connection_lost'), deleted after CONNECTION_EVENT_RETENTION days
+CREATE TABLE IF NOT EXISTS connection_events (
+  event_type  TEXT NOT NULL,
+  occurred_at TIMESTAMPTZ NOT NULL,
+  detail      TEXT
+);
+CREATE INDEX IF NOT EXISTS ix_connection_events_occurred_at
+  ON connection_events (occurred_at DESC);
+
+-- Raw MQTT messages as received, stored with -debug and deleted after
+-- DEBUG_RETENTION_HOURS
+CREATE TABLE IF NOT EXISTS debug_mqtt_messages (
+  id          BIGSERIAL PRIMARY KEY,
+  topic       TEXT NOT NULL,
+  payload     BYTEA NOT NULL,
+  qos         SMALLINT NOT NULL,
+  retained    BOOLEAN NOT NULL,
+  received_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS ix_debug_mqtt_messages_received_at
+  ON debug_mqtt_messages (received_at);
+
+-- Anomaly log (values with a z-score above ANOMALY_ZSCORE_THRESHOLD)
+CREATE TABLE IF NOT EXISTS measurements_anomaly (
+  time          TIMESTAMPTZ NOT NULL,
+  station_eui   TEXT NOT NULL,
+  slave_id      INTEGER NOT NULL,
+  sensor_type   SMALLINT NOT NULL,
+  value         DOUBLE PRECISION NOT NULL,
+  zscore        DOUBLE PRECISION NOT NULL,
+  UNIQUE (time, station_eui, slave_id, sensor_type)
+);
+
+-- Failed measurement inserts waiting to be retried (payload is the JSON encoded row)
+CREATE TABLE IF NOT EXISTS retry_queue (
+  id              BIGSERIAL PRIMARY KEY,
+  payload         BYTEA NOT NULL,
+  failed_at       TIMESTAMPTZ NOT NULL DEFAULT now(),
+  attempt_count   INT NOT NULL DEFAULT 0,
+  last_error      TEXT,
+  next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS ix_retry_queue_next_attempt
+  ON retry_queue (next_attempt_at);
+
+-- Downlink queued/sent events, for a full uplink/downlink log per device
+CREATE TABLE IF NOT EXISTS downlinks (
+  time           TIMESTAMPTZ NOT NULL,
+  station_eui    TEXT NOT NULL,
+  event          TEXT NOT NULL,              -- queued | sent
+  f_port         INTEGER,
+  f_cnt          BIGINT,
+  frm_payload    TEXT,                       -- base64
+  confirmed      BOOLEAN NOT NULL DEFAULT false,
+  correlation_id TEXT
+);
+CREATE INDEX IF NOT EXISTS ix_downlinks_station_time
+  ON downlinks (station_eui, time DESC);
+
 -- Uplink table for RF stats
 CREATE TABLE IF NOT EXISTS uplinks (
   event_time    TIMESTAMPTZ NOT NULL,
@@ -86,18 +363,112 @@ CREATE TABLE IF NOT EXISTS uplinks (
   latitude      DOUBLE PRECISION,
   longitude     DOUBLE PRECISION
 );
-SELECT create_hypertable('uplinks', 'event_time', if_not_exists => TRUE);
 
--- Hourly aggregate
-CREATE MATERIALIZED VIEW IF NOT EXISTS measurements_hourly
-WITH (timescaledb.continuous) AS
-SELECT time_bucket('1 hour', time) AS bucket,
-       station_eui, slave_id, sensor_type, sensor_index,
-       avg(value) AS avg_value, min(value) AS min_value, max(value) AS max_value
-FROM measurements
-GROUP BY bucket, station_eui, slave_id, sensor_type, sensor_index;
+-- Hypertables and the hourly aggregate. Every TimescaleDB call lives in this
+-- block so the schema applies on plain PostgreSQL too. With TimescaleDB the
+-- time-series tables become hypertables and measurements_hourly is a
+-- continuous aggregate refreshed every 15 minutes; without it the tables stay
+-- plain and measurements_hourly is a view with the same columns, so queries
+-- against it work either way. The aggregate is created WITH NO DATA
+-- (required inside DO); to fill history older than the policy's 7 day window
+-- run once:
+--   CALL refresh_continuous_aggregate('measurements_hourly', NULL, NULL);
+DO $$
+BEGIN
+  IF EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'timescaledb') THEN
+    PERFORM create_hypertable('measurements', 'time', if_not_exists => TRUE);
+    PERFORM create_hypertable('measurements_anomaly', 'time', if_not_exists => TRUE);
+    PERFORM create_hypertable('downlinks', 'time', if_not_exists => TRUE);
+    PERFORM create_hypertable('uplinks', 'event_time', if_not_exists => TRUE);
+    IF to_regclass('measurements_hourly') IS NULL THEN
+      EXECUTE $sql$
+        CREATE MATERIALIZED VIEW measurements_hourly
+        WITH (timescaledb.continuous) AS
+        SELECT time_bucket('1 hour', time) AS bucket,
+               station_eui, slave_id, sensor_type, sensor_index,
+               avg(value) AS avg_value, min(value) AS min_value, max(value) AS max_value
+        FROM measurements
+        GROUP BY bucket, station_eui, slave_id, sensor_type, sensor_index
+        WITH NO DATA
+      $sql$;
+    END IF;
+    PERFORM add_continuous_aggregate_policy('measurements_hourly',
+      start_offset => INTERVAL '7 days',
+      end_offset   => INTERVAL '1 hour',
+      schedule_interval => INTERVAL '15 minutes',
+      if_not_exists => TRUE);
+  ELSIF to_regclass('measurements_hourly') IS NULL THEN
+    RAISE NOTICE 'timescaledb not installed; measurements_hourly is a plain view';
+    EXECUTE $sql$
+      CREATE VIEW measurements_hourly AS
+      SELECT date_trunc('hour', time) AS bucket,
+             station_eui, slave_id, sensor_type, sensor_index,
+             avg(value) AS avg_value, min(value) AS min_value, max(value) AS max_value
+      FROM measurements
+      GROUP BY bucket, station_eui, slave_id, sensor_type, sensor_index
+    $sql$;
+  END IF;
+END
+$$;
+
+-- Row-level security per TTN application. Tenant roles (not the table owner
+-- the ingestor connects as, which RLS doesn't apply to) see only the rows of
+-- the application in their session setting:
+--   SET app.application_id = 'openclimate';
+-- An unset application_id matches nothing. Views run as their owner, so
+-- grant tenants the tables rather than views such as measurements_named.
+ALTER TABLE stations ENABLE ROW LEVEL SECURITY;
+ALTER TABLE measurements ENABLE ROW LEVEL SECURITY;
+ALTER TABLE gateways ENABLE ROW LEVEL SECURITY;
 
-SELECT add_continuous_aggregate_policy('measurements_hourly',
-  start_offset => INTERVAL '7 days',
-  end_offset   => INTERVAL '1 hour',
-  schedule_interval => INTERVAL '15 minutes');
+DROP POLICY IF EXISTS stations_application ON stations;
+CREATE POLICY stations_application ON stations
+  USING (application_id = current_setting('app.application_id', true));
+
+DROP POLICY IF EXISTS measurements_application ON measurements;
+CREATE POLICY measurements_application ON measurements
+  USING (station_eui IN (
+    SELECT station_eui FROM stations
+    WHERE application_id = current_setting('app.application_id', true)));
+
+-- Gateways are shared between applications; a tenant sees those that relayed
+-- one of its stations' measurements.
+CREATE INDEX IF NOT EXISTS ix_measurements_gateway
+  ON measurements (gateway_id, station_eui);
+DROP POLICY IF EXISTS gateways_application ON gateways;
+CREATE POLICY gateways_application ON gateways
+  USING (EXISTS (
+    SELECT 1 FROM measurements m
+    JOIN stations s ON s.station_eui = m.station_eui
+    WHERE m.gateway_id = gateways.gateway_id
+      AND s.application_id = current_setting('app.application_id', true)));
+
+-- Schema versions
+INSERT INTO schema_migrations (version, description) VALUES
+  (1, 'initial schema, anomaly log, retry queue'),
+  (2, 'measurements_named view'),
+  (3, 'stations.last_uplink_at, station_daily_frames view'),
+  (4, 'device_frame_state'),
+  (5, 'gateway_statistics'),
+  (6, 'measurement_units, measurements.si_value'),
+  (7, 'device_metadata'),
+  (8, 'measurements.raw_value'),
+  (9, 'downlinks'),
+  (10, 'station_group_defs, station_groups'),
+  (11, 'measurements.frm_payload'),
+  (12, 'stations.expected_uplink_interval_seconds, data_completeness'),
+  (13, 'uplink_tokens'),
+  (14, 'measurements_hourly continuous aggregate, plain view without timescaledb'),
+  (15, 'stations.ttn_tenant_id, ttn_cluster_id'),
+  (16, 'sensor_type_discoveries'),
+  (17, 'row-level security per application_id on stations, measurements, gateways'),
+  (18, 'measurements.message_id'),
+  (19, 'data_completeness.expected_messages nullable'),
+  (20, 'device_locations'),
+  (21, 'debug_mqtt_messages'),
+  (22, 'slave_sensor_map'),
+  (23, 'device_keys'),
+  (24, 'connection_events'),
+  (25, 'sensor_calibration'),
+  (26, 'device_frame_state.session_key_id')
+ON CONFLICT DO NOTHING;
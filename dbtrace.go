@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+//--- Query tracing ---//
+
+var dbQueryDurationSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "ingestor_db_query_duration_seconds",
+	Help:    "Duration of DB statements, by statement kind and table (e.g. \"INSERT measurements\").",
+	Buckets: []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5},
+}, []string{"query"})
+
+func init() {
+	prometheus.MustRegister(dbQueryDurationSeconds)
+}
+
+// Slow query logs show at most this many parameters, each cut to
+// maxTracedArgLen characters.
+const (
+	maxTracedArgs   = 20
+	maxTracedArgLen = 64
+)
+
+var sqlTableRe = regexp.MustCompile(`(?i)\b(?:FROM|INTO|UPDATE|TABLE|ON)\s+([a-z_][a-z0-9_.]*)`)
+
+// Names a statement by its first keyword and the first table it touches, so
+// the histogram has one series per kind of statement rather than per SQL
+// string (batch inserts differ in length only).
+func queryName(sql string) string {
+	fields := strings.Fields(sql)
+	if len(fields) == 0 {
+		return "unknown"
+	}
+	verb := strings.ToUpper(fields[0])
+	if verb == "WITH" {
+		verb = "SELECT"
+	}
+	if m := sqlTableRe.FindStringSubmatch(sql); m != nil {
+		return verb + " " + strings.ToLower(m[1])
+	}
+	return verb
+}
+
+// slowQueryTracer is a pgx.QueryTracer timing every statement for
+// ingestor_db_query_duration_seconds and logging those slower than threshold
+// (0 disables logging) at WARN.
+type slowQueryTracer struct {
+	log       Logger
+	threshold time.Duration
+}
+
+type queryTraceKey struct{}
+
+type queryTrace struct {
+	start time.Time
+	sql   string
+	args  []any
+}
+
+func (t *slowQueryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, queryTraceKey{}, &queryTrace{start: time.Now(), sql: data.SQL, args: data.Args})
+}
+
+func (t *slowQueryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	qt, ok := ctx.Value(queryTraceKey{}).(*queryTrace)
+	if !ok {
+		return
+	}
+	took := time.Since(qt.start)
+	dbQueryDurationSeconds.WithLabelValues(queryName(qt.sql)).Observe(took.Seconds())
+	if t.threshold <= 0 || took < t.threshold {
+		return
+	}
+	sql := strings.Join(strings.Fields(qt.sql), " ")
+	if len(sql) > 500 {
+		sql = sql[:500] + "..."
+	}
+	t.log.Warn("slow query (%s, err: %v): %s args: %s", took.Round(time.Millisecond), data.Err, sql, redactQueryArgs(qt.args))
+}
+
+// Formats query parameters for logging. Byte slices (payloads) are shown by
+// size only and long values are cut, so raw payloads and large documents
+// don't end up in the log.
+func redactQueryArgs(args []any) string {
+	var sb strings.Builder
+	sb.WriteByte('[')
+	for i, a := range args {
+		if i == maxTracedArgs {
+			fmt.Fprintf(&sb, " ...%d more", len(args)-i)
+			break
+		}
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		var s string
+		switch v := a.(type) {
+		case []byte:
+			s = fmt.Sprintf("<%d bytes>", len(v))
+		case *string:
+			if v == nil {
+				s = "<nil>"
+			} else {
+				s = *v
+			}
+		default:
+			s = fmt.Sprint(v)
+		}
+		if len(s) > maxTracedArgLen {
+			s = s[:maxTracedArgLen] + "..."
+		}
+		sb.WriteString(s)
+	}
+	sb.WriteByte(']')
+	return sb.String()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//--- DB write smoke test ---//
+
+// Station EUI of the -test-db-write row; not a valid EUI, so it can't clash
+// with a real device.
+const testWriteStationEUI = "TEST-0000000000000000"
+
+const (
+	selectTestMeasurementSQL = `SELECT value FROM measurements WHERE message_id = $1;`
+	deleteTestMeasurementSQL = `DELETE FROM measurements WHERE message_id = $1;`
+)
+
+// Inserts a synthetic measurement with the ingest statement, reads it back
+// and deletes it again, printing PASS/FAIL per step. Returns the exit code:
+// 0 if the round trip worked, 1 otherwise. The row is deleted even when a
+// later step fails.
+func runDBWriteTest(ctx context.Context, w io.Writer, pool *pgxpool.Pool) int {
+	ctx, cancel := context.WithTimeout(ctx, connectivityCheckTimeout)
+	defer cancel()
+
+	const want = 42.0
+	r := MeasurementRow{
+		Time: time.Now().UTC(), StationEUI: testWriteStationEUI,
+		SlaveID: 0, SensorType: 1, SensorIndex: 0, Value: want,
+	}
+	id := r.messageID()
+
+	step := func(name string, f func() error) bool {
+		start := time.Now()
+		if err := f(); err != nil {
+			fmt.Fprintf(w, "FAIL    %s: %v\n", name, err)
+			return false
+		}
+		fmt.Fprintf(w, "PASS    %s (%s)\n", name, time.Since(start).Round(time.Millisecond))
+		return true
+	}
+
+	inserted := step("insert", func() error {
+		tag, err := pool.Exec(ctx, insertMeasurementSQL,
+			r.Time, r.StationEUI, r.StationDevID, r.SlaveID, r.SensorType, r.SensorIndex, r.Value, r.Format,
+			r.GatewayID, r.Latitude, r.Longitude, r.RawValue, r.FrmPayload, id)
+		if err == nil && tag.RowsAffected() != 1 {
+			err = fmt.Errorf("%d rows inserted", tag.RowsAffected())
+		}
+		return err
+	})
+	if !inserted {
+		return 1
+	}
+
+	ok := step("select", func() error {
+		var got float64
+		if err := pool.QueryRow(ctx, selectTestMeasurementSQL, id).Scan(&got); err != nil {
+			return err
+		}
+		if got != want {
+			return fmt.Errorf("read back value %v, want %v", got, want)
+		}
+		return nil
+	})
+	ok = step("delete", func() error {
+		tag, err := pool.Exec(ctx, deleteTestMeasurementSQL, id)
+		if err == nil && tag.RowsAffected() != 1 {
+			err = errors.New("test row not found")
+		}
+		return err
+	}) && ok
+	if !ok {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//--- Raw MQTT message log (-debug) ---//
+
+// How often expired debug messages are deleted.
+const debugMessageCleanupInterval = time.Hour
+
+const insertDebugMQTTMessageSQL = `
+INSERT INTO debug_mqtt_messages(topic, payload, qos, retained, received_at)
+VALUES ($1, $2, $3, $4, $5);
+`
+
+const deleteExpiredDebugMQTTMessagesSQL = `
+DELETE FROM debug_mqtt_messages WHERE received_at < now() - $1::interval;
+`
+
+// Set with -debug: every received MQTT message is stored as is in
+// debug_mqtt_messages.
+var debugMessages *debugMessageLog
+
+type debugMessageLog struct {
+	log  Logger
+	pool *pgxpool.Pool
+}
+
+// Deletes messages older than retention in the background until ctx is
+// cancelled.
+func newDebugMessageLog(ctx context.Context, lg Logger, pool *pgxpool.Pool, retention time.Duration) *debugMessageLog {
+	d := &debugMessageLog{log: lg, pool: pool}
+	go d.runCleanup(ctx, retention)
+	return d
+}
+
+// Stores msg with its raw (possibly still gzipped) payload.
+func (d *debugMessageLog) record(ctx context.Context, msg mqtt.Message, receivedAt time.Time) {
+	if _, err := d.pool.Exec(ctx, insertDebugMQTTMessageSQL,
+		msg.Topic(), msg.Payload(), int16(msg.Qos()), msg.Retained(), receivedAt); err != nil {
+		stats.DBErrors.Add(1)
+		d.log.Error("debug message insert error: %v (topic: %s)", err, msg.Topic())
+	}
+}
+
+func (d *debugMessageLog) runCleanup(ctx context.Context, retention time.Duration) {
+	t := time.NewTicker(debugMessageCleanupInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			tag, err := d.pool.Exec(ctx, deleteExpiredDebugMQTTMessagesSQL, retention)
+			if err != nil {
+				d.log.Error("debug message cleanup error: %v", err)
+				continue
+			}
+			d.log.Debug("deleted %d expired debug messages", tag.RowsAffected())
+		}
+	}
+}
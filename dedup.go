@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//--- Uplink token deduplication ---//
+
+// How often expired tokens are deleted.
+const uplinkTokenCleanupInterval = time.Hour
+
+const insertUplinkTokenSQL = `
+INSERT INTO uplink_tokens(token, station_eui, received_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (token) DO NOTHING;
+`
+
+const deleteExpiredUplinkTokensSQL = `
+DELETE FROM uplink_tokens WHERE received_at < now() - $1::interval;
+`
+
+// uplinkTokenGuard wraps a Sink and drops uplinks whose uplink_token has
+// already been recorded in uplink_tokens, by this or any other instance.
+// The token identifies the frame at the network layer, so unlike FCnt or
+// timestamp checks it isn't fooled by rejoins or clock skew.
+type uplinkTokenGuard struct {
+	log   Logger
+	pool  *pgxpool.Pool
+	inner Sink
+}
+
+// Wraps inner and deletes tokens older than ttl in the background until ctx
+// is cancelled.
+func newUplinkTokenGuard(ctx context.Context, lg Logger, pool *pgxpool.Pool, inner Sink, ttl time.Duration) *uplinkTokenGuard {
+	g := &uplinkTokenGuard{log: lg, pool: pool, inner: inner}
+	go g.runCleanup(ctx, ttl)
+	return g
+}
+
+func (g *uplinkTokenGuard) InsertMeasurements(ctx context.Context, p *Parsed) error {
+	token := uplinkToken(&p.Msg)
+	if token == "" {
+		return g.inner.InsertMeasurements(ctx, p)
+	}
+	tag, err := g.pool.Exec(ctx, insertUplinkTokenSQL, token, p.StationEUI, p.When)
+	if err != nil {
+		stats.DBErrors.Add(1)
+		g.log.Error("uplink token insert error: %v (eui: %s)", err, p.StationEUI)
+		// Fail open, as the replay guard does.
+		return g.inner.InsertMeasurements(ctx, p)
+	}
+	if tag.RowsAffected() == 0 {
+		g.log.Debug("dropping duplicate uplink from %s (fcnt %d): token already seen", p.StationEUI, p.Msg.FCnt)
+		return nil
+	}
+	return g.inner.InsertMeasurements(ctx, p)
+}
+
+func (g *uplinkTokenGuard) Close() error {
+	closeSink(g.inner)
+	return nil
+}
+
+func (g *uplinkTokenGuard) runCleanup(ctx context.Context, ttl time.Duration) {
+	t := time.NewTicker(uplinkTokenCleanupInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			tag, err := g.pool.Exec(ctx, deleteExpiredUplinkTokensSQL, ttl)
+			if err != nil {
+				g.log.Error("uplink token cleanup error: %v", err)
+				continue
+			}
+			g.log.Debug("deleted %d expired uplink tokens", tag.RowsAffected())
+		}
+	}
+}
+
+// Returns the token of the first gateway that reported one. Every copy of a
+// frame delivered to the ingestor carries the same rx_metadata, so the first
+// token is enough to recognise it.
+func uplinkToken(msg *UplinkMsg) string {
+	for _, rm := range msg.RxMetadata {
+		if rm.UplinkToken != "" {
+			return rm.UplinkToken
+		}
+	}
+	return ""
+}
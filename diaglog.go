@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+//--- MQTT diagnostic logging ---//
+
+// slog.Handler publishing every record at WARN and above as a JSON object to
+// an MQTT topic, for edge deployments where MQTT is the only way out:
+//
+//	{"time": "...", "level": "WARN", "msg": "...", "service": "...", "version": "..."}
+//
+// Publishing never blocks the caller; failures go to fallback only, so they
+// can't feed back into the topic.
+type mqttLogHandler struct {
+	client   mqtt.Client
+	topic    string
+	fallback Logger
+	attrs    []slog.Attr
+	group    string
+}
+
+func newMQTTLogHandler(client mqtt.Client, topic string, fallback Logger) *mqttLogHandler {
+	return &mqttLogHandler{client: client, topic: topic, fallback: fallback}
+}
+
+func (h *mqttLogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= slog.LevelWarn
+}
+
+func (h *mqttLogHandler) Handle(_ context.Context, r slog.Record) error {
+	out := map[string]any{
+		"time":  r.Time.UTC().Format(time.RFC3339Nano),
+		"level": r.Level.String(),
+		"msg":   r.Message,
+	}
+	for _, a := range h.attrs {
+		out[a.Key] = a.Value.Any()
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		out[h.key(a.Key)] = a.Value.Any()
+		return true
+	})
+	b, err := json.Marshal(out)
+	if err != nil {
+		return err
+	}
+	token := h.client.Publish(h.topic, 0, false, b)
+	go func() {
+		if token.Wait() && token.Error() != nil {
+			h.fallback.Error("diagnostic publish error: %v", token.Error())
+		}
+	}()
+	return nil
+}
+
+func (h *mqttLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = append([]slog.Attr(nil), h.attrs...)
+	for _, a := range attrs {
+		h2.attrs = append(h2.attrs, slog.Attr{Key: h.key(a.Key), Value: a.Value})
+	}
+	return &h2
+}
+
+func (h *mqttLogHandler) WithGroup(name string) slog.Handler {
+	h2 := *h
+	h2.group = h.key(name)
+	return &h2
+}
+
+// Flattens grouped keys as group.key.
+func (h *mqttLogHandler) key(k string) string {
+	if h.group == "" {
+		return k
+	}
+	return h.group + "." + k
+}
+
+// diagnosticLogger is a Logger that also sends warnings and errors to an
+// slog.Logger once attach is called (the MQTT client is created after the
+// logger is handed out).
+type diagnosticLogger struct {
+	Logger
+	slog atomic.Pointer[slog.Logger]
+}
+
+func newDiagnosticLogger(base Logger) *diagnosticLogger {
+	return &diagnosticLogger{Logger: base}
+}
+
+// Starts publishing WARN and ERROR lines to topic through client, tagged
+// with the service name and version.
+func (d *diagnosticLogger) attach(client mqtt.Client, topic, serviceName string) {
+	l := slog.New(newMQTTLogHandler(client, topic, d.Logger)).With("service", serviceName, "version", version)
+	d.slog.Store(l)
+}
+
+func (d *diagnosticLogger) Warn(format string, args ...any) {
+	d.Logger.Warn(format, args...)
+	if l := d.slog.Load(); l != nil {
+		l.Warn(fmt.Sprintf(format, args...))
+	}
+}
+
+func (d *diagnosticLogger) Error(format string, args ...any) {
+	d.Logger.Error(format, args...)
+	if l := d.slog.Load(); l != nil {
+		l.Error(fmt.Sprintf(format, args...))
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//--- Sensor type autodiscovery ---//
+
+// Set from SENSOR_AUTODISCOVERY: readings of types missing from
+// validSensorTypes are stored instead of skipped, and each new type is
+// recorded in sensor_type_discoveries.
+var sensorAutodiscovery bool
+
+// Keeps the first sighting of each type.
+const insertSensorTypeDiscoverySQL = `
+INSERT INTO sensor_type_discoveries(type_id, first_seen_at, station_eui, sample_value)
+VALUES ($1,$2,$3,$4)
+ON CONFLICT (type_id) DO NOTHING;
+`
+
+// Types already recorded by this process, so the table is written once per
+// type rather than once per reading.
+var discoveredSensorTypes sync.Map
+
+func recordSensorTypeDiscovery(ctx context.Context, lg Logger, pool *pgxpool.Pool, sensorType int, eui string, value float64, when time.Time) {
+	if _, seen := discoveredSensorTypes.LoadOrStore(sensorType, true); seen {
+		return
+	}
+	if _, err := pool.Exec(ctx, insertSensorTypeDiscoverySQL, sensorType, when, eui, value); err != nil {
+		discoveredSensorTypes.Delete(sensorType)
+		stats.DBErrors.Add(1)
+		lg.Error("sensor type discovery insert error: %v (type: %d eui: %s)", err, sensorType, eui)
+		return
+	}
+	lg.Info("discovered unknown sensor type %d from %s (sample value: %v)", sensorType, eui, value)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//--- Downlink events ---//
+
+// When set, downlink queued/sent events are logged to the downlinks table.
+var downlinks *downlinkLog
+
+const insertDownlinkSQL = `
+INSERT INTO downlinks(time, station_eui, event, f_port, f_cnt, frm_payload, confirmed, correlation_id)
+VALUES ($1,$2,$3,$4,$5,$6,$7,$8);
+`
+
+// The application downlink carried by TTN's downlink events.
+type ApplicationDownlink struct {
+	FPort          int      `json:"f_port"`
+	FCnt           uint32   `json:"f_cnt"`
+	FrmPayload     string   `json:"frm_payload"`
+	Confirmed      bool     `json:"confirmed"`
+	CorrelationIDs []string `json:"correlation_ids"`
+}
+
+// Published on v3/{app}@{tenant}/devices/{dev}/down/queued.
+type DownlinkQueued struct {
+	EndDeviceIDs   EndDeviceIDs         `json:"end_device_ids"`
+	CorrelationIDs []string             `json:"correlation_ids"`
+	ReceivedAt     time.Time            `json:"received_at"`
+	DownlinkQueued *ApplicationDownlink `json:"downlink_queued"`
+}
+
+// Published on v3/{app}@{tenant}/devices/{dev}/down/sent.
+type DownlinkSent struct {
+	EndDeviceIDs   EndDeviceIDs         `json:"end_device_ids"`
+	CorrelationIDs []string             `json:"correlation_ids"`
+	ReceivedAt     time.Time            `json:"received_at"`
+	DownlinkSent   *ApplicationDownlink `json:"downlink_sent"`
+}
+
+type ParsedDownlink struct {
+	When          time.Time
+	StationEUI    string
+	Event         string // "queued" or "sent"
+	Msg           ApplicationDownlink
+	CorrelationID string
+}
+
+// Reports whether topic is one of TTN's downlink event topics (down/queued,
+// down/sent, down/ack, ...). Only queued and sent are logged.
+func isDownlinkTopic(topic string) bool {
+	return strings.Contains(topic, "/down/")
+}
+
+func isLoggedDownlinkTopic(topic string) bool {
+	return strings.HasSuffix(topic, "/down/queued") || strings.HasSuffix(topic, "/down/sent")
+}
+
+// Parses a TTN downlink queued or sent event.
+func parseDownlink(b []byte) (*ParsedDownlink, error) {
+	var q DownlinkQueued
+	if err := json.Unmarshal(b, &q); err != nil {
+		return nil, &ParseError{Reason: "invalid downlink JSON: " + err.Error()}
+	}
+	ids, when, corr, event, msg := q.EndDeviceIDs, q.ReceivedAt, q.CorrelationIDs, "queued", q.DownlinkQueued
+	if msg == nil {
+		var s DownlinkSent
+		if err := json.Unmarshal(b, &s); err != nil {
+			return nil, &ParseError{Reason: "invalid downlink JSON: " + err.Error()}
+		}
+		ids, when, corr, event, msg = s.EndDeviceIDs, s.ReceivedAt, s.CorrelationIDs, "sent", s.DownlinkSent
+	}
+	if msg == nil {
+		return nil, &ParseError{Reason: "unknown TTN downlink event (expecting downlink_queued or downlink_sent)"}
+	}
+	if !validateEUI64(ids.DevEUI) {
+		return nil, &ParseError{Reason: "invalid dev_eui", Value: ids.DevEUI}
+	}
+	if when.IsZero() {
+		when = time.Now()
+	}
+	return &ParsedDownlink{
+		When:          when.UTC(),
+		StationEUI:    strings.ToUpper(ids.DevEUI),
+		Event:         event,
+		Msg:           *msg,
+		CorrelationID: downlinkCorrelationID(append(msg.CorrelationIDs, corr...)),
+	}, nil
+}
+
+// Picks the application server's downlink correlation ID ("as:downlink:..."),
+// which stays the same from queued to sent, falling back to the first one.
+func downlinkCorrelationID(ids []string) string {
+	for _, id := range ids {
+		if strings.HasPrefix(id, "as:downlink:") {
+			return id
+		}
+	}
+	if len(ids) > 0 {
+		return ids[0]
+	}
+	return ""
+}
+
+type downlinkLog struct {
+	log  Logger
+	pool *pgxpool.Pool
+}
+
+func newDownlinkLog(lg Logger, pool *pgxpool.Pool) *downlinkLog {
+	return &downlinkLog{log: lg, pool: pool}
+}
+
+// Parses a downlink event and stores it. Parse and insert errors are logged
+// and returned.
+func (d *downlinkLog) ingest(ctx context.Context, b []byte) error {
+	p, err := parseDownlink(b)
+	if err != nil {
+		stats.ParseErrors.Add(1)
+		d.log.Warn("downlink parse error: %v", err)
+		return err
+	}
+	if _, err := d.pool.Exec(ctx, insertDownlinkSQL,
+		p.When, p.StationEUI, p.Event, p.Msg.FPort, int64(p.Msg.FCnt), nullIfEmpty(p.Msg.FrmPayload),
+		p.Msg.Confirmed, nullIfEmpty(p.CorrelationID),
+	); err != nil {
+		stats.DBErrors.Add(1)
+		d.log.Error("downlink insert error: %v (eui: %s)", err, p.StationEUI)
+		return err
+	}
+	d.log.Debug("logged downlink %s for %s (f_port: %d)", p.Event, p.StationEUI, p.Msg.FPort)
+	return nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//--- CSV export ---//
+
+const exportMeasurementsSQL = `
+SELECT time, slave_id, sensor_type, sensor_index, value, gateway_id, latitude, longitude
+FROM measurements
+WHERE station_eui = $1 AND time >= $2 AND time < $3
+ORDER BY time, slave_id, sensor_type, sensor_index;
+`
+
+// Parses an export range bound given as YYYY-MM-DD or RFC3339. Date-only end
+// bounds are inclusive, so they are moved to the start of the following day.
+func parseDateArg(s string, end bool) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		if end {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q (expecting YYYY-MM-DD or RFC3339)", s)
+	}
+	return t, nil
+}
+
+// Writes all measurements for a station in [start, end) to outPath as CSV.
+// An outPath of "-" writes to stdout.
+func exportCSV(ctx context.Context, pool *pgxpool.Pool, stationEUI, startArg, endArg, outPath string) error {
+	start, err := parseDateArg(startArg, false)
+	if err != nil {
+		return err
+	}
+	end, err := parseDateArg(endArg, true)
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if outPath != "-" {
+		f, err := os.Create(outPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	rows, err := pool.Query(ctx, exportMeasurementsSQL, strings.ToUpper(stationEUI), start, end)
+	if err != nil {
+		return fmt.Errorf("query measurements: %w", err)
+	}
+	defer rows.Close()
+
+	w := csv.NewWriter(out)
+	if err := w.Write([]string{"time", "slave_id", "sensor_type", "sensor_index", "value", "gateway_id", "latitude", "longitude"}); err != nil {
+		return err
+	}
+
+	n := 0
+	for rows.Next() {
+		var (
+			t                 time.Time
+			slaveID           int
+			sensorType, index int16
+			value             float64
+			gwID              *string
+			lat, lon          *float64
+		)
+		if err := rows.Scan(&t, &slaveID, &sensorType, &index, &value, &gwID, &lat, &lon); err != nil {
+			return fmt.Errorf("scan measurement: %w", err)
+		}
+		rec := []string{
+			t.UTC().Format(time.RFC3339),
+			strconv.Itoa(slaveID),
+			strconv.Itoa(int(sensorType)),
+			strconv.Itoa(int(index)),
+			strconv.FormatFloat(value, 'f', -1, 64),
+			derefOr(gwID, ""),
+			formatFloatPtr(lat),
+			formatFloatPtr(lon),
+		}
+		if err := w.Write(rec); err != nil {
+			return err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("read measurements: %w", err)
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "exported %d measurements for %s\n", n, strings.ToUpper(stationEUI))
+	return nil
+}
+
+func derefOr(s *string, d string) string {
+	if s == nil {
+		return d
+	}
+	return *s
+}
+
+func formatFloatPtr(f *float64) string {
+	if f == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*f, 'f', -1, 64)
+}
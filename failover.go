@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+//--- MQTT host failover ---//
+
+// How often the primary host is probed while connected to the fallback.
+const primaryProbeInterval = 30 * time.Second
+
+// hostFailover keeps a single broker in opts.Servers (paho would otherwise
+// try every entry on each attempt) and swaps it for the other host after
+// retries consecutive failed reconnect attempts, alternating between the two
+// while neither accepts. While connected to the fallback the primary is
+// probed, and the client moves back as soon as it accepts TCP connections.
+type hostFailover struct {
+	log     Logger
+	servers [2]*url.URL // primary, fallback
+	retries int
+
+	mu       sync.Mutex
+	active   int                 // index into servers
+	attempts int                 // reconnect attempts on the active host
+	opts     *mqtt.ClientOptions // the client's own options, from the reconnecting handler
+	probing  atomic.Bool
+}
+
+// Hooks failover to fallback host into opts, whose only broker must be the
+// primary. Chains the reconnecting and connect handlers already set.
+func applyHostFailover(lg Logger, opts *mqtt.ClientOptions, fallbackHost string, retries int) {
+	primary := opts.Servers[0]
+	fallback := *primary
+	fallback.Host = net.JoinHostPort(fallbackHost, primary.Port())
+	f := &hostFailover{log: lg, servers: [2]*url.URL{primary, &fallback}, retries: max(retries, 1)}
+
+	prevReconnecting := opts.OnReconnecting
+	opts.SetReconnectingHandler(func(c mqtt.Client, o *mqtt.ClientOptions) {
+		f.reconnecting(o)
+		if prevReconnecting != nil {
+			prevReconnecting(c, o)
+		}
+	})
+	prevConnect := opts.OnConnect
+	opts.SetOnConnectHandler(func(c mqtt.Client) {
+		f.mu.Lock()
+		f.attempts = 0
+		onFallback := f.active == 1
+		f.mu.Unlock()
+		if onFallback && f.probing.CompareAndSwap(false, true) {
+			go f.probePrimary(c)
+		}
+		if prevConnect != nil {
+			prevConnect(c)
+		}
+	})
+}
+
+// Called by paho before every reconnect attempt; the first call of a
+// reconnect cycle precedes the first attempt, so more than retries calls
+// mean retries attempts have failed.
+func (f *hostFailover) reconnecting(o *mqtt.ClientOptions) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.opts = o
+	f.attempts++
+	if f.attempts <= f.retries {
+		return
+	}
+	from := f.servers[f.active]
+	f.active = 1 - f.active
+	f.attempts = 1
+	o.Servers = []*url.URL{f.servers[f.active]}
+	f.log.Warn("mqtt failover at %s: %d reconnect attempts to %s failed, switching to %s",
+		time.Now().UTC().Format(time.RFC3339), f.retries, from.Host, f.servers[f.active].Host)
+}
+
+// Dials the primary every primaryProbeInterval while the client is connected
+// to the fallback, and reconnects to the primary once it answers. Returns
+// when the connection drops; the reconnect cycle takes over from there.
+func (f *hostFailover) probePrimary(c mqtt.Client) {
+	defer f.probing.Store(false)
+	t := time.NewTicker(primaryProbeInterval)
+	defer t.Stop()
+	for range t.C {
+		if !c.IsConnectionOpen() {
+			return
+		}
+		conn, err := net.DialTimeout("tcp", f.servers[0].Host, 5*time.Second)
+		if err != nil {
+			f.log.Debug("primary mqtt host %s still unreachable: %v", f.servers[0].Host, err)
+			continue
+		}
+		conn.Close()
+
+		f.log.Warn("mqtt failback at %s: primary host %s is reachable again, switching from %s",
+			time.Now().UTC().Format(time.RFC3339), f.servers[0].Host, f.servers[1].Host)
+		if f.switchTo(c, 0) == nil {
+			return
+		}
+		f.log.Warn("mqtt failback at %s: could not connect to %s, staying on %s",
+			time.Now().UTC().Format(time.RFC3339), f.servers[0].Host, f.servers[1].Host)
+		if err := f.switchTo(c, 1); err != nil {
+			f.log.Error("mqtt reconnect to %s after failed failback: %v", f.servers[1].Host, err)
+			return
+		}
+	}
+}
+
+// Disconnects c and connects it again to servers[i].
+func (f *hostFailover) switchTo(c mqtt.Client, i int) error {
+	f.mu.Lock()
+	f.active, f.attempts = i, 0
+	f.opts.Servers = []*url.URL{f.servers[i]}
+	f.mu.Unlock()
+
+	c.Disconnect(250)
+	return reconnectMQTT(c)
+}
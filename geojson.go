@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//--- Measurements GeoJSON ---//
+
+// Latest reading of each station per gateway with a known location, then one
+// row per gateway over the stations whose latest reading is within
+// [min_value, max_value]. The point is the newest reported location.
+const selectMeasurementsGeoJSONSQL = `
+WITH latest AS (
+  SELECT DISTINCT ON (m.gateway_id, m.station_eui)
+         m.gateway_id, m.station_eui, m.time, m.latitude, m.longitude, m.value
+  FROM measurements m
+  WHERE m.time >= now() - make_interval(hours => $4)
+    AND m.gateway_id IS NOT NULL AND m.latitude IS NOT NULL AND m.longitude IS NOT NULL
+    AND ($1::smallint IS NULL OR m.sensor_type = $1)
+  ORDER BY m.gateway_id, m.station_eui, m.time DESC
+)
+SELECT l.gateway_id,
+       (array_agg(l.longitude ORDER BY l.time DESC))[1],
+       (array_agg(l.latitude ORDER BY l.time DESC))[1],
+       count(*), max(l.time), gs.avg_rssi
+FROM latest l
+LEFT JOIN gateway_statistics gs ON gs.gateway_id = l.gateway_id
+WHERE ($2::float8 IS NULL OR l.value >= $2)
+  AND ($3::float8 IS NULL OR l.value <= $3)
+GROUP BY l.gateway_id, gs.avg_rssi
+ORDER BY l.gateway_id;
+`
+
+type measurementsGeoProps struct {
+	GatewayID       string    `json:"gateway_id"`
+	StationEUICount int       `json:"station_eui_count"`
+	LastActiveAt    time.Time `json:"last_active_at"`
+	AvgRSSI         *float64  `json:"avg_rssi"`
+}
+
+// GET /api/v1/measurements/geojson?sensor_type=5&min_value=10&max_value=30&hours=24:
+// one GeoJSON point per gateway that relayed a reading in the last hours,
+// counting the stations whose latest reading (of sensor_type) is in range.
+// min_value/max_value need sensor_type, since values of different types
+// aren't comparable.
+func handleMeasurementsGeoJSON(lg Logger, pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		hours := 24
+		if v := q.Get("hours"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				http.Error(w, "invalid hours", http.StatusBadRequest)
+				return
+			}
+			hours = n
+		}
+		var sensorType *int
+		if v := q.Get("sensor_type"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				http.Error(w, "invalid sensor_type", http.StatusBadRequest)
+				return
+			}
+			sensorType = &n
+		}
+		var bounds [2]*float64
+		for i, name := range []string{"min_value", "max_value"} {
+			v := q.Get(name)
+			if v == "" {
+				continue
+			}
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				http.Error(w, "invalid "+name, http.StatusBadRequest)
+				return
+			}
+			bounds[i] = &f
+		}
+		if sensorType == nil && (bounds[0] != nil || bounds[1] != nil) {
+			http.Error(w, "min_value/max_value need sensor_type", http.StatusBadRequest)
+			return
+		}
+
+		rows, err := pool.Query(r.Context(), selectMeasurementsGeoJSONSQL, sensorType, bounds[0], bounds[1], hours)
+		if err != nil {
+			lg.Error("measurements geojson query error: %v", err)
+			http.Error(w, "query failed", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		fc := geoJSONFeatureCollection[measurementsGeoProps]{Type: "FeatureCollection", Features: []geoJSONFeature[measurementsGeoProps]{}}
+		for rows.Next() {
+			f := geoJSONFeature[measurementsGeoProps]{Type: "Feature", Geometry: geoJSONPoint{Type: "Point"}}
+			var stations int64
+			if err := rows.Scan(&f.Properties.GatewayID, &f.Geometry.Coordinates[0], &f.Geometry.Coordinates[1],
+				&stations, &f.Properties.LastActiveAt, &f.Properties.AvgRSSI); err != nil {
+				lg.Error("measurements geojson scan error: %v", err)
+				http.Error(w, "query failed", http.StatusInternalServerError)
+				return
+			}
+			f.Properties.StationEUICount = int(stations)
+			fc.Features = append(fc.Features, f)
+		}
+		if err := rows.Err(); err != nil {
+			lg.Error("measurements geojson query error: %v", err)
+			http.Error(w, "query failed", http.StatusInternalServerError)
+			return
+		}
+		writeGeoJSON(w, fc)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//--- Station groups ---//
+
+const insertStationGroupSQL = `
+INSERT INTO station_group_defs(group_id, name) VALUES ($1,$2);
+`
+
+const selectStationGroupExistsSQL = `
+SELECT EXISTS (SELECT 1 FROM station_group_defs WHERE group_id = $1);
+`
+
+const deleteStationGroupMembersSQL = `
+DELETE FROM station_groups WHERE group_id = $1;
+`
+
+const insertStationGroupMembersSQL = `
+INSERT INTO station_groups(group_id, station_eui)
+SELECT $1, unnest($2::text[]);
+`
+
+// Newest reading of each sensor type per member station, then mean/min/max
+// across the stations.
+const selectStationGroupLatestSQL = `
+WITH latest AS (
+  SELECT DISTINCT ON (m.station_eui, m.sensor_type) m.station_eui, m.sensor_type, m.value, m.time
+  FROM measurements m
+  JOIN station_groups g ON g.station_eui = m.station_eui
+  WHERE g.group_id = $1
+  ORDER BY m.station_eui, m.sensor_type, m.time DESC
+)
+SELECT sensor_type, avg(value), min(value), max(value), count(*), max(time)
+FROM latest
+GROUP BY sensor_type
+ORDER BY sensor_type;
+`
+
+// Max accepted group request body.
+const maxGroupBody = 64 << 10
+
+type stationGroupJSON struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+}
+
+type groupLatestJSON struct {
+	SensorType int       `json:"sensor_type"`
+	Mean       float64   `json:"mean"`
+	Min        float64   `json:"min"`
+	Max        float64   `json:"max"`
+	Stations   int       `json:"stations"`
+	LatestAt   time.Time `json:"latest_at"`
+}
+
+// Decodes a JSON request body into v, or writes a 400 and returns false.
+func readJSONBody(w http.ResponseWriter, r *http.Request, limit int64, v any) bool {
+	b, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	if err != nil {
+		http.Error(w, "read body: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// POST /api/v1/groups with {"id": "...", "name": "..."}.
+func handleCreateGroup(lg Logger, pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var g stationGroupJSON
+		if !readJSONBody(w, r, maxGroupBody, &g) {
+			return
+		}
+		if g.ID == "" {
+			http.Error(w, "missing group id", http.StatusBadRequest)
+			return
+		}
+		if _, err := pool.Exec(r.Context(), insertStationGroupSQL, g.ID, nullIfEmpty(g.Name)); err != nil {
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+				http.Error(w, "group already exists", http.StatusConflict)
+				return
+			}
+			lg.Error("group insert error: %v", err)
+			http.Error(w, "insert failed", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusCreated, g)
+	}
+}
+
+// PUT /api/v1/groups/{id}/stations with a JSON array of station EUIs.
+// Replaces the group's members.
+func handlePutGroupStations(lg Logger, pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		var euis []string
+		if !readJSONBody(w, r, maxGroupBody, &euis) {
+			return
+		}
+		for i, eui := range euis {
+			euis[i] = strings.ToUpper(eui)
+			if !validateEUI64(euis[i]) {
+				http.Error(w, "invalid station EUI: "+eui, http.StatusBadRequest)
+				return
+			}
+		}
+
+		var exists bool
+		if err := pool.QueryRow(r.Context(), selectStationGroupExistsSQL, id).Scan(&exists); err != nil {
+			lg.Error("group query error: %v", err)
+			http.Error(w, "query failed", http.StatusInternalServerError)
+			return
+		}
+		if !exists {
+			http.Error(w, "group not found", http.StatusNotFound)
+			return
+		}
+
+		err := pgx.BeginFunc(r.Context(), pool, func(tx pgx.Tx) error {
+			if _, err := tx.Exec(r.Context(), deleteStationGroupMembersSQL, id); err != nil {
+				return err
+			}
+			_, err := tx.Exec(r.Context(), insertStationGroupMembersSQL, id, euis)
+			return err
+		})
+		if err != nil {
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+				http.Error(w, "unknown station EUI", http.StatusBadRequest)
+				return
+			}
+			lg.Error("group members update error (group: %s): %v", id, err)
+			http.Error(w, "update failed", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, euis)
+	}
+}
+
+// GET /api/v1/groups/{id}/latest: per sensor type, the mean/min/max of the
+// newest reading of every station in the group.
+func handleGroupLatest(lg Logger, pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		var exists bool
+		if err := pool.QueryRow(r.Context(), selectStationGroupExistsSQL, id).Scan(&exists); err != nil {
+			lg.Error("group query error: %v", err)
+			http.Error(w, "query failed", http.StatusInternalServerError)
+			return
+		}
+		if !exists {
+			http.Error(w, "group not found", http.StatusNotFound)
+			return
+		}
+
+		rows, err := pool.Query(r.Context(), selectStationGroupLatestSQL, id)
+		if err != nil {
+			lg.Error("group latest query error: %v", err)
+			http.Error(w, "query failed", http.StatusInternalServerError)
+			return
+		}
+		out := []groupLatestJSON{}
+		var g groupLatestJSON
+		var sensorType int16
+		var stations int64
+		_, err = pgx.ForEachRow(rows, []any{&sensorType, &g.Mean, &g.Min, &g.Max, &stations, &g.LatestAt}, func() error {
+			g.SensorType, g.Stations = int(sensorType), int(stations)
+			out = append(out, g)
+			return nil
+		})
+		if err != nil {
+			lg.Error("group latest query error: %v", err)
+			http.Error(w, "query failed", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, out)
+	}
+}
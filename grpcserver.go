@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	ingestorv1 "weatherbus-lorawan-ingestor/proto/ingestor/v1"
+)
+
+//--- gRPC measurement stream ---//
+
+// When set (GRPC_ADDR), every inserted measurement is passed to the
+// WatchMeasurements subscribers.
+var measurementStream *measurementBroadcaster
+
+// Events buffered per subscriber before further events are dropped for it.
+const watchSubscriberBuffer = 256
+
+var grpcDroppedEvents = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "ingestor_grpc_watch_dropped_events_total",
+	Help: "Measurements not streamed to a WatchMeasurements client because its buffer was full.",
+})
+
+func init() {
+	prometheus.MustRegister(grpcDroppedEvents)
+}
+
+// Fans measurements out to any number of subscribers. publish never blocks:
+// a subscriber that falls behind misses events rather than slowing down
+// ingestion.
+type measurementBroadcaster struct {
+	mu   sync.Mutex
+	subs map[chan *ingestorv1.MeasurementEvent]struct{}
+}
+
+func newMeasurementBroadcaster() *measurementBroadcaster {
+	return &measurementBroadcaster{subs: map[chan *ingestorv1.MeasurementEvent]struct{}{}}
+}
+
+// Returns a channel receiving every published measurement and a function
+// that unsubscribes it.
+func (b *measurementBroadcaster) subscribe() (<-chan *ingestorv1.MeasurementEvent, func()) {
+	ch := make(chan *ingestorv1.MeasurementEvent, watchSubscriberBuffer)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subs, ch)
+		b.mu.Unlock()
+	}
+}
+
+func (b *measurementBroadcaster) publish(r MeasurementRow) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.subs) == 0 {
+		return
+	}
+	ev := &ingestorv1.MeasurementEvent{
+		Time:        timestamppb.New(r.Time),
+		StationEui:  r.StationEUI,
+		SlaveId:     int32(r.SlaveID),
+		SensorType:  int32(r.SensorType),
+		SensorIndex: int32(r.SensorIndex),
+		Value:       r.Value,
+	}
+	if r.GatewayID != nil {
+		ev.GatewayId = *r.GatewayID
+	}
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+			grpcDroppedEvents.Inc()
+		}
+	}
+}
+
+func broadcastMeasurement(r MeasurementRow) {
+	if measurementStream != nil {
+		measurementStream.publish(r)
+	}
+}
+
+// GRPCServer implements ingestor.v1.IngestorService.
+type GRPCServer struct {
+	ingestorv1.UnimplementedIngestorServiceServer
+	log    Logger
+	stream *measurementBroadcaster
+}
+
+func (s *GRPCServer) WatchMeasurements(req *ingestorv1.WatchRequest, srv grpc.ServerStreamingServer[ingestorv1.MeasurementEvent]) error {
+	events, unsubscribe := s.stream.subscribe()
+	defer unsubscribe()
+
+	ctx := srv.Context()
+	s.log.Debug("grpc: watch started (stations: %v, types: %v)", req.StationEuis, req.SensorTypes)
+	for {
+		select {
+		case <-ctx.Done():
+			s.log.Debug("grpc: watch ended: %v", ctx.Err())
+			return nil
+		case ev := <-events:
+			if len(req.StationEuis) > 0 && !slices.Contains(req.StationEuis, ev.StationEui) {
+				continue
+			}
+			if len(req.SensorTypes) > 0 && !slices.Contains(req.SensorTypes, ev.SensorType) {
+				continue
+			}
+			if err := srv.Send(ev); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Rejects calls without "authorization: Bearer <token>" metadata.
+func grpcTokenAuth(token string) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		md, _ := metadata.FromIncomingContext(ss.Context())
+		for _, v := range md.Get("authorization") {
+			got, ok := strings.CutPrefix(v, "Bearer ")
+			if ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1 {
+				return handler(srv, ss)
+			}
+		}
+		return status.Error(codes.Unauthenticated, "missing or invalid bearer token")
+	}
+}
+
+// Serves IngestorService on addr until ctx is cancelled. Open streams are
+// closed on shutdown; clients are expected to reconnect.
+//
+// The stream carries every application's measurements regardless of the
+// per-application row security, so it is never served without protection:
+// callers need the bearer token, TLS (with certFile/keyFile) or both.
+func startGRPCServer(ctx context.Context, lg Logger, addr, token, certFile, keyFile string, stream *measurementBroadcaster) error {
+	var opts []grpc.ServerOption
+	if token != "" {
+		opts = append(opts, grpc.StreamInterceptor(grpcTokenAuth(token)))
+	}
+	if certFile != "" || keyFile != "" {
+		creds, err := credentials.NewServerTLSFromFile(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+	if len(opts) == 0 {
+		return errors.New("GRPC_TOKEN or GRPC_TLS_CERT_FILE/GRPC_TLS_KEY_FILE is required")
+	}
+
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	srv := grpc.NewServer(opts...)
+	ingestorv1.RegisterIngestorServiceServer(srv, &GRPCServer{log: lg, stream: stream})
+
+	go func() {
+		<-ctx.Done()
+		srv.Stop()
+	}()
+
+	go func() {
+		lg.Info("grpc server listening on %s", addr)
+		if err := srv.Serve(lis); err != nil {
+			lg.Error("grpc server error: %v", err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strings"
+	"testing"
+)
+
+func gzipped(t *testing.T, b []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestMaybeGunzip(t *testing.T) {
+	plain := []byte(`{"end_device_ids":{}}`)
+	huge := gzipped(t, make([]byte, maxDecompressedPayload+1))
+	corrupt := gzipped(t, plain)
+	corrupt[len(corrupt)-5] ^= 0xff // CRC
+
+	tests := []struct {
+		name string
+		in   []byte
+		want []byte
+		warn string
+	}{
+		{"plain JSON", plain, plain, ""},
+		{"gzip", gzipped(t, plain), plain, ""},
+		{"corrupt gzip", corrupt, corrupt, "invalid checksum"},
+		{"over the limit", huge, huge, "larger than"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lg := &TestLogger{}
+			got := maybeGunzip(lg, tt.in)
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("got %d bytes, want %d", len(got), len(tt.want))
+			}
+			warns := lg.messages("WARN")
+			if tt.warn == "" && len(warns) > 0 || tt.warn != "" && (len(warns) != 1 || !strings.Contains(warns[0], tt.warn)) {
+				t.Errorf("warnings %q, want %q", warns, tt.warn)
+			}
+		})
+	}
+}
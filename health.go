@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+//--- Health server ---//
+
+// Serves /healthz (process is up), /readyz (DB and MQTT are reachable;
+// client is nil when not running in MQTT mode), Prometheus /metrics and the
+// /api/v1 endpoints.
+// When enablePprof is set the net/http/pprof handlers are mounted under
+// /debug/pprof as well; keep it off unless the port is not publicly exposed.
+func startHealthServer(ctx context.Context, lg Logger, addr string, pool *pgxpool.Pool, client mqtt.Client, enablePprof bool) {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok\n"))
+	})
+
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		pingCtx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
+		if err := pool.Ping(pingCtx); err != nil {
+			http.Error(w, "db not ready: "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		if multiApp != nil && !multiApp.Connected() {
+			http.Error(w, "mqtt not connected for every application", http.StatusServiceUnavailable)
+			return
+		}
+		if client != nil && !client.IsConnectionOpen() {
+			http.Error(w, "mqtt not connected", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ready\n"))
+	})
+
+	mux.Handle("/metrics", promhttp.Handler())
+	registerAPIRoutes(mux, lg, pool)
+
+	if enablePprof {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		lg.Info("pprof enabled on %s/debug/pprof/", addr)
+	}
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	go func() {
+		lg.Info("health server listening on %s", addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			lg.Error("health server error: %v", err)
+		}
+	}()
+}
+
+// Checks /readyz of a running instance on localhost. Used as the Docker
+// HEALTHCHECK so the image doesn't need curl or wget.
+func ping(port string) error {
+	c := &http.Client{Timeout: 3 * time.Second}
+	resp, err := c.Get("http://localhost:" + port + "/readyz")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("readyz returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Runs SELECT 1 every interval and logs the round trip, at WARN once it
+// exceeds warnAfter, to give a baseline for spotting DB degradation.
+func runDBLatencyCheck(ctx context.Context, lg Logger, pool *pgxpool.Pool, interval, warnAfter time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			checkCtx, cancel := context.WithTimeout(ctx, interval)
+			start := time.Now()
+			_, err := pool.Exec(checkCtx, "SELECT 1")
+			latency := time.Since(start)
+			cancel()
+			switch {
+			case err != nil:
+				if ctx.Err() == nil {
+					lg.Error("db healthcheck error after %s: %v", latency.Round(time.Millisecond), err)
+				}
+			case latency > warnAfter:
+				lg.Warn("db healthcheck: latency %s (above %s)", latency.Round(time.Millisecond), warnAfter)
+			default:
+				lg.Info("db healthcheck: latency %s", latency.Round(time.Millisecond))
+			}
+		}
+	}
+}
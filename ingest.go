@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/subtle"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+//--- HTTP ingest API ---//
+
+// When set (INGEST_API_TOKENS), POST /api/v1/ingest accepts TTN uplinks
+// from bearer token holders.
+var ingestAPI *ingestHandler
+
+// Feeds TTN /up JSON bodies through ingestUplink for clients that can't use
+// MQTT or the TTN webhook. Every token gets its own token bucket, so one
+// noisy client can't starve the others.
+type ingestHandler struct {
+	log    Logger
+	sink   Sink
+	tokens []string
+	rps    rate.Limit
+	burst  int
+
+	limiters sync.Map // token -> *rate.Limiter
+}
+
+func newIngestHandler(lg Logger, sink Sink, tokens string, rps float64, burst int) *ingestHandler {
+	h := &ingestHandler{log: lg, sink: sink, rps: rate.Limit(rps), burst: burst}
+	for _, t := range strings.Split(tokens, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			h.tokens = append(h.tokens, t)
+		}
+	}
+	return h
+}
+
+// Returns the configured token matching the request's Authorization:
+// Bearer header, or "".
+func (h *ingestHandler) token(r *http.Request) string {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return ""
+	}
+	for _, t := range h.tokens {
+		if subtle.ConstantTimeCompare([]byte(got), []byte(t)) == 1 {
+			return t
+		}
+	}
+	return ""
+}
+
+// Tokens come from the config, so the map stays bounded without eviction.
+func (h *ingestHandler) limiter(token string) *rate.Limiter {
+	if l, ok := h.limiters.Load(token); ok {
+		return l.(*rate.Limiter)
+	}
+	l, _ := h.limiters.LoadOrStore(token, rate.NewLimiter(h.rps, h.burst))
+	return l.(*rate.Limiter)
+}
+
+func (h *ingestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	token := h.token(r)
+	if token == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !allowRequest(w, h.limiter(token)) {
+		return
+	}
+
+	b, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
+	if err != nil {
+		http.Error(w, "read body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := ingestUplink(r.Context(), h.log, h.sink, b, "", start); err != nil {
+		http.Error(w, err.Error(), ingestErrorStatus(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
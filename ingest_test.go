@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestIngestAPI(t *testing.T) {
+	sink := &captureSink{}
+	// 1 request every 10s after a burst of 2, per token.
+	srv := httptest.NewServer(newIngestHandler(&TestLogger{}, sink, "token-a, token-b", 0.1, 2))
+	defer srv.Close()
+
+	post := func(token, body string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	const uplink = `{"end_device_ids":{"dev_eui":"70B3D57ED0000001","application_ids":{"application_id":"app"}}}`
+
+	steps := []struct {
+		name, token, body string
+		want              int
+	}{
+		{"no token", "", uplink, http.StatusUnauthorized},
+		{"unknown token", "token-c", uplink, http.StatusUnauthorized},
+		{"first of burst", "token-a", uplink, http.StatusNoContent},
+		{"invalid uplink", "token-a", `{}`, http.StatusBadRequest},
+		{"burst used up", "token-a", uplink, http.StatusTooManyRequests},
+		{"other token has its own bucket", "token-b", uplink, http.StatusNoContent},
+	}
+	for _, s := range steps {
+		resp := post(s.token, s.body)
+		if resp.StatusCode != s.want {
+			t.Fatalf("%s: status %d, want %d", s.name, resp.StatusCode, s.want)
+		}
+		if resp.StatusCode == http.StatusTooManyRequests {
+			if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err != nil || secs < 1 || secs > 10 {
+				t.Errorf("%s: Retry-After %q, want 1-10 seconds", s.name, resp.Header.Get("Retry-After"))
+			}
+		}
+	}
+	if len(sink.got) != 2 {
+		t.Errorf("%d uplinks ingested, want 2", len(sink.got))
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+//--- Kafka sink ---//
+
+// KafkaSink publishes each parsed uplink as JSON, keyed by station EUI so a
+// station's uplinks stay on one partition.
+type KafkaSink struct {
+	log Logger
+	w   *kafka.Writer
+}
+
+func NewKafkaSink(lg Logger, brokers, topic string) *KafkaSink {
+	return &KafkaSink{
+		log: lg,
+		w: &kafka.Writer{
+			Addr:         kafka.TCP(strings.Split(brokers, ",")...),
+			Topic:        topic,
+			Balancer:     &kafka.Hash{},
+			BatchTimeout: 50 * time.Millisecond,
+			RequiredAcks: kafka.RequireOne,
+		},
+	}
+}
+
+func (k *KafkaSink) InsertMeasurements(ctx context.Context, p *Parsed) error {
+	q, err := effectiveParsed(p)
+	if err != nil {
+		k.log.Error("kafka encode error: %v (eui: %s)", err, p.StationEUI)
+		return err
+	}
+	b, err := json.Marshal(q)
+	if err != nil {
+		k.log.Error("kafka encode error: %v (eui: %s)", err, p.StationEUI)
+		return err
+	}
+	if err := k.w.WriteMessages(ctx, kafka.Message{Key: []byte(p.StationEUI), Value: b}); err != nil {
+		k.log.Error("kafka publish error: %v (eui: %s)", err, p.StationEUI)
+		return err
+	}
+	return nil
+}
+
+func (k *KafkaSink) Close() error { return k.w.Close() }
+
+//--- Kafka consumer (MODE=kafka) ---//
+
+// Delay before a message is ingested again after errIngestUnavailable.
+const kafkaRetryDelay = 5 * time.Second
+
+// Consumes TTN uplink JSON from topic as part of consumer group groupID and
+// feeds each message through ingestUplink, for setups where a separate
+// bridge moves uplinks from TTN into Kafka. Offsets are committed once a
+// message has been handled; unparseable messages are committed too, so they
+// can't stall the partition. A message that fails with errIngestUnavailable
+// is retried, without committing, until it goes through. Returns when ctx is
+// cancelled.
+func runKafkaConsumer(ctx context.Context, lg Logger, sink Sink, brokers, topic, groupID string) {
+	r := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:  strings.Split(brokers, ","),
+		GroupID:  groupID,
+		Topic:    topic,
+		MinBytes: 1,
+		MaxBytes: maxDecompressedPayload,
+	})
+	defer r.Close()
+
+	lg.Info("consuming %s as group %s", topic, groupID)
+	for {
+		m, err := r.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				lg.Error("kafka fetch error: %v", err)
+			}
+			return
+		}
+		start := time.Now()
+		b := m.Value
+		if gzipPayloads {
+			b = maybeGunzip(lg, b)
+		}
+		err = ingestUplink(ctx, lg, sink, b, "", start)
+		for errors.Is(err, errIngestUnavailable) {
+			lg.Warn("kafka: retrying %s/%d offset %d in %s", m.Topic, m.Partition, m.Offset, kafkaRetryDelay)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(kafkaRetryDelay):
+			}
+			err = ingestUplink(ctx, lg, sink, b, "", time.Now())
+		}
+		if err != nil {
+			lg.Warn("kafka: skipped %s/%d offset %d", m.Topic, m.Partition, m.Offset)
+		}
+		if err := r.CommitMessages(ctx, m); err != nil && ctx.Err() == nil {
+			lg.Error("kafka commit error: %v", err)
+		}
+	}
+}
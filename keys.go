@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+//--- TTN API key rotation ---//
+
+// When set (TTN_API_KEY_LIST), the MQTT password is taken from this pool
+// instead of MQTT_PASSWORD.
+var apiKeys *keyPool
+
+// An ordered list of API keys; the current one is used until a connection
+// attempt fails, then the next one is tried.
+type keyPool struct {
+	log Logger
+
+	mu     sync.Mutex
+	keys   []string
+	cur    int
+	client mqtt.Client // set once connected, for rotateAndReconnect
+}
+
+// Builds a pool from a comma separated key list. Returns nil for an empty list.
+func newKeyPool(lg Logger, list string) *keyPool {
+	var keys []string
+	for _, k := range strings.Split(list, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return &keyPool{log: lg, keys: keys}
+}
+
+func (kp *keyPool) current() string {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+	return kp.keys[kp.cur]
+}
+
+// Moves to the next key and returns its position (1-based) in the list.
+func (kp *keyPool) rotate() int {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+	kp.cur = (kp.cur + 1) % len(kp.keys)
+	kp.log.Warn("switching to TTN API key %d of %d", kp.cur+1, len(kp.keys))
+	return kp.cur + 1
+}
+
+func (kp *keyPool) size() int {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+	return len(kp.keys)
+}
+
+// Rotates to the next key and reconnects the MQTT client with it. If that
+// key is rejected the following ones are tried, so the client is never left
+// disconnected while a working key exists. Returns the key position in use.
+func (kp *keyPool) rotateAndReconnect() (int, error) {
+	kp.rotate()
+	kp.mu.Lock()
+	client := kp.client
+	kp.mu.Unlock()
+	if client != nil {
+		client.Disconnect(250)
+		if err := kp.connect(client); err != nil {
+			return 0, err
+		}
+	}
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+	return kp.cur + 1, nil
+}
+
+// Hooks the pool into the client options: the password is read from the
+// pool on every (re)connect, and every failed reconnect attempt moves on to
+// the next key.
+func (kp *keyPool) apply(opts *mqtt.ClientOptions, username string) {
+	opts.SetCredentialsProvider(func() (string, string) {
+		return username, kp.current()
+	})
+	var attempts atomic.Int32
+	opts.SetReconnectingHandler(func(mqtt.Client, *mqtt.ClientOptions) {
+		if attempts.Add(1) > 1 {
+			kp.rotate()
+		}
+	})
+	prev := opts.OnConnect
+	opts.SetOnConnectHandler(func(c mqtt.Client) {
+		attempts.Store(0)
+		if prev != nil {
+			prev(c)
+		}
+	})
+}
+
+// Connects client, trying each key in turn until one is accepted.
+func (kp *keyPool) connect(client mqtt.Client) error {
+	var err error
+	for range kp.size() {
+		token := client.Connect()
+		if token.Wait(); token.Error() == nil {
+			kp.mu.Lock()
+			kp.client = client
+			kp.mu.Unlock()
+			return nil
+		}
+		err = token.Error()
+		if errors.Is(err, packets.ErrorRefusedNotAuthorised) || errors.Is(err, packets.ErrorRefusedBadUsernameOrPassword) {
+			kp.log.Warn("mqtt rejected TTN API key: %v", err)
+		} else {
+			kp.log.Warn("mqtt connect error: %v", err)
+		}
+		kp.rotate()
+	}
+	return fmt.Errorf("all %d TTN API keys failed, last error: %w", kp.size(), err)
+}
+
+// How long before TTN_API_KEY_EXPIRES_AT the key is rotated out.
+const keyExpiryLead = 10 * time.Minute
+
+// Switches to the next key of apiKeys keyExpiryLead before expiresAt, the
+// expiry of the key in use at startup, reconnecting the MQTT client with it.
+// The next key's expiry isn't known, so this runs once. Without another key
+// to rotate to it can only warn.
+func runKeyExpiryMonitor(ctx context.Context, lg Logger, expiresAt time.Time) {
+	var startKey string
+	if apiKeys != nil {
+		startKey = apiKeys.current()
+	}
+	t := time.NewTimer(time.Until(expiresAt.Add(-keyExpiryLead)))
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return
+	case <-t.C:
+	}
+
+	if apiKeys == nil || apiKeys.size() < 2 {
+		lg.Warn("TTN API key expires at %s and TTN_API_KEY_LIST has no other key to rotate to", expiresAt.Format(time.RFC3339))
+		return
+	}
+	if apiKeys.current() != startKey {
+		lg.Info("TTN API key expires at %s but was already rotated out", expiresAt.Format(time.RFC3339))
+		return
+	}
+	n, err := apiKeys.rotateAndReconnect()
+	if err != nil {
+		lg.Error("TTN API key rotation before expiry failed: %v", err)
+		return
+	}
+	lg.Info("TTN API key expires at %s; reconnected with key %d of %d", expiresAt.Format(time.RFC3339), n, apiKeys.size())
+}
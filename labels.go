@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//--- Sensor position labels ---//
+
+const selectSensorLabelsSQL = `
+SELECT slave_id, sensor_type, sensor_index, label, installed_at
+FROM slave_sensor_map
+WHERE station_eui = $1
+ORDER BY slave_id, sensor_type, sensor_index;
+`
+
+const upsertSensorLabelSQL = `
+INSERT INTO slave_sensor_map(station_eui, slave_id, sensor_type, sensor_index, label, installed_at)
+VALUES ($1,$2,$3,$4,$5,$6)
+ON CONFLICT (station_eui, slave_id, sensor_type, sensor_index) DO UPDATE
+SET label = EXCLUDED.label, installed_at = EXCLUDED.installed_at;
+`
+
+const deleteSensorLabelSQL = `
+DELETE FROM slave_sensor_map
+WHERE station_eui = $1 AND slave_id = $2 AND sensor_type = $3 AND sensor_index = $4;
+`
+
+type sensorLabelJSON struct {
+	SlaveID     int        `json:"slave_id"`
+	SensorType  int        `json:"sensor_type"`
+	SensorIndex int        `json:"sensor_index"`
+	Label       string     `json:"label"`
+	InstalledAt *time.Time `json:"installed_at"`
+}
+
+func querySensorLabels(r *http.Request, pool *pgxpool.Pool, eui string) ([]sensorLabelJSON, error) {
+	rows, err := pool.Query(r.Context(), selectSensorLabelsSQL, eui)
+	if err != nil {
+		return nil, err
+	}
+	labels := []sensorLabelJSON{}
+	var l sensorLabelJSON
+	var sensorType, sensorIndex int16
+	_, err = pgx.ForEachRow(rows, []any{&l.SlaveID, &sensorType, &sensorIndex, &l.Label, &l.InstalledAt}, func() error {
+		l.SensorType, l.SensorIndex = int(sensorType), int(sensorIndex)
+		labels = append(labels, l)
+		return nil
+	})
+	return labels, err
+}
+
+// Sets the Label of every measurement that has one in slave_sensor_map.
+func applySensorLabels(ms []latestMeasurementJSON, labels []sensorLabelJSON) {
+	byKey := make(map[readingKey]string, len(labels))
+	for _, l := range labels {
+		byKey[readingKey{l.SlaveID, l.SensorType, l.SensorIndex}] = l.Label
+	}
+	for i := range ms {
+		ms[i].Label = byKey[readingKey{ms[i].SlaveID, ms[i].SensorType, ms[i].SensorIndex}]
+	}
+}
+
+// Returns the {slave}/{type}/{index} path values, or false after writing a 400.
+func sensorKeyParams(w http.ResponseWriter, r *http.Request) (readingKey, bool) {
+	var k readingKey
+	for _, p := range []struct {
+		name string
+		v    *int
+	}{{"slave", &k.slaveID}, {"type", &k.sensorType}, {"index", &k.sensorIndex}} {
+		n, err := strconv.Atoi(r.PathValue(p.name))
+		if err != nil {
+			http.Error(w, "invalid "+p.name, http.StatusBadRequest)
+			return k, false
+		}
+		*p.v = n
+	}
+	return k, true
+}
+
+// GET /api/v1/stations/{eui}/sensor-labels
+func handleGetSensorLabels(lg Logger, pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		eui := stationEUIParam(w, r)
+		if eui == "" {
+			return
+		}
+		labels, err := querySensorLabels(r, pool, eui)
+		if err != nil {
+			lg.Error("sensor labels query error: %v", err)
+			http.Error(w, "query failed", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, labels)
+	}
+}
+
+// PUT /api/v1/stations/{eui}/sensor-labels/{slave}/{type}/{index} with
+// {"label": "north probe", "installed_at": "2025-04-01T00:00:00Z"}; creates
+// or replaces the label of that sensor position.
+func handlePutSensorLabel(lg Logger, pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		eui := stationEUIParam(w, r)
+		if eui == "" {
+			return
+		}
+		k, ok := sensorKeyParams(w, r)
+		if !ok {
+			return
+		}
+		var l sensorLabelJSON
+		if !readJSONBody(w, r, maxMetadataBody, &l) {
+			return
+		}
+		if l.Label == "" {
+			http.Error(w, "label is required", http.StatusBadRequest)
+			return
+		}
+		l.SlaveID, l.SensorType, l.SensorIndex = k.slaveID, k.sensorType, k.sensorIndex
+
+		if _, err := pool.Exec(r.Context(), upsertSensorLabelSQL, eui, l.SlaveID, l.SensorType, l.SensorIndex, l.Label, l.InstalledAt); err != nil {
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+				http.Error(w, "station not found", http.StatusNotFound)
+				return
+			}
+			lg.Error("sensor label update error (eui: %s): %v", eui, err)
+			http.Error(w, "update failed", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, l)
+	}
+}
+
+// DELETE /api/v1/stations/{eui}/sensor-labels/{slave}/{type}/{index}
+func handleDeleteSensorLabel(lg Logger, pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		eui := stationEUIParam(w, r)
+		if eui == "" {
+			return
+		}
+		k, ok := sensorKeyParams(w, r)
+		if !ok {
+			return
+		}
+		tag, err := pool.Exec(r.Context(), deleteSensorLabelSQL, eui, k.slaveID, k.sensorType, k.sensorIndex)
+		if err != nil {
+			lg.Error("sensor label delete error (eui: %s): %v", eui, err)
+			http.Error(w, "delete failed", http.StatusInternalServerError)
+			return
+		}
+		if tag.RowsAffected() == 0 {
+			http.Error(w, "label not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import "context"
+
+//--- Device locations ---//
+
+const insertDeviceLocationSQL = `
+INSERT INTO device_locations(station_eui, time, latitude, longitude, altitude, source)
+VALUES ($1,$2,$3,$4,$5,$6)
+ON CONFLICT DO NOTHING;
+`
+
+// Returns the GPS fix a payload decoder reported at the top level of
+// decoded_payload ({"latitude": ..., "longitude": ..., "altitude": ...}).
+// Out of range coordinates and the 0,0 many trackers send without a fix are
+// ignored.
+func deviceFix(dp *DecodedPayload) (lat, lon float64, ok bool) {
+	if dp.Latitude == nil || dp.Longitude == nil {
+		return 0, 0, false
+	}
+	lat, lon = *dp.Latitude, *dp.Longitude
+	if lat < -90 || lat > 90 || lon < -180 || lon > 180 || (lat == 0 && lon == 0) {
+		return 0, 0, false
+	}
+	return lat, lon, true
+}
+
+// Records where the station was at p.When: the device's own GPS fix if it
+// sent one, and the location of the best gateway (gwLat/gwLon, if known) as
+// a coarser 'gateway' sourced estimate.
+func (st *Store) insertDeviceLocations(ctx context.Context, p *Parsed, gwLat, gwLon *float64) error {
+	if lat, lon, ok := deviceFix(&p.Msg.DecodedPayload); ok {
+		if _, err := st.pool.Exec(ctx, insertDeviceLocationSQL,
+			p.StationEUI, p.When, lat, lon, p.Msg.DecodedPayload.Altitude, "device"); err != nil {
+			return err
+		}
+	}
+	if gwLat != nil && gwLon != nil {
+		if _, err := st.pool.Exec(ctx, insertDeviceLocationSQL,
+			p.StationEUI, p.When, *gwLat, *gwLon, nil, "gateway"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
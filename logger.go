@@ -0,0 +1,45 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+)
+
+//--- Logging ---//
+
+// Logger is passed to everything that logs so output can be redirected or
+// captured instead of going through the global log package.
+type Logger interface {
+	Debug(format string, args ...any)
+	Info(format string, args ...any)
+	Warn(format string, args ...any)
+	Error(format string, args ...any)
+}
+
+type stdLogger struct {
+	l     *log.Logger
+	debug bool
+}
+
+// Returns a Logger writing to w; Debug output is dropped unless debug is set.
+func NewLogger(w io.Writer, debug bool) Logger {
+	return &stdLogger{l: log.New(w, "", log.LstdFlags), debug: debug}
+}
+
+// Returns a Logger writing to stderr, like the log package default.
+func NewStdLogger(debug bool) Logger {
+	return NewLogger(os.Stderr, debug)
+}
+
+func (s *stdLogger) Debug(format string, args ...any) {
+	if s.debug {
+		s.l.Printf("[DEBUG] "+format, args...)
+	}
+}
+
+func (s *stdLogger) Info(format string, args ...any) { s.l.Printf(format, args...) }
+
+func (s *stdLogger) Warn(format string, args ...any) { s.l.Printf("[WARN] "+format, args...) }
+
+func (s *stdLogger) Error(format string, args ...any) { s.l.Printf("[ERROR] "+format, args...) }
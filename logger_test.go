@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// One recorded log line.
+type logEntry struct {
+	Level string // DEBUG, INFO, WARN or ERROR
+	Msg   string
+}
+
+// TestLogger records everything logged, Debug included, for assertions.
+type TestLogger struct {
+	mu      sync.Mutex
+	Entries []logEntry
+}
+
+func (l *TestLogger) log(level, format string, args ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.Entries = append(l.Entries, logEntry{level, fmt.Sprintf(format, args...)})
+}
+
+func (l *TestLogger) Debug(format string, args ...any) { l.log("DEBUG", format, args...) }
+func (l *TestLogger) Info(format string, args ...any)  { l.log("INFO", format, args...) }
+func (l *TestLogger) Warn(format string, args ...any)  { l.log("WARN", format, args...) }
+func (l *TestLogger) Error(format string, args ...any) { l.log("ERROR", format, args...) }
+
+// Returns the messages logged at level.
+func (l *TestLogger) messages(level string) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var msgs []string
+	for _, e := range l.Entries {
+		if e.Level == level {
+			msgs = append(msgs, e.Msg)
+		}
+	}
+	return msgs
+}
+
+func TestStdLoggerLevels(t *testing.T) {
+	for _, debug := range []bool{false, true} {
+		var buf bytes.Buffer
+		lg := NewLogger(&buf, debug)
+		lg.Debug("d %d", 1)
+		lg.Info("i %d", 2)
+		lg.Warn("w %d", 3)
+		lg.Error("e %d", 4)
+
+		out := buf.String()
+		for _, want := range []string{"i 2\n", "[WARN] w 3\n", "[ERROR] e 4\n"} {
+			if !strings.Contains(out, want) {
+				t.Errorf("debug=%v: output %q lacks %q", debug, out, want)
+			}
+		}
+		if got := strings.Contains(out, "[DEBUG] d 1\n"); got != debug {
+			t.Errorf("debug=%v: debug line written: %v", debug, got)
+		}
+	}
+}
+
+func TestDecodedPayloadMismatchLogged(t *testing.T) {
+	lg := &TestLogger{}
+	msg := UplinkMsg{RawDecodedPayload: []byte(`{"slaves":"not a list"}`)}
+	decodeDecodedPayload(lg, &msg, "70B3D57ED0000001")
+
+	warns := lg.messages("WARN")
+	if len(warns) != 1 || !strings.Contains(warns[0], "70B3D57ED0000001") || !strings.Contains(warns[0], "not a list") {
+		t.Fatalf("warnings %q, want one naming the device and the raw payload", warns)
+	}
+	if len(msg.DecodedPayload.Slaves) != 0 {
+		t.Errorf("slaves %v after a mismatch, want none", msg.DecodedPayload.Slaves)
+	}
+}
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"os"
 	"os/signal"
 	"strings"
@@ -14,6 +19,7 @@ import (
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -21,45 +27,84 @@ import (
 
 var debug bool
 
-func debugf(format string, args ...any) {
-	if debug {
-		log.Printf("[DEBUG] "+format, args...)
-	}
-}
+//--- Anomaly detection ---//
+
+var anomalyZScoreThreshold = 3.0
+
+//--- Payload compression ---//
+
+// Set from MQTT_PAYLOAD_GZIP; payloads are gunzipped before parsing.
+var gzipPayloads bool
+
+// Upper bound for a decompressed payload, to guard against gzip bombs.
+const maxDecompressedPayload = 4 << 20
 
 //--- JSON types ---//
 
+type ApplicationIDs struct {
+	AppID string `json:"application_id"`
+}
+
+type EndDeviceIDs struct {
+	DeviceID string         `json:"device_id"`
+	DevEUI   string         `json:"dev_eui"`
+	AppIDs   ApplicationIDs `json:"application_ids"`
+}
+
 type UpCommon struct {
-	EndDeviceIDs struct {
-		DeviceID string `json:"device_id"`
-		DevEUI   string `json:"dev_eui"`
-		AppIDs   struct {
-			AppID string `json:"application_id"`
-		} `json:"application_ids"`
-	} `json:"end_device_ids"`
+	EndDeviceIDs EndDeviceIDs `json:"end_device_ids"`
+	// Some TTN versions also put application_ids at the top level.
+	ApplicationIDs ApplicationIDs `json:"application_ids"`
 
 	ReceivedAt    time.Time `json:"received_at"`
 	UplinkMessage UplinkMsg `json:"uplink_message"`
 }
 
 type UplinkMsg struct {
-	FPort          int            `json:"f_port"`
-	DecodedPayload DecodedPayload `json:"decoded_payload"`
-	RxMetadata     []RxMetadata   `json:"rx_metadata"`
-	Settings       UplinkSettings `json:"settings"`
-	ReceivedAt     time.Time      `json:"received_at"`
+	FPort int    `json:"f_port"`
+	FCnt  uint32 `json:"f_cnt"`
+	// base64 encoded application payload
+	FrmPayload string `json:"frm_payload"`
+	// decoded_payload is kept raw and parsed into DecodedPayload separately,
+	// so an unexpected decoder output doesn't reject the whole uplink.
+	RawDecodedPayload json.RawMessage `json:"decoded_payload"`
+	DecodedPayload    DecodedPayload  `json:"-"`
+	RxMetadata        []RxMetadata    `json:"rx_metadata"`
+	Settings          UplinkSettings  `json:"settings"`
+	ReceivedAt        time.Time       `json:"received_at"`
+	NetworkIDs        NetworkIDs      `json:"network_ids"`
+	// Changes whenever the device joins again (TTN V3).
+	SessionKeyID string `json:"session_key_id,omitempty"`
+}
+
+// Network server that handled the uplink; set by TTN V3 and needed to tell
+// apart data from several tenants or clusters.
+type NetworkIDs struct {
+	NetID     string `json:"net_id"`
+	TenantID  string `json:"tenant_id"`
+	ClusterID string `json:"cluster_id"`
 }
 
 type DecodedPayload struct {
-	Slaves []struct {
-		ID      int `json:"id"`
-		Sensors []struct {
-			Format int     `json:"format"`
-			Index  int     `json:"index"`
-			Type   int     `json:"type"`
-			Value  float64 `json:"value"`
-		} `json:"sensors"`
-	} `json:"slaves"`
+	Slaves []slaveReadings `json:"slaves"`
+	// GPS fix of the end device, for decoders that report one.
+	Latitude  *float64 `json:"latitude,omitempty"`
+	Longitude *float64 `json:"longitude,omitempty"`
+	Altitude  *float64 `json:"altitude,omitempty"`
+}
+
+type slaveReadings struct {
+	ID      int             `json:"id"`
+	Sensors []sensorReading `json:"sensors"`
+}
+
+type sensorReading struct {
+	Format int     `json:"format"`
+	Index  int     `json:"index"`
+	Type   int     `json:"type"`
+	Value  float64 `json:"value"`
+	// Reading as received when Value was calibrated (see sensor_calibration).
+	Raw *float64 `json:"raw_value,omitempty"`
 }
 
 type RxMetadata struct {
@@ -67,10 +112,12 @@ type RxMetadata struct {
 		GatewayID string `json:"gateway_id"`
 		EUI       string `json:"eui"`
 	} `json:"gateway_ids"`
-	RSSI     *int       `json:"rssi"`
-	SNR      *float64   `json:"snr"`
-	Time     *time.Time `json:"time"`
-	Location *struct {
+	RSSI *int       `json:"rssi"`
+	SNR  *float64   `json:"snr"`
+	Time *time.Time `json:"time"`
+	// Opaque per-gateway token identifying the frame at the network layer.
+	UplinkToken string `json:"uplink_token"`
+	Location    *struct {
 		Latitude  float64 `json:"latitude"`
 		Longitude float64 `json:"longitude"`
 	} `json:"location"`
@@ -93,17 +140,102 @@ type DirectUp struct {
 }
 
 type Parsed struct {
-	When         time.Time
-	StationEUI   string
-	StationDevID string
-	AppID        string
-	Msg          UplinkMsg
+	When         time.Time `json:"time"`
+	StationEUI   string    `json:"station_eui"`
+	StationDevID string    `json:"station_devid,omitempty"`
+	AppID        string    `json:"application_id,omitempty"`
+	NetID        string    `json:"net_id,omitempty"`
+	TenantID     string    `json:"tenant_id,omitempty"`
+	ClusterID    string    `json:"cluster_id,omitempty"`
+	Msg          UplinkMsg `json:"uplink_message"`
+
+	// Set by frameOrder for a frame that arrived after it gave up waiting
+	// for it, and for uplinks retried from retry_queue; the replay guard
+	// lets them through.
+	lateFrame bool
+	// When the uplink was received, for ingestor_uplink_processing_seconds.
+	received time.Time
 }
 
-func parseUplink(b []byte) (*Parsed, error) {
+// Copy of p for JSON output whose decoded_payload holds the readings actually
+// ingested (DecodedPayload), which may come from RAW_DECODERS, ThingPark or
+// the UDP path, or have had invalid readings dropped, rather than the
+// decoded_payload as received.
+func effectiveParsed(p *Parsed) (*Parsed, error) {
+	q := *p
+	var err error
+	if q.Msg.RawDecodedPayload, err = json.Marshal(p.Msg.DecodedPayload); err != nil {
+		return nil, err
+	}
+	return &q, nil
+}
+
+// Fills msg.DecodedPayload from msg.RawDecodedPayload. A mismatch is logged
+// with the raw JSON (so the struct can be updated) rather than failing.
+func decodeDecodedPayload(lg Logger, msg *UplinkMsg, devEUI string) {
+	raw := msg.RawDecodedPayload
+	if len(raw) == 0 || string(raw) == "null" {
+		return
+	}
+	if err := json.Unmarshal(raw, &msg.DecodedPayload); err != nil {
+		if len(raw) > 2048 {
+			raw = raw[:2048]
+		}
+		lg.Warn("unexpected decoded_payload from %s: %v (raw: %s)", devEUI, err, string(raw))
+		msg.DecodedPayload = DecodedPayload{}
+	}
+}
+
+// ParseError is returned by parseUplink when a payload is rejected.
+// Value holds the offending raw value, if any.
+type ParseError struct {
+	Reason string
+	Value  string
+}
+
+func (e *ParseError) Error() string {
+	if e.Value == "" {
+		return e.Reason
+	}
+	return fmt.Sprintf("%s: %q", e.Reason, e.Value)
+}
+
+// Reports whether s is a 16 hex character EUI-64.
+func validateEUI64(s string) bool {
+	if len(s) != 16 {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns end_device_ids.application_ids.application_id, falling back to the
+// top-level application_ids.
+func (u *UpCommon) appID() string {
+	if id := u.EndDeviceIDs.AppIDs.AppID; id != "" {
+		return id
+	}
+	return u.ApplicationIDs.AppID
+}
+
+func parseUplink(lg Logger, b []byte) (*Parsed, error) {
+	if networkServer == "thingpark" {
+		return parseThingParkUplink(lg, b)
+	}
+
 	// Direct /up only
 	var du DirectUp
 	if err := json.Unmarshal(b, &du); err == nil && du.EndDeviceIDs.DevEUI != "" {
+		if !validateEUI64(du.EndDeviceIDs.DevEUI) {
+			return nil, &ParseError{Reason: "invalid dev_eui", Value: du.EndDeviceIDs.DevEUI}
+		}
+		decodeDecodedPayload(lg, &du.UplinkMessage, du.EndDeviceIDs.DevEUI)
+		applyRawDecoder(lg, &du.UplinkMessage, du.EndDeviceIDs.DevEUI)
+		dropInvalidReadings(lg, &du.UplinkMessage, du.EndDeviceIDs.DevEUI)
 		when := du.UplinkMessage.ReceivedAt
 		if when.IsZero() {
 			when = du.ReceivedAt
@@ -111,46 +243,79 @@ func parseUplink(b []byte) (*Parsed, error) {
 		if when.IsZero() {
 			when = time.Now().UTC()
 		}
-		debugf("parsed direct /up for dev_eui: %s", du.EndDeviceIDs.DevEUI)
+		lg.Debug("parsed direct /up for dev_eui: %s", du.EndDeviceIDs.DevEUI)
 		return &Parsed{
 			When:         when.UTC(),
 			StationEUI:   strings.ToUpper(du.EndDeviceIDs.DevEUI),
 			StationDevID: du.EndDeviceIDs.DeviceID,
-			AppID:        du.EndDeviceIDs.AppIDs.AppID,
+			AppID:        du.appID(),
+			NetID:        du.UplinkMessage.NetworkIDs.NetID,
+			TenantID:     du.UplinkMessage.NetworkIDs.TenantID,
+			ClusterID:    du.UplinkMessage.NetworkIDs.ClusterID,
 			Msg:          du.UplinkMessage,
 		}, nil
 	}
 
-	if debug {
-		if len(b) > 2048 {
-			log.Printf("[DEBUG] payload head: %s", string(b[:2048]))
-		} else {
-			log.Printf("[DEBUG] payload: %s", string(b))
-		}
+	if len(b) > 2048 {
+		lg.Debug("payload head: %s", b[:2048])
+	} else {
+		lg.Debug("payload: %s", b)
 	}
-	return nil, fmt.Errorf("unknown TTN uplink shape (expecting direct /up)")
+	return nil, &ParseError{Reason: "unknown TTN uplink shape (expecting direct /up)"}
 }
 
 // --- Sensor type validation ---//
-var validSensorTypes = map[int]struct{}{
-	1: {}, 2: {}, 3: {}, 4: {}, 5: {}, 6: {}, 7: {}, 8: {},
+
+type sensorTypeSpec struct {
+	// A reading of exactly 0 is physically impossible (e.g. absolute
+	// pressure), so it indicates a sensor fault and is dropped.
+	ZeroInvalid bool
+}
+
+var validSensorTypes = map[int]sensorTypeSpec{
+	1: {}, 2: {}, 3: {ZeroInvalid: true}, 4: {}, 5: {}, 6: {}, 7: {}, 8: {},
 	9: {}, 10: {}, 11: {}, 12: {}, 13: {}, 14: {}, 15: {},
 }
 
+// Drops readings that can't be real: NaN or ±Inf (possible from raw
+// decoders, or bridges emitting non-standard JSON) and zeros for types where
+// zero is impossible.
+func dropInvalidReadings(lg Logger, msg *UplinkMsg, devEUI string) {
+	for i := range msg.DecodedPayload.Slaves {
+		s := &msg.DecodedPayload.Slaves[i]
+		kept := s.Sensors[:0]
+		for _, m := range s.Sensors {
+			switch {
+			case math.IsNaN(m.Value) || math.IsInf(m.Value, 0):
+				lg.Warn("dropping non-finite value from %s: slave %d type %d idx %d = %v", devEUI, s.ID, m.Type, m.Index, m.Value)
+			case m.Value == 0 && validSensorTypes[m.Type].ZeroInvalid:
+				lg.Warn("dropping impossible zero from %s: slave %d type %d idx %d", devEUI, s.ID, m.Type, m.Index)
+			default:
+				kept = append(kept, m)
+			}
+		}
+		s.Sensors = kept
+	}
+}
+
 // --- SQL statements ---//
 const insertMeasurementSQL = `
 INSERT INTO measurements(
-  time, station_eui, station_devid, slave_id, sensor_type, sensor_index, value, format, gateway_id, latitude, longitude
-) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)
+  time, station_eui, station_devid, slave_id, sensor_type, sensor_index, value, format, gateway_id, latitude, longitude, raw_value, frm_payload, message_id
+) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14)
 ON CONFLICT DO NOTHING;
 `
 
+// Uplinks without network_ids keep the tenant/cluster last seen.
 const upsertStationSQL = `
-INSERT INTO stations(station_eui, application_id, station_devid)
-VALUES ($1,$2,$3)
+INSERT INTO stations(station_eui, application_id, station_devid, last_uplink_at, ttn_tenant_id, ttn_cluster_id)
+VALUES ($1,$2,$3,$4,$5,$6)
 ON CONFLICT (station_eui) DO UPDATE
 SET application_id = EXCLUDED.application_id,
-    station_devid  = EXCLUDED.station_devid;
+    station_devid  = EXCLUDED.station_devid,
+    last_uplink_at = GREATEST(stations.last_uplink_at, EXCLUDED.last_uplink_at),
+    ttn_tenant_id  = coalesce(EXCLUDED.ttn_tenant_id, stations.ttn_tenant_id),
+    ttn_cluster_id = coalesce(EXCLUDED.ttn_cluster_id, stations.ttn_cluster_id);
 `
 
 const upsertGatewaySQL = `
@@ -159,24 +324,34 @@ VALUES ($1,$2)
 ON CONFLICT (gateway_id) DO UPDATE SET gateway_eui = EXCLUDED.gateway_eui;
 `
 
-//--- Helpers ---//
+// Running averages: a NULL reading leaves the average as it was.
+const upsertGatewayStatsSQL = `
+INSERT INTO gateway_statistics AS gs (gateway_id, msg_count, avg_rssi, avg_snr, last_active_at)
+VALUES ($1, 1, $2, $3, $4)
+ON CONFLICT (gateway_id) DO UPDATE
+SET avg_rssi = COALESCE((gs.avg_rssi * gs.msg_count + EXCLUDED.avg_rssi) / (gs.msg_count + 1), gs.avg_rssi, EXCLUDED.avg_rssi),
+    avg_snr  = COALESCE((gs.avg_snr * gs.msg_count + EXCLUDED.avg_snr) / (gs.msg_count + 1), gs.avg_snr, EXCLUDED.avg_snr),
+    msg_count = gs.msg_count + 1,
+    last_active_at = GREATEST(gs.last_active_at, EXCLUDED.last_active_at);
+`
 
-// Fails if the env var is not set
-func mustEnv(k string) string {
-	v := os.Getenv(k)
-	if v == "" {
-		log.Fatalf("missing env %s", k)
-	}
-	return v
-}
+// Compares the value against the previous 24h of readings for the same
+// station/slave/type and logs it as an anomaly if the z-score is too high.
+const insertAnomalySQL = `
+WITH stats AS (
+  SELECT avg(value) AS mean, stddev_samp(value) AS sd
+  FROM measurements
+  WHERE station_eui = $2 AND slave_id = $3 AND sensor_type = $4
+    AND time >= $1::timestamptz - INTERVAL '24 hours' AND time < $1::timestamptz
+)
+INSERT INTO measurements_anomaly(time, station_eui, slave_id, sensor_type, value, zscore)
+SELECT $1, $2, $3, $4, $5::float8, ($5::float8 - mean) / sd
+FROM stats
+WHERE sd > 0 AND abs($5::float8 - mean) / sd > $6::float8
+ON CONFLICT DO NOTHING;
+`
 
-// Returns the env var value or a default value if not set
-func envOr(k, d string) string {
-	if v := os.Getenv(k); v != "" {
-		return v
-	}
-	return d
-}
+//--- Helpers ---//
 
 func nullIfEmpty(s string) *string {
 	if s == "" {
@@ -190,21 +365,259 @@ func nullFloat(f *float64) *float64 { return f }
 func randSuffix() string { return fmt.Sprintf("%d", time.Now().UnixNano()%1e9) }
 
 // --- MQTT handler ---//
-func handleMessage(ctx context.Context, pool *pgxpool.Pool, msg mqtt.Message) {
-	if debug {
-		log.Printf("[DEBUG] mqtt topic: %s qos: %d retained: %v", msg.Topic(), msg.Qos(), msg.Retained())
+func handleMessage(ctx context.Context, lg Logger, sink Sink, msg mqtt.Message) {
+	lg.Debug("mqtt topic: %s qos: %d retained: %v", msg.Topic(), msg.Qos(), msg.Retained())
+	markMessageReceived()
+
+	start := time.Now()
+	if debugMessages != nil {
+		debugMessages.record(ctx, msg, start)
 	}
+	b := msg.Payload()
+	if gzipPayloads {
+		b = maybeGunzip(lg, b)
+	}
+	if isDownlinkTopic(msg.Topic()) {
+		if downlinks != nil && isLoggedDownlinkTopic(msg.Topic()) {
+			_ = downlinks.ingest(ctx, b)
+		}
+		return
+	}
+	if err := ingestUplink(ctx, lg, sink, b, extractAppIDFromTopic(msg.Topic()), start); err != nil {
+		parkUplink(ctx, lg, err)
+	}
+}
 
-	p, err := parseUplink(msg.Payload())
-	if err != nil {
-		log.Printf("parse error: %v", err)
+// Returns the decompressed payload, or b unchanged if it isn't gzip or
+// decompresses to more than maxDecompressedPayload. A payload that fails to
+// decompress is only logged when it isn't valid JSON either, since bridges
+// may forward a mix of compressed and plain messages.
+func maybeGunzip(lg Logger, b []byte) []byte {
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err == nil {
+		defer zr.Close()
+		var out []byte
+		out, err = io.ReadAll(io.LimitReader(zr, maxDecompressedPayload+1))
+		if err == nil && len(out) > maxDecompressedPayload {
+			err = fmt.Errorf("decompressed payload larger than %d bytes", maxDecompressedPayload)
+		}
+		if err == nil {
+			return out
+		}
+	}
+	if !json.Valid(b) {
+		lg.Warn("gzip decompress error: %v", err)
+	}
+	return b
+}
+
+// Returns the application ID encoded in a TTN v3 topic
+// ("v3/{app_id}@{tenant}/devices/{dev_id}/up"), or "" if the topic doesn't
+// follow that layout.
+func extractAppIDFromTopic(topic string) string {
+	parts := strings.Split(topic, "/")
+	if len(parts) < 4 || parts[0] != "v3" || parts[2] != "devices" {
+		return ""
+	}
+	appID, _, _ := strings.Cut(parts[1], "@")
+	return appID
+}
+
+// --- Ingest pipeline ---//
+
+// Returned (wrapped) by ingestUplink when the uplink could not be processed
+// for a reason that may clear up, such as the database being unreachable;
+// HTTP front ends answer 503 so the sender retries.
+var errIngestUnavailable = errors.New("temporarily unable to process uplink")
+
+// Returned by ingestParsed when the calibrations of a station with none
+// cached can't be read. Carries the uplink, so front ends that can't have it
+// redelivered can park it with parkUplink.
+type calibrationUnavailableError struct {
+	p   *Parsed
+	err error
+}
+
+func (e *calibrationUnavailableError) Error() string {
+	return fmt.Sprintf("%v: calibration lookup: %v", errIngestUnavailable, e.err)
+}
+
+func (e *calibrationUnavailableError) Unwrap() []error { return []error{errIngestUnavailable, e.err} }
+
+// Parks the uplink of a calibrationUnavailableError in the retry queue, which
+// calibrates and ingests it once the database is back. For the MQTT and UDP
+// front ends, which can't ask for the uplink again; other errors have
+// already been logged by ingestUplink.
+func parkUplink(ctx context.Context, lg Logger, err error) {
+	var ce *calibrationUnavailableError
+	if !errors.As(err, &ce) {
 		return
 	}
+	if retryQueue == nil {
+		lg.Error("uplink dropped, no retry queue to park it in (eui: %s f_cnt: %d)", ce.p.StationEUI, ce.p.Msg.FCnt)
+		return
+	}
+	retryQueue.EnqueueUplink(ctx, ce.p, ce.err)
+}
+
+// Parses a TTN uplink and hands it to the sink. Shared by the MQTT and webhook
+// front ends; parse errors and errIngestUnavailable are returned, sink errors
+// are logged by the sink itself. fallbackAppID is used when the payload
+// carries no application ID. start is when the message was received, for the
+// processing time histogram.
+func ingestUplink(ctx context.Context, lg Logger, sink Sink, b []byte, fallbackAppID string, start time.Time) error {
+	stats.Messages.Add(1)
+
+	p, err := parseUplink(lg, b)
+	if err != nil {
+		stats.ParseErrors.Add(1)
+		lg.Warn("parse error: %v", err)
+		notifyError("parse", "", err)
+		return err
+	}
+	return ingestParsed(ctx, lg, sink, p, b, fallbackAppID, start)
+}
+
+// Everything ingestUplink does after parsing, for sources that build the
+// Parsed uplink themselves (e.g. the Semtech UDP listener). raw is only used
+// for tracing.
+func ingestParsed(ctx context.Context, lg Logger, sink Sink, p *Parsed, raw []byte, fallbackAppID string, start time.Time) error {
+	if p.AppID == "" {
+		p.AppID = fallbackAppID
+	}
+	p.received = start
+
+	if payloadTransform != nil {
+		payloadTransform.apply(ctx, p)
+	}
+
+	// Calibrated once here, so every sink and alerter sees the same values.
+	// Storing the uplink uncalibrated would look just like a calibrated one.
+	if calibrations != nil {
+		if err := calibrations.apply(ctx, p); err != nil {
+			stats.DBErrors.Add(1)
+			lg.Error("calibration lookup error: %v (eui: %s)", err, p.StationEUI)
+			notifyError("db", p.StationEUI, err)
+			return &calibrationUnavailableError{p: p, err: err}
+		}
+	}
+	deliverUplink(ctx, sink, p, raw, start)
+	return nil
+}
+
+// Ingests an uplink parked by parkUplink, for the retry queue. It goes on as
+// a late frame: newer frames of its device have likely been stored since.
+func ingestParked(ctx context.Context, sink Sink, p *Parsed) error {
+	if calibrations != nil {
+		if err := calibrations.apply(ctx, p); err != nil {
+			return err
+		}
+	}
+	p.lateFrame = true
+	deliverUplink(ctx, sink, p, nil, time.Now())
+	return nil
+}
+
+// Hands a calibrated uplink to the frame orderer or the sink, and to the
+// threshold alerts.
+func deliverUplink(ctx context.Context, sink Sink, p *Parsed, raw []byte, start time.Time) {
+	if tracing(p.StationEUI) {
+		trace(p.StationEUI, "raw payload: %s", raw)
+		traceJSON(p.StationEUI, "parsed", p)
+		traceJSON(p.StationEUI, "decoded payload", p.Msg.DecodedPayload)
+		defer func() { trace(p.StationEUI, "done in %s", time.Since(start)) }()
+	}
+
+	if frameOrder != nil && !p.lateFrame {
+		frameOrder.add(p)
+		trace(p.StationEUI, "queued for FCnt ordering (f_cnt: %d)", p.Msg.FCnt)
+	} else {
+		err := sink.InsertMeasurements(ctx, p)
+		trace(p.StationEUI, "sink result: %v", err)
+		notifyError("db", p.StationEUI, err)
+	}
+	if thresholdAlerts != nil {
+		thresholdAlerts.check(p)
+	}
+}
+
+// MeasurementRow is a single measurements row, as written by
+// insertMeasurementSQL and read back by Store.QueryMeasurements.
+type MeasurementRow struct {
+	Time         time.Time `json:"time"`
+	StationEUI   string    `json:"station_eui"`
+	StationDevID *string   `json:"station_devid,omitempty"`
+	SlaveID      int       `json:"slave_id"`
+	SensorType   int       `json:"sensor_type"`
+	SensorIndex  int       `json:"sensor_index"`
+	Value        float64   `json:"value"`
+	Format       int       `json:"format"`
+	GatewayID    *string   `json:"gateway_id,omitempty"`
+	Latitude     *float64  `json:"latitude,omitempty"`
+	Longitude    *float64  `json:"longitude,omitempty"`
+	// Unsmoothed reading when Value is an EMA (see SMOOTH_SENSOR_TYPES).
+	RawValue *float64 `json:"raw_value,omitempty"`
+	// base64 LoRa payload the row was decoded from, for re-decoding later.
+	FrmPayload *string `json:"frm_payload,omitempty"`
+	// Derived from the row's key by measurementMessageID.
+	MessageID uuid.UUID `json:"message_id"`
+}
+
+// Namespace of measurement message IDs.
+var measurementIDNamespace = uuid.MustParse("46349fd9-10ab-4d41-ab53-4ce0bace2bb7")
+
+// Returns a name-based (SHA-1) UUID of a measurement's unique key, so the
+// same reading always gets the same message_id no matter which instance or
+// retry writes it.
+func measurementMessageID(when time.Time, eui string, slaveID, sensorType, sensorIndex int) uuid.UUID {
+	key := fmt.Sprintf("%s|%s|%d|%d|%d", when.UTC().Format(time.RFC3339Nano), eui, slaveID, sensorType, sensorIndex)
+	return uuid.NewSHA1(measurementIDNamespace, []byte(key))
+}
+
+// Returns r.MessageID, deriving it for rows queued before it existed.
+func (r MeasurementRow) messageID() uuid.UUID {
+	if r.MessageID != uuid.Nil {
+		return r.MessageID
+	}
+	return measurementMessageID(r.Time, r.StationEUI, r.SlaveID, r.SensorType, r.SensorIndex)
+}
+
+func insertMeasurement(ctx context.Context, pool *pgxpool.Pool, r MeasurementRow) error {
+	_, err := pool.Exec(ctx, insertMeasurementSQL,
+		r.Time, r.StationEUI, r.StationDevID, r.SlaveID, r.SensorType, r.SensorIndex, r.Value, r.Format,
+		r.GatewayID, r.Latitude, r.Longitude, r.RawValue, r.FrmPayload, r.messageID(),
+	)
+	if err == nil {
+		cacheMeasurement(r)
+		broadcastMeasurement(r)
+	}
+	return err
+}
+
+// Store is the PostgreSQL sink.
+type Store struct {
+	log  Logger
+	pool *pgxpool.Pool
+}
+
+func NewStore(lg Logger, pool *pgxpool.Pool) *Store {
+	return &Store{log: lg, pool: pool}
+}
+
+// Writes the station, gateway and measurements of a parsed uplink. Every
+// failed statement is logged and counted; the returned error joins them.
+func (st *Store) InsertMeasurements(ctx context.Context, p *Parsed) error {
+	lg, pool := st.log, st.pool
+	var errs []error
 
 	if p.AppID != "" && p.StationEUI != "" {
+		trace(p.StationEUI, "upsert station params: %s %s %q %s %q %q", p.StationEUI, p.AppID, p.StationDevID, p.When, p.TenantID, p.ClusterID)
 		if _, err := pool.Exec(ctx, upsertStationSQL,
-			p.StationEUI, p.AppID, nullIfEmpty(p.StationDevID)); err != nil {
-			log.Printf("station upsert error: %v", err)
+			p.StationEUI, p.AppID, nullIfEmpty(p.StationDevID), p.When,
+			nullIfEmpty(p.TenantID), nullIfEmpty(p.ClusterID)); err != nil {
+			stats.DBErrors.Add(1)
+			lg.Error("station upsert error: %v", err)
+			errs = append(errs, err)
 		}
 	}
 
@@ -216,7 +629,9 @@ func handleMessage(ctx context.Context, pool *pgxpool.Pool, msg mqtt.Message) {
 		gwID = rm.GatewayIDs.GatewayID
 		if gwID != "" {
 			if _, err := pool.Exec(ctx, upsertGatewaySQL, gwID, rm.GatewayIDs.EUI); err != nil {
-				log.Printf("gateway upsert error: %v", err)
+				stats.DBErrors.Add(1)
+				lg.Error("gateway upsert error: %v", err)
+				errs = append(errs, err)
 			}
 		}
 		if rm.Location != nil {
@@ -224,87 +639,685 @@ func handleMessage(ctx context.Context, pool *pgxpool.Pool, msg mqtt.Message) {
 			lat, lon = &latV, &lonV
 		}
 	}
+	if err := st.insertDeviceLocations(ctx, p, lat, lon); err != nil {
+		stats.DBErrors.Add(1)
+		lg.Error("device location error: %v (eui: %s)", err, p.StationEUI)
+		errs = append(errs, err)
+	}
 
 	count := 0
+	var rows []MeasurementRow
 	for _, s := range p.Msg.DecodedPayload.Slaves {
 		for _, m := range s.Sensors {
 			if _, ok := validSensorTypes[m.Type]; !ok {
-				debugf("skip unknown sensor type: %d idx: %d value: %v", m.Type, m.Index, m.Value)
+				if !sensorAutodiscovery {
+					lg.Debug("skip unknown sensor type: %d idx: %d value: %v", m.Type, m.Index, m.Value)
+					continue
+				}
+				recordSensorTypeDiscovery(ctx, lg, pool, m.Type, p.StationEUI, m.Value, p.When)
+			}
+			row := MeasurementRow{
+				Time: p.When, StationEUI: p.StationEUI, StationDevID: nullIfEmpty(p.StationDevID),
+				SlaveID: s.ID, SensorType: m.Type, SensorIndex: m.Index, Value: m.Value, Format: m.Format,
+				GatewayID: nullIfEmpty(gwID), Latitude: nullFloat(lat), Longitude: nullFloat(lon),
+				FrmPayload: nullIfEmpty(p.Msg.FrmPayload),
+				MessageID:  measurementMessageID(p.When, p.StationEUI, s.ID, m.Type, m.Index),
+			}
+			// raw_value keeps the reading as received whenever value is
+			// calibrated (already done by ingestParsed), smoothed or both.
+			row.RawValue = m.Raw
+			if smoother != nil {
+				if v, ok := smoother.smooth(p.StationEUI, s.ID, m.Type, m.Index, m.Value); ok {
+					if row.RawValue == nil {
+						raw := m.Value
+						row.RawValue = &raw
+					}
+					row.Value = v
+				}
+			}
+			rows = append(rows, row)
+			traceJSON(p.StationEUI, "insert measurement params", row)
+			if batcher != nil {
+				batcher.add(ctx, row)
+				count++
 				continue
 			}
-			_, err := pool.Exec(ctx, insertMeasurementSQL,
-				p.When, p.StationEUI, nullIfEmpty(p.StationDevID), s.ID, m.Type, m.Index, m.Value, m.Format,
-				nullIfEmpty(gwID), nullFloat(lat), nullFloat(lon),
-			)
-			if err != nil {
-				log.Printf("insert error: %v (eui: %s slave: %d type:%d idx: %d)", err, p.StationEUI, s.ID, m.Type, m.Index)
+			if err := insertMeasurement(ctx, pool, row); err != nil {
+				stats.DBErrors.Add(1)
+				writerStats.recordErrors(1)
+				lg.Error("insert error: %v (eui: %s slave: %d type:%d idx: %d)", err, p.StationEUI, s.ID, m.Type, m.Index)
+				if retryQueue != nil {
+					retryQueue.Enqueue(ctx, row, err)
+				}
+				errs = append(errs, err)
 				continue
 			}
 			count++
+			stats.Measurements.Add(1)
+			writerStats.recordInserts(1)
+
+			if _, err := pool.Exec(ctx, insertAnomalySQL,
+				p.When, p.StationEUI, s.ID, m.Type, row.Value, anomalyZScoreThreshold,
+			); err != nil {
+				stats.DBErrors.Add(1)
+				lg.Error("anomaly check error: %v (eui: %s slave: %d type: %d)", err, p.StationEUI, s.ID, m.Type)
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	if aggregateSlaves {
+		for _, row := range aggregateSlaveRows(rows, aggregateSlaveID) {
+			if batcher != nil {
+				batcher.add(ctx, row)
+				count++
+				continue
+			}
+			if err := insertMeasurement(ctx, pool, row); err != nil {
+				stats.DBErrors.Add(1)
+				writerStats.recordErrors(1)
+				lg.Error("aggregate insert error: %v (eui: %s type: %d idx: %d)", err, p.StationEUI, row.SensorType, row.SensorIndex)
+				if retryQueue != nil {
+					retryQueue.Enqueue(ctx, row, err)
+				}
+				errs = append(errs, err)
+				continue
+			}
+			count++
+			stats.Measurements.Add(1)
+			writerStats.recordInserts(1)
+		}
+	}
+
+	// Counted only once a measurement of the uplink was written (or queued
+	// for the batch); uplinks whose inserts all failed don't count.
+	if gwID != "" && count > 0 {
+		rm := p.Msg.RxMetadata[0]
+		var rssi *float64
+		if rm.RSSI != nil {
+			v := float64(*rm.RSSI)
+			rssi = &v
+		}
+		if _, err := pool.Exec(ctx, upsertGatewayStatsSQL, gwID, rssi, rm.SNR, p.When); err != nil {
+			stats.DBErrors.Add(1)
+			lg.Error("gateway statistics error: %v", err)
+			errs = append(errs, err)
+		}
+	}
+
+	if batcher != nil {
+		lg.Info("queued %d measurements from %s", count, p.StationEUI)
+		return errors.Join(errs...)
+	}
+	lg.Info("ingested %d measurements from %s", count, p.StationEUI)
+	return errors.Join(errs...)
+}
+
+// Returns the broker URL passed to AddBroker.
+func mqttBrokerURL(cfg *Config) string {
+	if cfg.MQTTAWSIoTCore {
+		return "wss://" + cfg.AWSIoTEndpoint + ":443/mqtt"
+	}
+	return cfg.MQTTProtocol + "://" + cfg.MQTTHost + ":" + cfg.MQTTPort
+}
+
+// Connects to the MQTT broker configured via env and subscribes to MQTT_TOPIC,
+// passing every received message to handle.
+func connectMQTT(lg Logger, cfg *Config, handle func(mqtt.Message)) mqtt.Client {
+	opts := mqttClientOptions(lg, cfg, handle)
+
+	if !cfg.MQTTAWSIoTCore {
+		go checkTTNHost(lg, cfg)
+	}
+
+	if apiKeys != nil && cfg.MQTTUseAuth && !cfg.MQTTAWSIoTCore {
+		apiKeys.apply(opts, cfg.MQTTUsername)
+	}
+	if cfg.TTNRegionHostFallback != "" && !cfg.MQTTAWSIoTCore {
+		applyHostFailover(lg, opts, cfg.TTNRegionHostFallback, cfg.FallbackRetries)
+	}
+
+	if apiKeys != nil && cfg.MQTTUseAuth && !cfg.MQTTAWSIoTCore {
+		client := mqtt.NewClient(opts)
+		if err := apiKeys.connect(client); err != nil {
+			log.Fatalf("mqtt connect: %v", err)
+		}
+		return client
+	}
+
+	client := mqtt.NewClient(opts)
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		log.Fatalf("mqtt connect: %v", token.Error())
+	}
+	return client
+}
+
+// Client options for the broker and credentials in cfg, subscribing to
+// MQTT_TOPIC (and MQTT_DOWNLINK_TOPIC) on every (re)connect.
+func mqttClientOptions(lg Logger, cfg *Config, handle func(mqtt.Message)) *mqtt.ClientOptions {
+	topic := cfg.MQTTTopic
+	pingTimeout := time.Duration(cfg.MQTTPingTimeoutSeconds) * time.Second
+
+	// Shared subscriptions are an MQTT 5 feature; EMQX, HiveMQ and Mosquitto
+	// also honour $share on 3.1.1 connections, but other brokers may not.
+	if group := cfg.MQTTSharedSubscriptionGroup; group != "" {
+		topic = "$share/" + group + "/" + topic
+		lg.Warn("subscribing to %s over MQTT 3.1.1; shared subscriptions are an MQTT 5 feature and only work if the broker accepts them on 3.1.1", topic)
+	}
+
+	// MQTT client options
+	opts := mqtt.NewClientOptions().
+		SetClientID("ttn-uplink-ingestor-" + randSuffix())
+
+	switch {
+	case cfg.MQTTAWSIoTCore:
+		applyAWSIoT(opts, cfg)
+	default:
+		opts.AddBroker(mqttBrokerURL(cfg))
+		if strings.HasPrefix(cfg.MQTTProtocol, "mqtts") {
+			opts.SetTLSConfig(&tls.Config{MinVersion: tls.VersionTLS12})
 		}
 	}
 
-	log.Printf("ingested %d measurements from %s", count, p.StationEUI)
+	// SCRAM needs MQTT 5 enhanced authentication (AUTH packets), which the
+	// v3.1.1 paho client doesn't implement.
+	switch method := cfg.MQTTAuthMethod; method {
+	case "plain":
+	case "scram-sha-256":
+		log.Fatalf("MQTT_AUTH_METHOD=%s requires an MQTT 5 client; only plain is supported", method)
+	default:
+		log.Fatalf("invalid MQTT_AUTH_METHOD %q (want plain or scram-sha-256)", method)
+	}
+
+	// AWS IoT authenticates the signed WebSocket request instead.
+	if cfg.MQTTUseAuth && !cfg.MQTTAWSIoTCore {
+		opts.SetUsername(cfg.MQTTUsername)
+		opts.SetPassword(cfg.MQTTPassword)
+	}
+
+	// Separate from keep-alive: slow links may answer PINGREQ late but still be up.
+	opts.SetPingTimeout(pingTimeout)
+	opts.SetAutoReconnect(true)
+	opts.SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+		lg.Warn("mqtt connection lost: %v", err)
+		if connectionEvents != nil {
+			connectionEvents.record(connectionEventLost, err.Error())
+		}
+	})
+	topics := []string{topic}
+	if cfg.MQTTDownlinkTopic != "" {
+		topics = append(topics, cfg.MQTTDownlinkTopic)
+	}
+	opts.SetOnConnectHandler(func(c mqtt.Client) {
+		for _, t := range topics {
+			if token := c.Subscribe(t, 0, func(_ mqtt.Client, msg mqtt.Message) {
+				handle(msg)
+			}); token.Wait() && token.Error() != nil {
+				lg.Error("subscribe error: %v", token.Error())
+			} else {
+				lg.Info("subscribed to %s", t)
+			}
+		}
+		if connectionEvents != nil {
+			r := c.OptionsReader()
+			var servers []string
+			for _, u := range r.Servers() {
+				servers = append(servers, u.Redacted())
+			}
+			connectionEvents.record(connectionEventConnected, strings.Join(servers, ","))
+		}
+	})
+	return opts
 }
 
 func main() {
+	var statsInterval time.Duration
+	var healthcheckInterval time.Duration
+	var exportCSVMode bool
+	var exportParquetMode bool
+	var watchEUI string
+	var schemaVersion bool
+	var schemaDiff bool
+	var migrateIndexesMode bool
+	var sensorTypeStats bool
+	var uplinkStats bool
+	var testDBWrite bool
+	var uplinkStatsAppID string
+	var pingMode bool
+	var backfill bool
+	var simulateN int
+	var simulateRate float64
+	var replayFile string
+	var replaySpeed float64
+	var profileCPU, profileMem string
+	var profileDuration time.Duration
+	var dumpConfig bool
+	var configTemplate bool
+	var configAudit bool
+	var printMQTTURL bool
+	var emitNDJSON bool
+	var checkConnectivity bool
 	flag.BoolVar(&debug, "debug", false, "enable debug logging")
+	flag.StringVar(&traceEUI, "trace-eui", "", "log every processing step (raw payload, parsed uplink, DB parameters, timing) for this device EUI")
+	flag.DurationVar(&statsInterval, "stats", 0, "print ingestion statistics to stdout every interval (e.g. 10s)")
+	flag.DurationVar(&healthcheckInterval, "healthcheck-interval", 0, "log DB round-trip latency (SELECT 1) every interval (e.g. 1m); 0 disables")
+	flag.BoolVar(&exportCSVMode, "export-csv", false, "export measurements to CSV and exit; args: stationEUI startDate endDate outputFile")
+	flag.BoolVar(&exportParquetMode, "export-parquet", false, "export measurements to a Parquet file and exit; args: stationEUI startDate endDate outputFile")
+	flag.StringVar(&watchEUI, "watch-station", "", "show a live terminal dashboard for the given station EUI (no DB writes)")
+	flag.BoolVar(&schemaVersion, "schema-version", false, "print the latest applied schema version and exit")
+	flag.BoolVar(&schemaDiff, "schema-diff", false, "print the DDL (missing tables, columns and indexes) needed to bring the DB up to the built-in schema, without running it, and exit")
+	flag.BoolVar(&migrateIndexesMode, "migrate-indexes", false, "create missing or invalid measurements indexes without blocking writes (CONCURRENTLY, or per chunk on hypertables) and exit")
+	flag.BoolVar(&sensorTypeStats, "sensor-type-stats", false, "print count, stations, min/max/mean/stddev and latest time per sensor type and exit")
+	flag.BoolVar(&uplinkStats, "print-uplink-stats", false, "print message and measurement counts, last uplink and last gateway per device (newest first) and exit")
+	flag.StringVar(&uplinkStatsAppID, "app-id", "", "with -print-uplink-stats, only list devices of this TTN application")
+	flag.BoolVar(&testDBWrite, "test-db-write", false, "insert a synthetic measurement, read it back and delete it, then exit (1 on failure)")
+	flag.BoolVar(&backfill, "backfill", false, "re-insert everything in retry_queue that has attempts left and exit")
+	flag.IntVar(&simulateN, "simulate", 0, "inject this many synthetic uplinks into the pipeline instead of connecting to MQTT, then exit")
+	flag.Float64Var(&simulateRate, "simulate-rate", 10, "synthetic uplinks per second for -simulate (0 = unthrottled)")
+	flag.StringVar(&replayFile, "replay-file", "", "ingest the uplinks in this file (one TTN /up JSON per line) instead of connecting to MQTT, then exit")
+	flag.Float64Var(&replaySpeed, "replay-speed", 0, "for -replay-file, reproduce the gaps between received_at timestamps divided by this (1 = real time, 0 = as fast as possible)")
+	flag.StringVar(&profileCPU, "profile-cpu", "", "write a CPU profile of the run (or the -profile-duration window) to this file")
+	flag.StringVar(&profileMem, "profile-mem", "", "write a heap profile to this file at exit (or after -profile-duration)")
+	flag.DurationVar(&profileDuration, "profile-duration", 0, "stop -profile-cpu/-profile-mem after this long instead of at exit")
+	flag.BoolVar(&pingMode, "ping", false, "check /readyz of a running instance and exit 0 if ready, 1 otherwise")
+	flag.BoolVar(&dumpConfig, "dump-config", false, "print the effective configuration as JSON (secrets redacted) and exit")
+	flag.BoolVar(&configAudit, "config-audit", false, "check the configuration for conflicting, ignored or invalid settings and exit (1 on errors)")
+	flag.BoolVar(&checkConnectivity, "check-connectivity", false, "check the DB, DNS and TCP reachability of the MQTT broker and TRANSFORM_ENDPOINT_URL, print a summary and exit (1 on failures)")
+	flag.BoolVar(&printMQTTURL, "print-mqtt-url", false, "print the MQTT broker URL built from the environment and exit")
+	flag.BoolVar(&emitNDJSON, "emit-ndjson", false, "also write every parsed uplink to stdout as one JSON object per line (see NDJSON_SKIP_DB)")
+	flag.BoolVar(&configTemplate, "config-template", false, "print a .env template of every supported env var and exit")
 	flag.Parse()
 
+	if configTemplate {
+		if err := writeConfigTemplate(os.Stdout); err != nil {
+			log.Fatalf("config template: %v", err)
+		}
+		return
+	}
+
+	cfg, err := loadConfig()
+	if configAudit {
+		os.Exit(runConfigAudit(os.Stdout, cfg, err))
+	}
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+
+	if dumpConfig {
+		if err := cfg.dump(os.Stdout); err != nil {
+			log.Fatalf("dump config: %v", err)
+		}
+		return
+	}
+
+	if printMQTTURL {
+		fmt.Println(redactURL(mqttBrokerURL(cfg)))
+		return
+	}
+
+	if checkConnectivity {
+		os.Exit(runConnectivityCheck(context.Background(), os.Stdout, NewStdLogger(debug), cfg))
+	}
+
+	if pingMode || flag.Arg(0) == "ping" {
+		if err := ping(cfg.HealthPort); err != nil {
+			fmt.Fprintf(os.Stderr, "ping: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// ingestor validate < uplink.json
+	if flag.Arg(0) == "validate" {
+		if err := registerRawDecoders(cfg.RawDecoders); err != nil {
+			log.Fatalf("RAW_DECODERS: %v", err)
+		}
+		os.Exit(runValidate(NewStdLogger(debug), os.Stdin, os.Stdout))
+	}
+
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
-	pgdsn := mustEnv("PG_DSN")
-	username := mustEnv("MQTT_USERNAME")
-	password := mustEnv("MQTT_PASSWORD")
-	host := mustEnv("MQTT_HOST")
-	port := envOr("MQTT_PORT", "1883")
-	authEnabled := envOr("MQTT_USE_AUTH", "true")
-	protocol := envOr("MQTT_PROTOCOL", "mqtt")
-	topic := mustEnv("MQTT_TOPIC")
+	lg := NewStdLogger(debug)
+	var diagLog *diagnosticLogger
+	if cfg.MQTTDiagnosticTopic != "" {
+		diagLog = newDiagnosticLogger(lg)
+		lg = diagLog
+	}
+	traceLog = lg
+	registerBuildInfo(cfg.OTELServiceName, cfg.DeploymentEnv)
+	promEUIPrefixLen = cfg.PromEUIPrefixLen
+	adminAPIToken = cfg.AdminAPIToken
+	apiKeys = newKeyPool(lg, cfg.TTNAPIKeyList)
+
+	if profileCPU != "" || profileMem != "" {
+		stopProfiling, err := startProfiling(lg, profileCPU, profileMem, profileDuration)
+		if err != nil {
+			log.Fatalf("profiling: %v", err)
+		}
+		defer stopProfiling()
+	}
+
+	if watchEUI != "" {
+		if err := cfg.validateMQTT(); err != nil {
+			log.Fatalf("config: %v", err)
+		}
+		runWatchStation(ctx, lg, cfg, watchEUI)
+		return
+	}
+
+	if err := cfg.validate(); err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	anomalyZScoreThreshold = cfg.AnomalyZScoreThreshold
+	gzipPayloads = cfg.MQTTPayloadGzip
+	sensorAutodiscovery = cfg.SensorAutodiscovery
+	switch networkServer = cfg.NetworkServer; networkServer {
+	case "ttn", "thingpark":
+	default:
+		log.Fatalf("unknown NETWORK_SERVER %q (expecting ttn or thingpark)", networkServer)
+	}
+	aggregateSlaves, aggregateSlaveID = cfg.AggregateSlaves, cfg.AggregateSlaveID
+	if err := registerRawDecoders(cfg.RawDecoders); err != nil {
+		log.Fatalf("RAW_DECODERS: %v", err)
+	}
+	if smoother, err = newEMASmoother(cfg.SmoothSensorTypes, cfg.SmoothAlpha); err != nil {
+		log.Fatalf("SMOOTH_SENSOR_TYPES: %v", err)
+	}
+	if cfg.ErrorNotifyWebhookURL != "" {
+		errorNotify = newErrorNotifier(lg, cfg.ErrorNotifyWebhookURL, cfg.ErrorNotifyThreshold,
+			time.Duration(cfg.ErrorNotifyWindowSeconds)*time.Second)
+	}
+	if cfg.CacheStations > 0 && cfg.CacheReadingsPerStation > 0 {
+		measurementCache = newMeasurementCache(cfg.CacheStations, cfg.CacheReadingsPerStation)
+	}
+	if cfg.TransformEndpointURL != "" {
+		payloadTransform = newPayloadTransformer(lg, cfg.TransformEndpointURL,
+			time.Duration(cfg.TransformTimeoutMS)*time.Millisecond)
+	}
+	if cfg.ThresholdConfigPath != "" {
+		thresholds, err := loadThresholds(cfg.ThresholdConfigPath)
+		if err != nil {
+			log.Fatalf("THRESHOLD_CONFIG_PATH: %v", err)
+		}
+		thresholdAlerts = newThresholdAlerter(lg, thresholds)
+		if cfg.AnomalyWebhookURL != "" {
+			thresholdAlerts.addPublisher(webhookAlertPublisher(lg, cfg.AnomalyWebhookURL))
+		}
+	}
 
 	// DB pool
-	pool, err := pgxpool.New(ctx, pgdsn)
+	poolCfg, err := pgPoolConfig(lg, cfg)
+	if err != nil {
+		log.Fatalf("pgx pool: %v", err)
+	}
+	if migrateIndexesMode || sensorTypeStats || uplinkStats || exportCSVMode || exportParquetMode || backfill {
+		// Index builds, full table scans, exports and backfills legitimately
+		// run (and wait for locks) longer than any ingest statement.
+		delete(poolCfg.ConnConfig.RuntimeParams, "statement_timeout")
+		delete(poolCfg.ConnConfig.RuntimeParams, "lock_timeout")
+	}
+	pool, err := pgxpool.NewWithConfig(ctx, poolCfg)
 	if err != nil {
 		log.Fatalf("pgx pool: %v", err)
 	}
 	defer pool.Close()
 
-	// MQTT client options
-	opts := mqtt.NewClientOptions().
-		AddBroker(protocol + "://" + host + ":" + port).
-		SetClientID("ttn-uplink-ingestor-" + randSuffix())
+	if schemaVersion {
+		if err := printSchemaVersion(ctx, pool); err != nil {
+			log.Fatalf("schema version: %v", err)
+		}
+		return
+	}
 
-	if strings.HasPrefix(protocol, "mqtts") {
-		opts.SetTLSConfig(&tls.Config{MinVersion: tls.VersionTLS12})
+	if schemaDiff {
+		if err := printSchemaDiff(ctx, os.Stdout, pool); err != nil {
+			log.Fatalf("schema diff: %v", err)
+		}
+		return
 	}
 
-	if authEnabled == "true" {
-		opts.SetUsername(username)
-		opts.SetPassword(password)
+	if migrateIndexesMode {
+		if err := migrateIndexes(ctx, lg, pool); err != nil {
+			log.Fatalf("migrate indexes: %v", err)
+		}
+		return
 	}
 
-	opts.SetAutoReconnect(true)
-	opts.SetConnectionLostHandler(func(_ mqtt.Client, err error) {
-		log.Printf("mqtt connection lost: %v", err)
+	if sensorTypeStats {
+		if err := printSensorTypeStats(ctx, os.Stdout, pool); err != nil {
+			log.Fatalf("sensor type stats: %v", err)
+		}
+		return
+	}
+
+	if testDBWrite {
+		code := runDBWriteTest(ctx, os.Stdout, pool)
+		pool.Close()
+		os.Exit(code)
+	}
+
+	if uplinkStats {
+		if err := printUplinkStats(ctx, os.Stdout, pool, uplinkStatsAppID); err != nil {
+			log.Fatalf("uplink stats: %v", err)
+		}
+		return
+	}
+
+	if exportCSVMode {
+		args := flag.Args()
+		if len(args) != 4 {
+			log.Fatalf("usage: ingestor -export-csv stationEUI startDate endDate outputFile")
+		}
+		if err := exportCSV(ctx, pool, args[0], args[1], args[2], args[3]); err != nil {
+			log.Fatalf("export csv: %v", err)
+		}
+		return
+	}
+
+	if exportParquetMode {
+		args := flag.Args()
+		if len(args) != 4 {
+			log.Fatalf("usage: ingestor -export-parquet stationEUI startDate endDate outputFile")
+		}
+		if err := exportParquet(ctx, pool, args[0], args[1], args[2], args[3]); err != nil {
+			log.Fatalf("export parquet: %v", err)
+		}
+		return
+	}
+
+	// The retry queue gets its own connections: inserts fail over to it
+	// precisely when the ingest pool is exhausted or timing out.
+	retryPoolCfg := poolCfg.Copy()
+	retryPoolCfg.MaxConns = int32(cfg.RetryPoolMaxConns)
+	retryPool, err := pgxpool.NewWithConfig(ctx, retryPoolCfg)
+	if err != nil {
+		log.Fatalf("pgx retry pool: %v", err)
+	}
+	defer retryPool.Close()
+	retryQueue = newRetryQueue(lg, retryPool, cfg.RetryMaxAttempts)
+	downlinks = newDownlinkLog(lg, pool)
+
+	if backfill {
+		ok, failed, err := retryQueue.Backfill(ctx)
+		if err != nil {
+			log.Fatalf("backfill: %v", err)
+		}
+		lg.Info("backfill done: %d inserted, %d failed", ok, failed)
+		return
+	}
+
+	if err := cfg.validateIngest(); err != nil {
+		log.Fatalf("config: %v", err)
+	}
+
+	if cfg.GRPCAddr != "" {
+		measurementStream = newMeasurementBroadcaster()
+		if err := startGRPCServer(ctx, lg, cfg.GRPCAddr, cfg.GRPCToken, cfg.GRPCTLSCertFile, cfg.GRPCTLSKeyFile, measurementStream); err != nil {
+			log.Fatalf("grpc server: %v", err)
+		}
+	}
+
+	if debug {
+		debugMessages = newDebugMessageLog(ctx, lg, pool, time.Duration(cfg.DebugRetentionHours)*time.Hour)
+	}
+
+	if cfg.BatchMaxSize > 1 && cfg.BatchMaxWaitMS > 0 {
+		if cfg.BatchMaxSize*measurementColumns > math.MaxUint16 {
+			log.Fatalf("BATCH_MAX_SIZE: at most %d rows fit in one statement", math.MaxUint16/measurementColumns)
+		}
+		batcher = newMessageBatcher(lg, pool, cfg.BatchMaxSize, time.Duration(cfg.BatchMaxWaitMS)*time.Millisecond)
+	}
+
+	calibrations = newCalibrationCache(lg, pool, time.Duration(cfg.CalibrationCacheTTLSeconds)*time.Second)
+
+	if cfg.SilenceAlertIntervalMinutes > 0 {
+		go runSilenceMonitor(ctx, lg, pool,
+			time.Duration(cfg.SilenceAlertIntervalMinutes)*time.Minute,
+			time.Duration(cfg.SilenceAlertThresholdMinutes)*time.Minute)
+	}
+
+	if cfg.CompletenessIntervalMinutes > 0 {
+		go runCompletenessUpdater(ctx, lg, pool, time.Duration(cfg.CompletenessIntervalMinutes)*time.Minute)
+	}
+
+	sink, err := buildSink(cfg.SinkFanout, func(name string) (Sink, error) {
+		switch name {
+		case "postgres":
+			return NewStore(lg, pool), nil
+		case "kafka":
+			return NewKafkaSink(lg, cfg.KafkaBrokers, cfg.KafkaSinkTopic), nil
+		case "newrelic":
+			return NewNewRelicSink(lg, cfg.NewRelicLicenseKey, cfg.NewRelicAccountID, cfg.NewRelicRegion)
+		default:
+			return nil, fmt.Errorf("unknown sink %q (expecting postgres, kafka or newrelic)", name)
+		}
 	})
-	opts.SetOnConnectHandler(func(c mqtt.Client) {
-		if token := c.Subscribe(topic, 0, func(_ mqtt.Client, msg mqtt.Message) {
-			handleMessage(ctx, pool, msg)
-		}); token.Wait() && token.Error() != nil {
-			log.Printf("subscribe error: %v", token.Error())
+	if err != nil {
+		log.Fatalf("SINK_FANOUT: %v", err)
+	}
+	if emitNDJSON {
+		if cfg.NDJSONSkipDB {
+			closeSink(sink)
+			sink = NewNDJSONSink(lg, os.Stdout)
 		} else {
-			log.Printf("subscribed to %s", topic)
+			sink = NewFanOutSink(sink, NewNDJSONSink(lg, os.Stdout))
 		}
-	})
+	}
+	sink = processingTimer{inner: sink}
+	if cfg.FCntReplayCheck {
+		sink = newReplayGuard(lg, pool, sink)
+	}
+	if cfg.UplinkTokenDedup {
+		sink = newUplinkTokenGuard(ctx, lg, pool, sink, time.Duration(cfg.UplinkTokenTTLHours)*time.Hour)
+	}
 
-	client := mqtt.NewClient(opts)
-	if token := client.Connect(); token.Wait() && token.Error() != nil {
-		log.Fatalf("mqtt connect: %v", token.Error())
+	retryQueue.ingest = func(ctx context.Context, p *Parsed) error { return ingestParked(ctx, sink, p) }
+	go retryQueue.Run(ctx)
+
+	if cfg.OrderByFrameCounter {
+		orderTimeout := time.Duration(cfg.OrderTimeoutSeconds) * time.Second
+		frameOrder = newFrameOrderer(ctx, lg, orderTimeout, func(ctx context.Context, p *Parsed) {
+			notifyError("db", p.StationEUI, sink.InsertMeasurements(ctx, p))
+		})
+	}
+
+	if cfg.IngestAPITokens != "" {
+		ingestAPI = newIngestHandler(lg, sink, cfg.IngestAPITokens, cfg.IngestRateLimitRPS, cfg.IngestRateLimitBurst)
+	}
+
+	var client mqtt.Client
+	switch {
+	case simulateN > 0:
+		go func() {
+			runSimulation(ctx, lg, sink, simulateN, simulateRate)
+			cancel()
+		}()
+	case replayFile != "":
+		go func() {
+			if err := runReplayFile(ctx, lg, sink, replayFile, replaySpeed); err != nil && ctx.Err() == nil {
+				lg.Error("replay: %v", err)
+			}
+			cancel()
+		}()
+	case cfg.Mode == "mqtt":
+		connectionEvents = newConnectionEventLog(ctx, lg, pool, time.Duration(cfg.ConnectionEventRetentionDays)*24*time.Hour)
+		handle := func(msg mqtt.Message) {
+			handleMessage(ctx, lg, sink, msg)
+		}
+		if cfg.MultiAppConfigPath != "" {
+			apps, err := loadMultiAppConfig(cfg.MultiAppConfigPath, cfg.MQTTHost)
+			if err != nil {
+				log.Fatalf("MULTI_APP_CONFIG_PATH: %v", err)
+			}
+			multiApp = NewMultiAppIngestor(lg, cfg, apps, handle)
+			if err := multiApp.Connect(); err != nil {
+				lg.Error("mqtt connect: %v", err)
+			}
+			client = multiApp.Primary()
+		} else {
+			client = connectMQTT(lg, cfg, handle)
+		}
+		if thresholdAlerts != nil && cfg.AlertMQTTTopic != "" {
+			thresholdAlerts.addPublisher(mqttAlertPublisher(lg, client, cfg.AlertMQTTTopic))
+		}
+		if diagLog != nil {
+			diagLog.attach(client, cfg.MQTTDiagnosticTopic, cfg.OTELServiceName)
+		}
+		if cfg.TTNAPIKeyExpiresAt != "" && multiApp == nil {
+			expiresAt, err := time.Parse(time.RFC3339, cfg.TTNAPIKeyExpiresAt)
+			if err != nil {
+				log.Fatalf("TTN_API_KEY_EXPIRES_AT: %v", err)
+			}
+			go runKeyExpiryMonitor(ctx, lg, expiresAt)
+		}
+		if cfg.WatchdogTimeoutMinutes > 0 {
+			go runMQTTWatchdog(ctx, lg, client, time.Duration(cfg.WatchdogTimeoutMinutes)*time.Minute)
+		}
+	case cfg.Mode == "webhook":
+		startWebhookServer(ctx, lg, cfg.WebhookAddr, sink, cfg.WebhookSecret, cfg.WebhookSigningKey,
+			cfg.WebhookRateLimitRPS, cfg.WebhookRateLimitBurst)
+	case cfg.Mode == "kafka":
+		go runKafkaConsumer(ctx, lg, sink, cfg.KafkaBrokers, cfg.KafkaTopic, cfg.KafkaGroupID)
+	case cfg.Mode == "udp":
+		go func() {
+			if err := NewUDPListener(lg, pool, sink, !cfg.FCntReplayCheck).Run(ctx, cfg.UDPListenAddr); err != nil {
+				log.Fatalf("udp listener: %v", err)
+			}
+		}()
+	default:
+		log.Fatalf("unknown MODE %q (expecting mqtt, webhook, kafka or udp)", cfg.Mode)
+	}
+
+	startHealthServer(ctx, lg, ":"+cfg.HealthPort, pool, client, cfg.EnablePprof)
+
+	if statsInterval > 0 {
+		go runStatsPrinter(ctx, statsInterval)
 	}
 
-	log.Println("ingestor running. Ctrl+C to exit.")
+	if healthcheckInterval > 0 {
+		go runDBLatencyCheck(ctx, lg, pool, healthcheckInterval,
+			time.Duration(cfg.DBLatencyWarnMS)*time.Millisecond)
+	}
+
+	lg.Info("ingestor %s running. Ctrl+C to exit.", version)
 	<-ctx.Done()
-	log.Println("shutdown signal received")
-	client.Disconnect(250)
+	lg.Info("shutdown signal received")
+	switch {
+	case multiApp != nil:
+		multiApp.Disconnect(250)
+	case client != nil:
+		client.Disconnect(250)
+	}
+	if frameOrder != nil {
+		flushCtx, flushCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		frameOrder.flushAll(flushCtx)
+		flushCancel()
+	}
+	if batcher != nil {
+		flushCtx, flushCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		batcher.flush(flushCtx)
+		flushCancel()
+	}
+	closeSink(sink)
 }
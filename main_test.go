@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func TestExtractAppIDFromTopic(t *testing.T) {
+	tests := []struct {
+		topic, want string
+	}{
+		{"v3/weather-app/devices/station-1/up", "weather-app"},
+		{"v3/weather-app@ttn/devices/station-1/up", "weather-app"},
+		{"v3/weather-app@tenant/devices/station-1/join", "weather-app"},
+		{"v3/weather-app/devices", ""},
+		{"v3/weather-app/gateways/gw-1/up", ""},
+		{"v2/weather-app/devices/station-1/up", ""},
+		{"weather/uplinks", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := extractAppIDFromTopic(tt.topic); got != tt.want {
+			t.Errorf("extractAppIDFromTopic(%q) = %q, want %q", tt.topic, got, tt.want)
+		}
+	}
+}
+
+// Records the uplinks it receives.
+type captureSink struct {
+	got []*Parsed
+}
+
+func (s *captureSink) InsertMeasurements(_ context.Context, p *Parsed) error {
+	s.got = append(s.got, p)
+	return nil
+}
+
+func TestIngestUplinkTopicAppIDFallback(t *testing.T) {
+	lg := NewLogger(io.Discard, false)
+	tests := []struct {
+		name, payload, want string
+	}{
+		{"payload without app ID", `{"end_device_ids":{"dev_eui":"70B3D57ED0000001"}}`, "from-topic"},
+		{"payload app ID wins", `{"end_device_ids":{"dev_eui":"70B3D57ED0000001","application_ids":{"application_id":"from-payload"}}}`, "from-payload"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &captureSink{}
+			topicAppID := extractAppIDFromTopic("v3/from-topic@ttn/devices/station-1/up")
+			if err := ingestUplink(context.Background(), lg, sink, []byte(tt.payload), topicAppID, time.Now()); err != nil {
+				t.Fatal(err)
+			}
+			if len(sink.got) != 1 {
+				t.Fatalf("got %d uplinks, want 1", len(sink.got))
+			}
+			if got := sink.got[0].AppID; got != tt.want {
+				t.Errorf("app ID %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseUplinkAppID(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"nested", `{"end_device_ids":{"dev_eui":"70B3D57ED0000001","application_ids":{"application_id":"nested-app"}}}`, "nested-app"},
+		{"top level", `{"end_device_ids":{"dev_eui":"70B3D57ED0000001"},"application_ids":{"application_id":"top-app"}}`, "top-app"},
+		{"both, nested wins", `{"end_device_ids":{"dev_eui":"70B3D57ED0000001","application_ids":{"application_id":"nested-app"}},"application_ids":{"application_id":"top-app"}}`, "nested-app"},
+		{"neither", `{"end_device_ids":{"dev_eui":"70B3D57ED0000001"}}`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var up UpCommon
+			if err := json.Unmarshal([]byte(tt.in), &up); err != nil {
+				t.Fatal(err)
+			}
+			if got := up.appID(); got != tt.want {
+				t.Errorf("appID() = %q, want %q", got, tt.want)
+			}
+			p, err := parseUplink(&TestLogger{}, []byte(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.AppID != tt.want {
+				t.Errorf("parseUplink AppID = %q, want %q", p.AppID, tt.want)
+			}
+		})
+	}
+}
+
+func TestIngestUplinkCalibrationUnavailable(t *testing.T) {
+	// Nothing listens on port 1, so every calibration lookup fails.
+	pool, err := pgxpool.New(context.Background(), "postgres://ingestor@127.0.0.1:1/weather?connect_timeout=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	lg := &TestLogger{}
+	oldCalibrations, oldRetryQueue := calibrations, retryQueue
+	calibrations, retryQueue = newCalibrationCache(lg, pool, time.Minute), nil
+	defer func() { calibrations, retryQueue = oldCalibrations, oldRetryQueue }()
+
+	const payload = `{"end_device_ids":{"dev_eui":"70B3D57ED0000001","application_ids":{"application_id":"app"}},` +
+		`"uplink_message":{"f_cnt":7,"decoded_payload":{"slaves":[{"id":1,"sensors":[{"format":1,"index":0,"type":1,"value":21.5}]}]}}}`
+	sink := &captureSink{}
+	err = ingestUplink(context.Background(), lg, sink, []byte(payload), "", time.Now())
+	if !errors.Is(err, errIngestUnavailable) {
+		t.Fatalf("ingestUplink error %v, want errIngestUnavailable", err)
+	}
+	if len(sink.got) != 0 {
+		t.Fatalf("%d uplinks reached the sink uncalibrated", len(sink.got))
+	}
+
+	// Without a retry queue the uplink is lost, but not silently.
+	parkUplink(context.Background(), lg, err)
+	if msgs := lg.messages("ERROR"); len(msgs) == 0 || !strings.HasPrefix(msgs[len(msgs)-1], "uplink dropped") {
+		t.Errorf("dropping the uplink wasn't logged: %q", msgs)
+	}
+
+	// The parked payload brings back the uplink, readings included.
+	var ce *calibrationUnavailableError
+	if !errors.As(err, &ce) {
+		t.Fatalf("error %T carries no uplink", err)
+	}
+	b, err := uplinkRetryPayload(ce.p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r retryPayload
+	if err := json.Unmarshal(b, &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Uplink == nil {
+		t.Fatalf("payload %s has no uplink", b)
+	}
+	p := r.Uplink
+	decodeDecodedPayload(lg, &p.Msg, p.StationEUI)
+	if p.StationEUI != "70B3D57ED0000001" || p.Msg.FCnt != 7 {
+		t.Errorf("parked uplink %s f_cnt %d", p.StationEUI, p.Msg.FCnt)
+	}
+	if s := p.Msg.DecodedPayload.Slaves; len(s) != 1 || len(s[0].Sensors) != 1 || s[0].Sensors[0].Value != 21.5 {
+		t.Errorf("parked readings %+v", s)
+	}
+
+	// Once it is retried it goes on as a late frame.
+	calibrations = nil
+	if err := ingestParked(context.Background(), sink, p); err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.got) != 1 || !sink.got[0].lateFrame {
+		t.Errorf("retried uplink not passed on as a late frame")
+	}
+}
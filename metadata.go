@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//--- Station metadata and latest readings ---//
+
+const selectStationSQL = `
+SELECT station_eui, application_id, station_devid, last_uplink_at FROM stations WHERE station_eui = $1;
+`
+
+const selectDeviceMetadataSQL = `
+SELECT key, value FROM device_metadata WHERE station_eui = $1 ORDER BY key;
+`
+
+const deleteDeviceMetadataSQL = `
+DELETE FROM device_metadata WHERE station_eui = $1 AND NOT (key = ANY($2));
+`
+
+const upsertDeviceMetadataSQL = `
+INSERT INTO device_metadata(station_eui, key, value, updated_at)
+VALUES ($1,$2,$3,now())
+ON CONFLICT (station_eui, key) DO UPDATE
+SET value = EXCLUDED.value, updated_at = now()
+WHERE device_metadata.value IS DISTINCT FROM EXCLUDED.value;
+`
+
+const selectLatestMeasurementsSQL = `
+SELECT DISTINCT ON (slave_id, sensor_type, sensor_index)
+       time, slave_id, sensor_type, sensor_index, value
+FROM measurements
+WHERE station_eui = $1
+ORDER BY slave_id, sensor_type, sensor_index, time DESC;
+`
+
+// Max accepted metadata document.
+const maxMetadataBody = 64 << 10
+
+type latestMeasurementJSON struct {
+	Time        time.Time `json:"time"`
+	SlaveID     int       `json:"slave_id"`
+	SensorType  int       `json:"sensor_type"`
+	SensorIndex int       `json:"sensor_index"`
+	Value       float64   `json:"value"`
+	// Position label from slave_sensor_map, if one is set.
+	Label string `json:"label,omitempty"`
+}
+
+type stationLatestJSON struct {
+	StationEUI    string                  `json:"station_eui"`
+	ApplicationID string                  `json:"application_id"`
+	StationDevID  *string                 `json:"station_devid"`
+	LastUplinkAt  *time.Time              `json:"last_uplink_at"`
+	Metadata      map[string]string       `json:"metadata"`
+	Measurements  []latestMeasurementJSON `json:"measurements"`
+}
+
+// Returns the upper-cased {eui} path value, or "" after writing a 400.
+func stationEUIParam(w http.ResponseWriter, r *http.Request) string {
+	eui := strings.ToUpper(r.PathValue("eui"))
+	if !validateEUI64(eui) {
+		http.Error(w, "invalid station EUI", http.StatusBadRequest)
+		return ""
+	}
+	return eui
+}
+
+func queryDeviceMetadata(r *http.Request, pool *pgxpool.Pool, eui string) (map[string]string, error) {
+	rows, err := pool.Query(r.Context(), selectDeviceMetadataSQL, eui)
+	if err != nil {
+		return nil, err
+	}
+	md := map[string]string{}
+	var k, v string
+	_, err = pgx.ForEachRow(rows, []any{&k, &v}, func() error {
+		md[k] = v
+		return nil
+	})
+	return md, err
+}
+
+// GET /api/v1/stations/{eui}/metadata
+func handleGetMetadata(lg Logger, pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		eui := stationEUIParam(w, r)
+		if eui == "" {
+			return
+		}
+		md, err := queryDeviceMetadata(r, pool, eui)
+		if err != nil {
+			lg.Error("metadata query error: %v", err)
+			http.Error(w, "query failed", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, md)
+	}
+}
+
+// PUT /api/v1/stations/{eui}/metadata with a JSON object of string values.
+// Replaces the station's metadata: keys missing from the body are removed.
+func handlePutMetadata(lg Logger, pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		eui := stationEUIParam(w, r)
+		if eui == "" {
+			return
+		}
+		b, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxMetadataBody))
+		if err != nil {
+			http.Error(w, "read body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		var md map[string]string
+		if err := json.Unmarshal(b, &md); err != nil {
+			http.Error(w, "body must be a JSON object of strings", http.StatusBadRequest)
+			return
+		}
+
+		err = pgx.BeginFunc(r.Context(), pool, func(tx pgx.Tx) error {
+			keys := make([]string, 0, len(md))
+			for k := range md {
+				keys = append(keys, k)
+			}
+			if _, err := tx.Exec(r.Context(), deleteDeviceMetadataSQL, eui, keys); err != nil {
+				return err
+			}
+			for k, v := range md {
+				if _, err := tx.Exec(r.Context(), upsertDeviceMetadataSQL, eui, k, v); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			// device_metadata references stations, so unknown EUIs fail here.
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+				http.Error(w, "station not found", http.StatusNotFound)
+				return
+			}
+			lg.Error("metadata update error (eui: %s): %v", eui, err)
+			http.Error(w, "update failed", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, md)
+	}
+}
+
+// GET /api/v1/stations/{eui}/latest: the newest value of every
+// slave/sensor/index of a station, plus its metadata.
+func handleStationLatest(lg Logger, pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		eui := stationEUIParam(w, r)
+		if eui == "" {
+			return
+		}
+
+		var res stationLatestJSON
+		err := pool.QueryRow(r.Context(), selectStationSQL, eui).
+			Scan(&res.StationEUI, &res.ApplicationID, &res.StationDevID, &res.LastUplinkAt)
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "station not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			lg.Error("station query error: %v", err)
+			http.Error(w, "query failed", http.StatusInternalServerError)
+			return
+		}
+
+		if res.Metadata, err = queryDeviceMetadata(r, pool, eui); err != nil {
+			lg.Error("metadata query error: %v", err)
+			http.Error(w, "query failed", http.StatusInternalServerError)
+			return
+		}
+
+		labels, err := querySensorLabels(r, pool, eui)
+		if err != nil {
+			lg.Error("sensor labels query error: %v", err)
+			http.Error(w, "query failed", http.StatusInternalServerError)
+			return
+		}
+
+		if measurementCache != nil {
+			if ms, ok := measurementCache.latest(eui); ok {
+				applySensorLabels(ms, labels)
+				res.Measurements = ms
+				writeJSON(w, http.StatusOK, res)
+				return
+			}
+		}
+
+		rows, err := pool.Query(r.Context(), selectLatestMeasurementsSQL, eui)
+		if err != nil {
+			lg.Error("latest measurements query error: %v", err)
+			http.Error(w, "query failed", http.StatusInternalServerError)
+			return
+		}
+		res.Measurements = []latestMeasurementJSON{}
+		var m latestMeasurementJSON
+		var sensorType, sensorIndex int16
+		_, err = pgx.ForEachRow(rows, []any{&m.Time, &m.SlaveID, &sensorType, &sensorIndex, &m.Value}, func() error {
+			m.SensorType, m.SensorIndex = int(sensorType), int(sensorIndex)
+			res.Measurements = append(res.Measurements, m)
+			return nil
+		})
+		if err != nil {
+			lg.Error("latest measurements query error: %v", err)
+			http.Error(w, "query failed", http.StatusInternalServerError)
+			return
+		}
+		if measurementCache != nil {
+			measurementCache.fill(eui, res.Measurements)
+		}
+		applySensorLabels(res.Measurements, labels)
+		writeJSON(w, http.StatusOK, res)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+//--- Prometheus metrics ---//
+
+// Set at build time: go build -ldflags "-X main.version=v1.2.3".
+var version = "dev"
+
+var (
+	uplinkProcessingSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ingestor_uplink_processing_seconds",
+		Help:    "Time from receiving an uplink to its write to the sinks, by station EUI prefix (PROM_EUI_PREFIX_LEN).",
+		Buckets: []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5},
+	}, []string{"station_eui"})
+)
+
+func init() {
+	prometheus.MustRegister(uplinkProcessingSeconds)
+
+	// Expose the counters behind -stats as well.
+	counters := []struct {
+		name, help string
+		load       func() uint64
+	}{
+		{"ingestor_messages_total", "Uplink messages received.", stats.Messages.Load},
+		{"ingestor_measurements_total", "Measurements written.", stats.Measurements.Load},
+		{"ingestor_parse_errors_total", "Uplinks rejected by the parser.", stats.ParseErrors.Load},
+		{"ingestor_db_errors_total", "Failed DB statements.", stats.DBErrors.Load},
+	}
+	for _, c := range counters {
+		load := c.load
+		prometheus.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: c.name,
+			Help: c.help,
+		}, func() float64 { return float64(load()) }))
+	}
+}
+
+// Registers ingestor_build_info, a constant 1 labelled with the resource
+// attributes that identify this deployment (OpenTelemetry semantic
+// convention names, with dots replaced by underscores).
+func registerBuildInfo(serviceName, deploymentEnv string) {
+	host, _ := os.Hostname()
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "ingestor_build_info",
+		Help: "Service identity of this ingestor instance; always 1.",
+		ConstLabels: prometheus.Labels{
+			"service_name":           serviceName,
+			"service_version":        version,
+			"deployment_environment": deploymentEnv,
+			"host_name":              host,
+		},
+	}, func() float64 { return 1 }))
+}
+
+// Set from PROM_EUI_PREFIX_LEN: when > 0, station_eui label values are cut
+// to this many characters, so stations sharing a prefix share a series.
+var promEUIPrefixLen int
+
+// Returns the station_eui label value for eui.
+func promStationLabel(eui string) string {
+	if promEUIPrefixLen > 0 && len(eui) > promEUIPrefixLen {
+		return eui[:promEUIPrefixLen]
+	}
+	return eui
+}
+
+func observeUplinkProcessing(stationEUI string, start time.Time) {
+	uplinkProcessingSeconds.WithLabelValues(promStationLabel(stationEUI)).Observe(time.Since(start).Seconds())
+}
+
+// processingTimer wraps the sinks that write an uplink and observes
+// ingestor_uplink_processing_seconds once they succeeded. Uplinks dropped
+// by the dedup or replay guards (which wrap it) or still buffered for
+// FCnt ordering are not observed.
+type processingTimer struct {
+	inner Sink
+}
+
+func (t processingTimer) InsertMeasurements(ctx context.Context, p *Parsed) error {
+	err := t.inner.InsertMeasurements(ctx, p)
+	if err == nil && !p.received.IsZero() {
+		observeUplinkProcessing(p.StationEUI, p.received)
+	}
+	return err
+}
+
+func (t processingTimer) Close() error {
+	closeSink(t.inner)
+	return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type errSink struct{ err error }
+
+func (s errSink) InsertMeasurements(context.Context, *Parsed) error { return s.err }
+
+func TestProcessingTimerObservesWrites(t *testing.T) {
+	uplinkProcessingSeconds.Reset()
+	defer uplinkProcessingSeconds.Reset()
+	oldPrefixLen := promEUIPrefixLen
+	promEUIPrefixLen = 6
+	defer func() { promEUIPrefixLen = oldPrefixLen }()
+
+	received := time.Now()
+	ok := processingTimer{inner: errSink{}}
+	for _, eui := range []string{"70B3D57ED0000001", "70B3D57ED0000002"} {
+		_ = ok.InsertMeasurements(context.Background(), &Parsed{StationEUI: eui, received: received})
+	}
+	failing := processingTimer{inner: errSink{errors.New("db down")}}
+	_ = failing.InsertMeasurements(context.Background(), &Parsed{StationEUI: "0004A30B00000001", received: received})
+
+	if n := testutil.CollectAndCount(uplinkProcessingSeconds); n != 1 {
+		t.Fatalf("%d series, want 1 (one EUI prefix, failed write not observed)", n)
+	}
+}
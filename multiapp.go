@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"gopkg.in/yaml.v3"
+)
+
+//--- Several TTN applications (MULTI_APP_CONFIG_PATH) ---//
+
+// How long Connect waits for every application before leaving the rest to
+// retry in the background.
+const mqttConnectWait = 30 * time.Second
+
+// Set when MULTI_APP_CONFIG_PATH is used instead of a single MQTT_* broker.
+var multiApp *MultiAppIngestor
+
+// One entry of the multi app file:
+//
+//	apps:
+//	  - app_id: openclimate
+//	    api_key: NNSXS.XXXX
+//	    region_host: au1.cloud.thethings.network # default MQTT_HOST
+//	    topic: v3/openclimate@ttn/devices/+/up   # default as shown
+type ttnAppConfig struct {
+	AppID      string `yaml:"app_id"`
+	APIKey     string `yaml:"api_key"`
+	RegionHost string `yaml:"region_host"`
+	Topic      string `yaml:"topic"`
+}
+
+// Reads the apps in path, filling in region_host from defaultHost and the
+// topic from the app ID where left out.
+func loadMultiAppConfig(path, defaultHost string) ([]ttnAppConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f struct {
+		Apps []ttnAppConfig `yaml:"apps"`
+	}
+	if err := yaml.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if len(f.Apps) == 0 {
+		return nil, fmt.Errorf("parse %s: no apps listed", path)
+	}
+	seen := map[string]bool{}
+	for i := range f.Apps {
+		app := &f.Apps[i]
+		if app.AppID == "" || app.APIKey == "" {
+			return nil, fmt.Errorf("parse %s: app %d: app_id and api_key are required", path, i+1)
+		}
+		if seen[app.AppID] {
+			return nil, fmt.Errorf("parse %s: app %s is listed twice", path, app.AppID)
+		}
+		seen[app.AppID] = true
+		if app.RegionHost == "" {
+			app.RegionHost = defaultHost
+		}
+		if app.RegionHost == "" {
+			return nil, fmt.Errorf("parse %s: app %s: region_host is required when MQTT_HOST is not set", path, app.AppID)
+		}
+		if app.Topic == "" {
+			app.Topic = "v3/" + app.AppID + "@ttn/devices/+/up"
+		}
+	}
+	return f.Apps, nil
+}
+
+// MultiAppIngestor keeps one MQTT connection per TTN application, all
+// feeding the same handler (and so the same sink and pool). Each client
+// reconnects on its own; connecting and disconnecting is done for all of
+// them together.
+type MultiAppIngestor struct {
+	log     Logger
+	apps    []ttnAppConfig
+	clients []mqtt.Client
+}
+
+// Builds a client per app from cfg's MQTT settings with the app's broker,
+// credentials and topic swapped in.
+func NewMultiAppIngestor(lg Logger, cfg *Config, apps []ttnAppConfig, handle func(mqtt.Message)) *MultiAppIngestor {
+	m := &MultiAppIngestor{log: lg, apps: apps}
+	for _, app := range apps {
+		appCfg := *cfg
+		appCfg.MQTTHost = app.RegionHost
+		appCfg.MQTTTopic = app.Topic
+		appCfg.MQTTUseAuth = true
+		appCfg.MQTTUsername = app.AppID + "@ttn"
+		appCfg.MQTTPassword = app.APIKey
+		appCfg.TTNAppID = app.AppID
+		appCfg.TTNAPIKey = app.APIKey
+		appCfg.MQTTDownlinkTopic = ""
+
+		opts := mqttClientOptions(lg, &appCfg, handle)
+		// Keep retrying an application whose broker or key is
+		// unavailable at startup instead of failing the others.
+		opts.SetConnectRetry(true)
+		m.clients = append(m.clients, mqtt.NewClient(opts))
+		go checkTTNHost(lg, &appCfg)
+	}
+	return m
+}
+
+// Connects every client, waiting until all are connected or have failed
+// once. Clients that failed keep retrying in the background.
+func (m *MultiAppIngestor) Connect() error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(m.clients))
+	for i, c := range m.clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token := c.Connect()
+			// With connect retry the token only completes once connected.
+			if !token.WaitTimeout(mqttConnectWait) {
+				errs[i] = fmt.Errorf("%s: not connected yet, retrying", m.apps[i].AppID)
+				return
+			}
+			if err := token.Error(); err != nil {
+				errs[i] = fmt.Errorf("%s: %w", m.apps[i].AppID, err)
+				return
+			}
+			m.log.Info("mqtt connected for application %s (%s)", m.apps[i].AppID, m.apps[i].RegionHost)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// The first application's client, used for publishing (alerts,
+// diagnostics) and by the watchdog.
+func (m *MultiAppIngestor) Primary() mqtt.Client {
+	return m.clients[0]
+}
+
+// Reports whether every application is connected.
+func (m *MultiAppIngestor) Connected() bool {
+	for _, c := range m.clients {
+		if !c.IsConnectionOpen() {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *MultiAppIngestor) Disconnect(quiesce uint) {
+	var wg sync.WaitGroup
+	for _, c := range m.clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Disconnect(quiesce)
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+//--- NDJSON sink ---//
+
+// NDJSONSink writes each parsed uplink as one JSON object per line, for
+// piping into jq, Vector, Fluent Bit and the like. Logs go to stderr, so the
+// output stays clean.
+type NDJSONSink struct {
+	log Logger
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func NewNDJSONSink(lg Logger, w io.Writer) *NDJSONSink {
+	return &NDJSONSink{log: lg, enc: json.NewEncoder(w)}
+}
+
+func (n *NDJSONSink) InsertMeasurements(_ context.Context, p *Parsed) error {
+	q, err := effectiveParsed(p)
+	if err != nil {
+		n.log.Error("ndjson encode error: %v (eui: %s)", err, p.StationEUI)
+		return err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if err := n.enc.Encode(q); err != nil {
+		n.log.Error("ndjson write error: %v (eui: %s)", err, p.StationEUI)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+)
+
+func TestNDJSONSinkWritesEffectivePayload(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewNDJSONSink(NewLogger(io.Discard, false), &buf)
+	p := &Parsed{StationEUI: "A", Msg: UplinkMsg{
+		// As received: two readings, one of which was dropped as invalid.
+		RawDecodedPayload: json.RawMessage(`{"slaves":[{"id":1,"sensors":[{"type":1,"value":21.5},{"type":2,"value":9999}]}]}`),
+		DecodedPayload: DecodedPayload{Slaves: []slaveReadings{
+			{ID: 1, Sensors: []sensorReading{{Type: 1, Value: 21.5}}},
+		}},
+	}}
+	if err := sink.InsertMeasurements(context.Background(), p); err != nil {
+		t.Fatal(err)
+	}
+
+	var got struct {
+		Msg struct {
+			DecodedPayload DecodedPayload `json:"decoded_payload"`
+		} `json:"uplink_message"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if s := got.Msg.DecodedPayload.Slaves; len(s) != 1 || len(s[0].Sensors) != 1 || s[0].Sensors[0].Value != 21.5 {
+		t.Fatalf("decoded_payload = %+v, want the single valid reading", got.Msg.DecodedPayload)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("9999")) {
+		t.Fatalf("dropped reading written: %s", buf.Bytes())
+	}
+}
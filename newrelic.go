@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+//--- New Relic sink ---//
+
+const (
+	// The Events API rejects payloads of more than 2000 events.
+	newRelicMaxEvents     = 2000
+	newRelicFlushInterval = 5 * time.Second
+	newRelicEventType     = "WeatherbusMeasurement"
+)
+
+// Events API host per NEW_RELIC_REGION.
+var newRelicEventHosts = map[string]string{
+	"US": "insights-collector.newrelic.com",
+	"EU": "insights-collector.eu01.nr-data.net",
+}
+
+// One reading as a New Relic custom event, queryable with
+// SELECT * FROM WeatherbusMeasurement.
+type newRelicEvent struct {
+	EventType    string  `json:"eventType"`
+	Timestamp    int64   `json:"timestamp"` // unix milliseconds
+	StationEUI   string  `json:"stationEui"`
+	StationDevID string  `json:"stationDevId,omitempty"`
+	AppID        string  `json:"appId,omitempty"`
+	GatewayID    string  `json:"gatewayId,omitempty"`
+	SlaveID      int     `json:"slaveId"`
+	SensorType   int     `json:"sensorType"`
+	SensorIndex  int     `json:"sensorIndex"`
+	Value        float64 `json:"value"`
+}
+
+// NewRelicSink publishes every reading as a custom event through the New
+// Relic Events API. Events are buffered and posted every few seconds, or as
+// soon as newRelicMaxEvents are pending; a failed post is logged and its
+// events are dropped.
+type NewRelicSink struct {
+	log    Logger
+	client *http.Client
+	url    string
+	key    string
+
+	mu      sync.Mutex
+	pending []newRelicEvent
+
+	full chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewNewRelicSink(lg Logger, licenseKey, accountID, region string) (*NewRelicSink, error) {
+	host, ok := newRelicEventHosts[strings.ToUpper(region)]
+	if !ok {
+		return nil, fmt.Errorf("unknown New Relic region %q (expecting US or EU)", region)
+	}
+	s := &NewRelicSink{
+		log:    lg,
+		client: &http.Client{Timeout: 30 * time.Second},
+		url:    "https://" + host + "/v1/accounts/" + accountID + "/events",
+		key:    licenseKey,
+		full:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go s.flushLoop()
+	return s, nil
+}
+
+func (s *NewRelicSink) InsertMeasurements(_ context.Context, p *Parsed) error {
+	var gwID string
+	if len(p.Msg.RxMetadata) > 0 {
+		gwID = p.Msg.RxMetadata[0].GatewayIDs.GatewayID
+	}
+	var events []newRelicEvent
+	for _, sl := range p.Msg.DecodedPayload.Slaves {
+		for _, m := range sl.Sensors {
+			if _, ok := validSensorTypes[m.Type]; !ok && !sensorAutodiscovery {
+				continue
+			}
+			events = append(events, newRelicEvent{
+				EventType: newRelicEventType, Timestamp: p.When.UnixMilli(),
+				StationEUI: p.StationEUI, StationDevID: p.StationDevID, AppID: p.AppID, GatewayID: gwID,
+				SlaveID: sl.ID, SensorType: m.Type, SensorIndex: m.Index, Value: m.Value,
+			})
+		}
+	}
+	if len(events) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	s.pending = append(s.pending, events...)
+	full := len(s.pending) >= newRelicMaxEvents
+	s.mu.Unlock()
+	if full {
+		select {
+		case s.full <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (s *NewRelicSink) flushLoop() {
+	defer close(s.done)
+	t := time.NewTicker(newRelicFlushInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.stop:
+			s.flush()
+			return
+		case <-t.C:
+		case <-s.full:
+		}
+		s.flush()
+	}
+}
+
+// Posts everything pending, newRelicMaxEvents events per request.
+func (s *NewRelicSink) flush() {
+	s.mu.Lock()
+	events := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+	for len(events) > 0 {
+		n := min(len(events), newRelicMaxEvents)
+		if err := s.post(events[:n]); err != nil {
+			s.log.Error("new relic publish error: %v (%d events dropped)", err, n)
+		}
+		events = events[n:]
+	}
+}
+
+func (s *NewRelicSink) post(events []newRelicEvent) error {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(events); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.url, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("X-Insert-Key", s.key)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("events API returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Flushes the pending events and stops the flush loop.
+func (s *NewRelicSink) Close() error {
+	close(s.stop)
+	<-s.done
+	return nil
+}
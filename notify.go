@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+//--- Error notifications ---//
+
+// When set (ERROR_NOTIFY_WEBHOOK_URL), bursts of DB or parse errors are
+// posted to a webhook.
+var errorNotify *errorNotifier
+
+// Body posted to ERROR_NOTIFY_WEBHOOK_URL. Error and StationEUI are those of
+// the error that crossed the threshold.
+type errorNotification struct {
+	Error      string    `json:"error"`
+	Count      int       `json:"count"`
+	StationEUI string    `json:"station_eui,omitempty"`
+	TS         time.Time `json:"ts"`
+}
+
+// Counts errors per kind ("db", "parse") over a sliding window and posts a
+// notification once more than threshold fall inside it. Further
+// notifications for the same kind are held back until a window has passed,
+// so a sustained error rate notifies at most once per window.
+type errorNotifier struct {
+	log       Logger
+	url       string
+	threshold int
+	window    time.Duration
+	client    *http.Client
+
+	mu       sync.Mutex
+	recent   map[string][]time.Time
+	notified map[string]time.Time
+}
+
+func newErrorNotifier(lg Logger, url string, threshold int, window time.Duration) *errorNotifier {
+	return &errorNotifier{
+		log: lg, url: url, threshold: threshold, window: window,
+		client: &http.Client{Timeout: 10 * time.Second},
+		recent: map[string][]time.Time{}, notified: map[string]time.Time{},
+	}
+}
+
+// Records an error of the given kind; eui may be empty.
+func (n *errorNotifier) record(kind, eui string, err error) {
+	now := time.Now()
+	n.mu.Lock()
+	times := n.recent[kind]
+	i := 0
+	for i < len(times) && now.Sub(times[i]) > n.window {
+		i++
+	}
+	times = append(times[i:], now)
+	n.recent[kind] = times
+	count := len(times)
+	send := count > n.threshold && now.Sub(n.notified[kind]) > n.window
+	if send {
+		n.notified[kind] = now
+	}
+	n.mu.Unlock()
+
+	if send {
+		go n.post(errorNotification{
+			Error:      fmt.Sprintf("%s error: %v", kind, err),
+			Count:      count,
+			StationEUI: eui,
+			TS:         now.UTC(),
+		})
+	}
+}
+
+func (n *errorNotifier) post(msg errorNotification) {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		n.log.Error("error notification marshal error: %v", err)
+		return
+	}
+	resp, err := n.client.Post(n.url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		n.log.Error("error notification post error: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		n.log.Error("error notification post: webhook returned %s", resp.Status)
+		return
+	}
+	n.log.Info("posted error notification (%d errors within %s): %s", msg.Count, n.window, msg.Error)
+}
+
+// Records err with errorNotify, if configured.
+func notifyError(kind, eui string, err error) {
+	if errorNotify != nil && err != nil {
+		errorNotify.record(kind, eui, err)
+	}
+}
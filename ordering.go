@@ -0,0 +1,286 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+//--- Frame counter ordering ---//
+
+// When set, uplinks are passed through this before being stored so that
+// measurements of a device are written in FCnt order.
+var frameOrder *frameOrderer
+
+// A gap larger than this between the expected and received FCnt is treated
+// as a device reset (or counter rollover) rather than a late frame.
+const fcntResetGap = 1 << 15
+
+// Devices without an uplink for this long (and nothing buffered) are
+// forgotten; their next frame sets a new baseline.
+const frameOrderIdleTimeout = 10 * time.Minute
+
+// FCnts remembered per device to tell a late frame from a duplicate.
+const recentFrameWindow = 64
+
+// Buffers out-of-order uplinks per device until the missing frames arrive or
+// the timeout elapses, then emits them sorted by FCnt.
+//
+// Each device has its own lock and no lock is held while emitting: frames
+// that are ready go to the device's ready list, and whichever goroutine finds
+// nobody emitting for that device writes the list out in order. One slow
+// device therefore never holds up the others.
+type frameOrderer struct {
+	ctx     context.Context // used for emits; buffered frames outlive the request that delivered them
+	log     Logger
+	timeout time.Duration
+	emit    func(context.Context, *Parsed)
+
+	mu      sync.Mutex // guards devices and swept
+	devices map[string]*deviceFrames
+	swept   time.Time
+}
+
+type deviceFrames struct {
+	mu       sync.Mutex
+	started  bool
+	next     uint32 // next FCnt expected
+	pending  frameHeap
+	timer    *time.Timer
+	ready    []*Parsed // in order, waiting to be emitted
+	emitting bool      // a goroutine is emitting ready
+	recent   []uint32  // last recentFrameWindow FCnts passed on
+	skipped  []fcntRange
+	lastSeen time.Time
+}
+
+// FCnts from up to (not including) to that flushPending gave up waiting for.
+// At most recentFrameWindow ranges are kept per device.
+type fcntRange struct{ from, to uint32 }
+
+type frameHeap []*Parsed
+
+func (h frameHeap) Len() int           { return len(h) }
+func (h frameHeap) Less(i, j int) bool { return h[i].Msg.FCnt < h[j].Msg.FCnt }
+func (h frameHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *frameHeap) Push(x any)        { *h = append(*h, x.(*Parsed)) }
+func (h *frameHeap) Pop() any {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+func newFrameOrderer(ctx context.Context, lg Logger, timeout time.Duration, emit func(context.Context, *Parsed)) *frameOrderer {
+	return &frameOrderer{
+		ctx:     ctx,
+		log:     lg,
+		timeout: timeout,
+		emit:    emit,
+		devices: make(map[string]*deviceFrames),
+		swept:   time.Now(),
+	}
+}
+
+// Returns the state of eui, creating it on first use, and forgets idle
+// devices now and then.
+func (o *frameOrderer) device(eui string) *deviceFrames {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if now := time.Now(); now.Sub(o.swept) >= time.Minute {
+		for k, d := range o.devices {
+			d.mu.Lock()
+			idle := now.Sub(d.lastSeen) >= frameOrderIdleTimeout && d.pending.Len() == 0 && len(d.ready) == 0 && !d.emitting
+			d.mu.Unlock()
+			if idle {
+				delete(o.devices, k)
+			}
+		}
+		o.swept = now
+	}
+	d, ok := o.devices[eui]
+	if !ok {
+		d = &deviceFrames{}
+		o.devices[eui] = d
+	}
+	return d
+}
+
+// Emits p right away if it is the next expected frame (followed by any
+// buffered successors), otherwise buffers it until the gap is filled or
+// times out. A frame behind the expected one is passed on marked late if the
+// orderer gave up waiting for it, dropped if it was passed on already, and
+// otherwise passed on as is for the replay guard to judge. FCnt 0 starts a
+// new baseline, as after an OTAA rejoin or ABP reboot.
+func (o *frameOrderer) add(p *Parsed) {
+	d := o.device(p.StationEUI)
+
+	d.mu.Lock()
+	d.lastSeen = time.Now()
+	fcnt := p.Msg.FCnt
+	switch {
+	case !d.started:
+		// First frame of a device sets the baseline.
+		d.started = true
+		d.next = fcnt + 1
+		d.queue(p)
+	case fcnt == d.next:
+		d.queue(p)
+		d.next++
+		d.drain()
+	case fcnt < d.next:
+		switch {
+		case fcnt == 0 && !slices.Contains(d.recent, 0),
+			d.next-fcnt > fcntResetGap && d.pending.Len() == 0:
+			o.log.Debug("fcnt reset for %s: %d -> %d", p.StationEUI, d.next-1, fcnt)
+			d.flushPending()
+			d.recent, d.skipped = nil, nil
+			d.next = fcnt + 1
+			d.queue(p)
+		case slices.Contains(d.recent, fcnt):
+			o.log.Debug("dropping duplicate frame for %s: fcnt %d", p.StationEUI, fcnt)
+		case d.takeSkipped(fcnt):
+			o.log.Debug("late frame for %s: fcnt %d, expected %d", p.StationEUI, fcnt, d.next)
+			p.lateFrame = true
+			d.queue(p)
+		default:
+			o.log.Debug("old frame for %s: fcnt %d, expected %d", p.StationEUI, fcnt, d.next)
+			d.queue(p)
+		}
+	default:
+		o.log.Debug("buffering frame for %s: fcnt %d, expected %d", p.StationEUI, fcnt, d.next)
+		heap.Push(&d.pending, p)
+		if d.timer == nil {
+			eui := p.StationEUI
+			d.timer = time.AfterFunc(o.timeout, func() { o.flush(eui) })
+		}
+	}
+	d.mu.Unlock()
+
+	o.run(o.ctx, d)
+}
+
+// Appends p to the frames ready to emit. Callers must hold d.mu.
+func (d *deviceFrames) queue(p *Parsed) {
+	d.ready = append(d.ready, p)
+	if len(d.recent) == recentFrameWindow {
+		d.recent = d.recent[1:]
+	}
+	d.recent = append(d.recent, p.Msg.FCnt)
+}
+
+// Queues buffered frames that are now in sequence. Callers must hold d.mu.
+func (d *deviceFrames) drain() {
+	for d.pending.Len() > 0 && d.pending[0].Msg.FCnt <= d.next {
+		p := heap.Pop(&d.pending).(*Parsed)
+		d.queue(p)
+		if p.Msg.FCnt == d.next {
+			d.next++
+		}
+	}
+	if d.pending.Len() == 0 && d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+}
+
+// Gives up on missing frames and queues everything buffered. Callers must
+// hold d.mu.
+func (d *deviceFrames) flushPending() {
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	for d.pending.Len() > 0 {
+		p := heap.Pop(&d.pending).(*Parsed)
+		if p.Msg.FCnt > d.next {
+			d.skip(d.next, p.Msg.FCnt)
+		}
+		d.queue(p)
+		d.next = max(d.next, p.Msg.FCnt+1)
+	}
+}
+
+// Records the FCnts from up to to as given up on. Callers must hold d.mu.
+func (d *deviceFrames) skip(from, to uint32) {
+	if len(d.skipped) == recentFrameWindow {
+		d.skipped = d.skipped[1:]
+	}
+	d.skipped = append(d.skipped, fcntRange{from, to})
+}
+
+// Reports whether fcnt was given up on, and forgets it if so. Callers must
+// hold d.mu.
+func (d *deviceFrames) takeSkipped(fcnt uint32) bool {
+	for i, r := range d.skipped {
+		if fcnt < r.from || fcnt >= r.to {
+			continue
+		}
+		var rest []fcntRange
+		if r.from < fcnt {
+			rest = append(rest, fcntRange{r.from, fcnt})
+		}
+		if fcnt+1 < r.to {
+			rest = append(rest, fcntRange{fcnt + 1, r.to})
+		}
+		d.skipped = slices.Replace(d.skipped, i, i+1, rest...)
+		return true
+	}
+	return false
+}
+
+// Emits the ready frames of d in order, unless another goroutine already
+// does; that one picks up frames queued meanwhile.
+func (o *frameOrderer) run(ctx context.Context, d *deviceFrames) {
+	d.mu.Lock()
+	if d.emitting {
+		d.mu.Unlock()
+		return
+	}
+	d.emitting = true
+	for len(d.ready) > 0 {
+		batch := d.ready
+		d.ready = nil
+		d.mu.Unlock()
+		for _, p := range batch {
+			o.emit(ctx, p)
+		}
+		d.mu.Lock()
+	}
+	d.emitting = false
+	d.mu.Unlock()
+}
+
+// Gives up on missing frames of a device and emits everything buffered.
+func (o *frameOrderer) flush(eui string) {
+	o.mu.Lock()
+	d, ok := o.devices[eui]
+	o.mu.Unlock()
+	if !ok {
+		return
+	}
+	d.mu.Lock()
+	d.flushPending()
+	d.mu.Unlock()
+	o.run(o.ctx, d)
+}
+
+// Emits all buffered frames using ctx; used on shutdown once o.ctx is done.
+func (o *frameOrderer) flushAll(ctx context.Context) {
+	o.mu.Lock()
+	devices := make([]*deviceFrames, 0, len(o.devices))
+	for _, d := range o.devices {
+		devices = append(devices, d)
+	}
+	o.mu.Unlock()
+
+	for _, d := range devices {
+		d.mu.Lock()
+		d.flushPending()
+		d.mu.Unlock()
+		o.run(ctx, d)
+	}
+}
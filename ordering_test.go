@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+type emitted struct {
+	mu     sync.Mutex
+	frames map[string][]uint32
+	late   map[string][]uint32
+}
+
+func (e *emitted) emit(_ context.Context, p *Parsed) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.frames[p.StationEUI] = append(e.frames[p.StationEUI], p.Msg.FCnt)
+	if p.lateFrame {
+		e.late[p.StationEUI] = append(e.late[p.StationEUI], p.Msg.FCnt)
+	}
+}
+
+func newTestOrderer(timeout time.Duration) (*frameOrderer, *emitted) {
+	e := &emitted{frames: map[string][]uint32{}, late: map[string][]uint32{}}
+	return newFrameOrderer(context.Background(), NewLogger(io.Discard, false), timeout, e.emit), e
+}
+
+func frame(eui string, fcnt uint32) *Parsed {
+	return &Parsed{StationEUI: eui, Msg: UplinkMsg{FCnt: fcnt}}
+}
+
+func TestFrameOrdererReorders(t *testing.T) {
+	o, e := newTestOrderer(time.Hour)
+	for _, f := range []uint32{10, 12, 13, 11, 14} {
+		o.add(frame("A", f))
+	}
+	if got, want := e.frames["A"], []uint32{10, 11, 12, 13, 14}; !slices.Equal(got, want) {
+		t.Fatalf("emitted %v, want %v", got, want)
+	}
+}
+
+func TestFrameOrdererLateAndDuplicate(t *testing.T) {
+	o, e := newTestOrderer(10 * time.Millisecond)
+	o.add(frame("A", 100))
+	o.add(frame("A", 102)) // 101 is missing; flushed after the timeout
+	time.Sleep(50 * time.Millisecond)
+	o.add(frame("A", 101)) // late, never passed on
+	o.add(frame("A", 102)) // duplicate
+	o.add(frame("A", 101)) // duplicate of the late frame
+	o.add(frame("A", 50))  // older than the baseline: not late, left to the replay guard
+
+	if got, want := e.frames["A"], []uint32{100, 102, 101, 50}; !slices.Equal(got, want) {
+		t.Fatalf("emitted %v, want %v", got, want)
+	}
+	if got, want := e.late["A"], []uint32{101}; !slices.Equal(got, want) {
+		t.Fatalf("late %v, want %v", got, want)
+	}
+}
+
+func TestFrameOrdererRejoin(t *testing.T) {
+	o, e := newTestOrderer(time.Hour)
+	o.add(frame("A", 200))
+	o.add(frame("A", 201))
+	o.add(frame("A", 203)) // buffered, waiting for 202
+	// The device rejoins and its FCnt starts over.
+	for _, f := range []uint32{0, 2, 1, 0, 3} {
+		o.add(frame("A", f))
+	}
+
+	if got, want := e.frames["A"], []uint32{200, 201, 203, 0, 1, 2, 3}; !slices.Equal(got, want) {
+		t.Fatalf("emitted %v, want %v", got, want)
+	}
+	if got := e.late["A"]; len(got) != 0 {
+		t.Fatalf("late %v, want none", got)
+	}
+}
+
+func TestFrameOrdererConcurrentDevices(t *testing.T) {
+	o, e := newTestOrderer(time.Hour)
+	var wg sync.WaitGroup
+	for d := range 8 {
+		eui := fmt.Sprintf("dev-%d", d)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			o.add(frame(eui, 0))
+			for f := uint32(1); f <= 100; f += 2 {
+				o.add(frame(eui, f+1))
+				o.add(frame(eui, f))
+			}
+		}()
+	}
+	wg.Wait()
+	for eui, got := range e.frames {
+		if len(got) != 101 || !slices.IsSorted(got) {
+			t.Errorf("%s: emitted %d frames, sorted %v", eui, len(got), slices.IsSorted(got))
+		}
+	}
+}
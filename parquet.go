@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/parquet-go/parquet-go"
+)
+
+//--- Parquet export ---//
+
+const exportMeasurementsFullSQL = `
+SELECT time, station_eui, station_devid, slave_id, sensor_type, sensor_index, value, format,
+       gateway_id, latitude, longitude
+FROM measurements
+WHERE station_eui = $1 AND time >= $2 AND time < $3
+ORDER BY time, slave_id, sensor_type, sensor_index;
+`
+
+// Rows written per parquet.GenericWriter.Write call.
+const parquetBatchSize = 10000
+
+// Mirrors the measurements table; pointer fields are optional columns.
+type parquetMeasurement struct {
+	Time         time.Time `parquet:"time,timestamp(millisecond)"`
+	StationEUI   string    `parquet:"station_eui,dict"`
+	StationDevID *string   `parquet:"station_devid,optional"`
+	SlaveID      int32     `parquet:"slave_id"`
+	SensorType   int32     `parquet:"sensor_type"`
+	SensorIndex  int32     `parquet:"sensor_index"`
+	Value        float64   `parquet:"value"`
+	Format       *int32    `parquet:"format,optional"`
+	GatewayID    *string   `parquet:"gateway_id,optional,dict"`
+	Latitude     *float64  `parquet:"latitude,optional"`
+	Longitude    *float64  `parquet:"longitude,optional"`
+}
+
+// Writes all measurements for a station in [start, end) to outPath as a
+// Parquet file.
+func exportParquet(ctx context.Context, pool *pgxpool.Pool, stationEUI, startArg, endArg, outPath string) error {
+	start, err := parseDateArg(startArg, false)
+	if err != nil {
+		return err
+	}
+	end, err := parseDateArg(endArg, true)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rows, err := pool.Query(ctx, exportMeasurementsFullSQL, strings.ToUpper(stationEUI), start, end)
+	if err != nil {
+		return fmt.Errorf("query measurements: %w", err)
+	}
+	defer rows.Close()
+
+	w := parquet.NewGenericWriter[parquetMeasurement](f)
+	batch := make([]parquetMeasurement, 0, parquetBatchSize)
+	n := 0
+	flush := func() error {
+		if _, err := w.Write(batch); err != nil {
+			return fmt.Errorf("write parquet: %w", err)
+		}
+		n += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	for rows.Next() {
+		var m parquetMeasurement
+		if err := rows.Scan(&m.Time, &m.StationEUI, &m.StationDevID, &m.SlaveID, &m.SensorType, &m.SensorIndex,
+			&m.Value, &m.Format, &m.GatewayID, &m.Latitude, &m.Longitude); err != nil {
+			return fmt.Errorf("scan measurement: %w", err)
+		}
+		batch = append(batch, m)
+		if len(batch) == parquetBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("read measurements: %w", err)
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("close parquet: %w", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "exported %d measurements for %s\n", n, strings.ToUpper(stationEUI))
+	return f.Close()
+}
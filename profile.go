@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+	"time"
+)
+
+//--- One-shot profiling ---//
+
+// Starts a CPU profile to cpuPath (if set) and returns a function that stops
+// it and writes a heap profile to memPath (if set). With window > 0 that
+// happens by itself once the window has elapsed; the returned function is
+// safe to call again at exit either way.
+func startProfiling(lg Logger, cpuPath, memPath string, window time.Duration) (func(), error) {
+	var cpuFile *os.File
+	if cpuPath != "" {
+		f, err := os.Create(cpuPath)
+		if err != nil {
+			return nil, fmt.Errorf("create CPU profile: %w", err)
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("start CPU profile: %w", err)
+		}
+		cpuFile = f
+		lg.Info("CPU profiling to %s", cpuPath)
+	}
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			if cpuFile != nil {
+				pprof.StopCPUProfile()
+				if err := cpuFile.Close(); err != nil {
+					lg.Error("close CPU profile: %v", err)
+				} else {
+					lg.Info("wrote CPU profile %s", cpuPath)
+				}
+			}
+			if memPath != "" {
+				if err := writeHeapProfile(memPath); err != nil {
+					lg.Error("heap profile: %v", err)
+				} else {
+					lg.Info("wrote heap profile %s", memPath)
+				}
+			}
+		})
+	}
+	if window > 0 {
+		time.AfterFunc(window, stop)
+	}
+	return stop, nil
+}
+
+func writeHeapProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	runtime.GC() // up to date allocation statistics
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
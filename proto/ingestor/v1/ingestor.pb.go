@@ -0,0 +1,245 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: proto/ingestor/v1/ingestor.proto
+
+package ingestorv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type WatchRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Only stream measurements of these stations; empty streams all stations.
+	StationEuis []string `protobuf:"bytes,1,rep,name=station_euis,json=stationEuis,proto3" json:"station_euis,omitempty"`
+	// Only stream these sensor types; empty streams all types.
+	SensorTypes   []int32 `protobuf:"varint,2,rep,packed,name=sensor_types,json=sensorTypes,proto3" json:"sensor_types,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchRequest) Reset() {
+	*x = WatchRequest{}
+	mi := &file_proto_ingestor_v1_ingestor_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchRequest) ProtoMessage() {}
+
+func (x *WatchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_ingestor_v1_ingestor_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchRequest.ProtoReflect.Descriptor instead.
+func (*WatchRequest) Descriptor() ([]byte, []int) {
+	return file_proto_ingestor_v1_ingestor_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *WatchRequest) GetStationEuis() []string {
+	if x != nil {
+		return x.StationEuis
+	}
+	return nil
+}
+
+func (x *WatchRequest) GetSensorTypes() []int32 {
+	if x != nil {
+		return x.SensorTypes
+	}
+	return nil
+}
+
+type MeasurementEvent struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	Time        *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=time,proto3" json:"time,omitempty"`
+	StationEui  string                 `protobuf:"bytes,2,opt,name=station_eui,json=stationEui,proto3" json:"station_eui,omitempty"`
+	SlaveId     int32                  `protobuf:"varint,3,opt,name=slave_id,json=slaveId,proto3" json:"slave_id,omitempty"`
+	SensorType  int32                  `protobuf:"varint,4,opt,name=sensor_type,json=sensorType,proto3" json:"sensor_type,omitempty"`
+	SensorIndex int32                  `protobuf:"varint,5,opt,name=sensor_index,json=sensorIndex,proto3" json:"sensor_index,omitempty"`
+	Value       float64                `protobuf:"fixed64,6,opt,name=value,proto3" json:"value,omitempty"`
+	// Empty when the uplink carried no gateway metadata.
+	GatewayId     string `protobuf:"bytes,7,opt,name=gateway_id,json=gatewayId,proto3" json:"gateway_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MeasurementEvent) Reset() {
+	*x = MeasurementEvent{}
+	mi := &file_proto_ingestor_v1_ingestor_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MeasurementEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MeasurementEvent) ProtoMessage() {}
+
+func (x *MeasurementEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_ingestor_v1_ingestor_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MeasurementEvent.ProtoReflect.Descriptor instead.
+func (*MeasurementEvent) Descriptor() ([]byte, []int) {
+	return file_proto_ingestor_v1_ingestor_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *MeasurementEvent) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+func (x *MeasurementEvent) GetStationEui() string {
+	if x != nil {
+		return x.StationEui
+	}
+	return ""
+}
+
+func (x *MeasurementEvent) GetSlaveId() int32 {
+	if x != nil {
+		return x.SlaveId
+	}
+	return 0
+}
+
+func (x *MeasurementEvent) GetSensorType() int32 {
+	if x != nil {
+		return x.SensorType
+	}
+	return 0
+}
+
+func (x *MeasurementEvent) GetSensorIndex() int32 {
+	if x != nil {
+		return x.SensorIndex
+	}
+	return 0
+}
+
+func (x *MeasurementEvent) GetValue() float64 {
+	if x != nil {
+		return x.Value
+	}
+	return 0
+}
+
+func (x *MeasurementEvent) GetGatewayId() string {
+	if x != nil {
+		return x.GatewayId
+	}
+	return ""
+}
+
+var File_proto_ingestor_v1_ingestor_proto protoreflect.FileDescriptor
+
+const file_proto_ingestor_v1_ingestor_proto_rawDesc = "" +
+	"\n" +
+	" proto/ingestor/v1/ingestor.proto\x12\vingestor.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"T\n" +
+	"\fWatchRequest\x12!\n" +
+	"\fstation_euis\x18\x01 \x03(\tR\vstationEuis\x12!\n" +
+	"\fsensor_types\x18\x02 \x03(\x05R\vsensorTypes\"\xf7\x01\n" +
+	"\x10MeasurementEvent\x12.\n" +
+	"\x04time\x18\x01 \x01(\v2\x1a.google.protobuf.TimestampR\x04time\x12\x1f\n" +
+	"\vstation_eui\x18\x02 \x01(\tR\n" +
+	"stationEui\x12\x19\n" +
+	"\bslave_id\x18\x03 \x01(\x05R\aslaveId\x12\x1f\n" +
+	"\vsensor_type\x18\x04 \x01(\x05R\n" +
+	"sensorType\x12!\n" +
+	"\fsensor_index\x18\x05 \x01(\x05R\vsensorIndex\x12\x14\n" +
+	"\x05value\x18\x06 \x01(\x01R\x05value\x12\x1d\n" +
+	"\n" +
+	"gateway_id\x18\a \x01(\tR\tgatewayId2b\n" +
+	"\x0fIngestorService\x12O\n" +
+	"\x11WatchMeasurements\x12\x19.ingestor.v1.WatchRequest\x1a\x1d.ingestor.v1.MeasurementEvent0\x01B:Z8weatherbus-lorawan-ingestor/proto/ingestor/v1;ingestorv1b\x06proto3"
+
+var (
+	file_proto_ingestor_v1_ingestor_proto_rawDescOnce sync.Once
+	file_proto_ingestor_v1_ingestor_proto_rawDescData []byte
+)
+
+func file_proto_ingestor_v1_ingestor_proto_rawDescGZIP() []byte {
+	file_proto_ingestor_v1_ingestor_proto_rawDescOnce.Do(func() {
+		file_proto_ingestor_v1_ingestor_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_proto_ingestor_v1_ingestor_proto_rawDesc), len(file_proto_ingestor_v1_ingestor_proto_rawDesc)))
+	})
+	return file_proto_ingestor_v1_ingestor_proto_rawDescData
+}
+
+var file_proto_ingestor_v1_ingestor_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_proto_ingestor_v1_ingestor_proto_goTypes = []any{
+	(*WatchRequest)(nil),          // 0: ingestor.v1.WatchRequest
+	(*MeasurementEvent)(nil),      // 1: ingestor.v1.MeasurementEvent
+	(*timestamppb.Timestamp)(nil), // 2: google.protobuf.Timestamp
+}
+var file_proto_ingestor_v1_ingestor_proto_depIdxs = []int32{
+	2, // 0: ingestor.v1.MeasurementEvent.time:type_name -> google.protobuf.Timestamp
+	0, // 1: ingestor.v1.IngestorService.WatchMeasurements:input_type -> ingestor.v1.WatchRequest
+	1, // 2: ingestor.v1.IngestorService.WatchMeasurements:output_type -> ingestor.v1.MeasurementEvent
+	2, // [2:3] is the sub-list for method output_type
+	1, // [1:2] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_proto_ingestor_v1_ingestor_proto_init() }
+func file_proto_ingestor_v1_ingestor_proto_init() {
+	if File_proto_ingestor_v1_ingestor_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_ingestor_v1_ingestor_proto_rawDesc), len(file_proto_ingestor_v1_ingestor_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_proto_ingestor_v1_ingestor_proto_goTypes,
+		DependencyIndexes: file_proto_ingestor_v1_ingestor_proto_depIdxs,
+		MessageInfos:      file_proto_ingestor_v1_ingestor_proto_msgTypes,
+	}.Build()
+	File_proto_ingestor_v1_ingestor_proto = out.File
+	file_proto_ingestor_v1_ingestor_proto_goTypes = nil
+	file_proto_ingestor_v1_ingestor_proto_depIdxs = nil
+}
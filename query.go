@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//--- Measurement queries ---//
+
+// Unset filter fields are passed as NULL and match everything; LIMIT NULL
+// returns all rows. Columns are in MeasurementRow field order.
+const queryMeasurementsSQL = `
+SELECT time, station_eui, station_devid, slave_id, sensor_type, sensor_index, value,
+       coalesce(format, 0), gateway_id, latitude, longitude, raw_value, frm_payload, message_id
+FROM measurements
+WHERE ($1::text IS NULL OR station_eui = $1)
+  AND ($2::timestamptz IS NULL OR time >= $2)
+  AND ($3::timestamptz IS NULL OR time < $3)
+  AND ($4::int[] IS NULL OR sensor_type = ANY($4))
+ORDER BY time, station_eui, slave_id, sensor_type, sensor_index
+LIMIT $5;
+`
+
+// MeasurementFilter selects measurements for Store.QueryMeasurements. Zero
+// values don't filter; EndTime is exclusive.
+type MeasurementFilter struct {
+	StationEUI  string
+	StartTime   time.Time
+	EndTime     time.Time
+	SensorTypes []int
+	Limit       int
+}
+
+// Returns the measurements matching f, oldest first.
+func (st *Store) QueryMeasurements(ctx context.Context, f MeasurementFilter) ([]MeasurementRow, error) {
+	eui := nullIfEmpty(strings.ToUpper(f.StationEUI))
+	var start, end *time.Time
+	if !f.StartTime.IsZero() {
+		start = &f.StartTime
+	}
+	if !f.EndTime.IsZero() {
+		end = &f.EndTime
+	}
+	var types []int // nil, not empty, when unfiltered so it is sent as NULL
+	if len(f.SensorTypes) > 0 {
+		types = f.SensorTypes
+	}
+	var limit *int
+	if f.Limit > 0 {
+		limit = &f.Limit
+	}
+
+	rows, err := st.pool.Query(ctx, queryMeasurementsSQL, eui, start, end, types, limit)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowToStructByPos[MeasurementRow])
+}
+
+// Rows returned by GET /api/v1/measurements without a limit, and the most
+// it returns with one.
+const (
+	defaultMeasurementsLimit = 1000
+	maxMeasurementsLimit     = 10000
+)
+
+// Builds the filter of GET /api/v1/measurements from its query string:
+// station_eui, start and end (RFC 3339), sensor_type (comma separated) and
+// limit.
+func measurementFilterFromQuery(q url.Values) (MeasurementFilter, error) {
+	f := MeasurementFilter{StationEUI: q.Get("station_eui"), Limit: defaultMeasurementsLimit}
+	if f.StationEUI != "" && !validateEUI64(f.StationEUI) {
+		return f, errors.New("invalid station_eui")
+	}
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+	}{{"start", &f.StartTime}, {"end", &f.EndTime}} {
+		if v := q.Get(p.name); v != "" {
+			var err error
+			if *p.t, err = time.Parse(time.RFC3339, v); err != nil {
+				return f, fmt.Errorf("invalid %s (expecting RFC 3339)", p.name)
+			}
+		}
+	}
+	if v := q.Get("sensor_type"); v != "" {
+		for _, s := range strings.Split(v, ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(s))
+			if err != nil {
+				return f, errors.New("invalid sensor_type")
+			}
+			f.SensorTypes = append(f.SensorTypes, n)
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxMeasurementsLimit {
+			return f, fmt.Errorf("invalid limit (1-%d)", maxMeasurementsLimit)
+		}
+		f.Limit = n
+	}
+	return f, nil
+}
+
+// GET /api/v1/measurements?station_eui=...&start=...&end=...&sensor_type=1,2&limit=100
+func handleQueryMeasurements(lg Logger, pool *pgxpool.Pool) http.HandlerFunc {
+	st := NewStore(lg, pool)
+	return func(w http.ResponseWriter, r *http.Request) {
+		f, err := measurementFilterFromQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rows, err := st.QueryMeasurements(r.Context(), f)
+		if err != nil {
+			lg.Error("measurements query error: %v", err)
+			http.Error(w, "query failed", http.StatusInternalServerError)
+			return
+		}
+		if rows == nil {
+			rows = []MeasurementRow{}
+		}
+		writeJSON(w, http.StatusOK, rows)
+	}
+}
@@ -0,0 +1,61 @@
+//go:build integration
+
+package main
+
+import (
+	"context"
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestStoreQueryMeasurements(t *testing.T) {
+	pool := integrationPool(t)
+	ctx := context.Background()
+	st := NewStore(NewLogger(io.Discard, false), pool)
+
+	t0 := time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC)
+	for i, r := range []MeasurementRow{
+		{Time: t0, StationEUI: "70B3D57ED0000001", SlaveID: 1, SensorType: 1, Value: 20},
+		{Time: t0.Add(time.Hour), StationEUI: "70B3D57ED0000001", SlaveID: 1, SensorType: 2, Value: 55},
+		{Time: t0.Add(2 * time.Hour), StationEUI: "70B3D57ED0000001", SlaveID: 1, SensorType: 1, Value: 21},
+		{Time: t0.Add(time.Hour), StationEUI: "70B3D57ED0000002", SlaveID: 1, SensorType: 1, Value: 19},
+	} {
+		if err := insertMeasurement(ctx, pool, r); err != nil {
+			t.Fatalf("row %d: %v", i, err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter MeasurementFilter
+		values []float64
+	}{
+		{"all", MeasurementFilter{}, []float64{20, 55, 19, 21}},
+		{"station, lower case", MeasurementFilter{StationEUI: "70b3d57ed0000001"}, []float64{20, 55, 21}},
+		{"time range, end exclusive", MeasurementFilter{StartTime: t0.Add(time.Hour), EndTime: t0.Add(2 * time.Hour)}, []float64{55, 19}},
+		{"sensor types", MeasurementFilter{SensorTypes: []int{2}}, []float64{55}},
+		{"limit", MeasurementFilter{Limit: 2}, []float64{20, 55}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := st.QueryMeasurements(ctx, tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var values []float64
+			for _, r := range rows {
+				values = append(values, r.Value)
+			}
+			if !slices.Equal(values, tt.values) {
+				t.Fatalf("values %v, want %v", values, tt.values)
+			}
+			if rows[0].MessageID == uuid.Nil {
+				t.Error("message_id not read back")
+			}
+		})
+	}
+}
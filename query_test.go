@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestMeasurementFilterFromQuery(t *testing.T) {
+	start := time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		query   string
+		want    MeasurementFilter
+		wantErr bool
+	}{
+		{"", MeasurementFilter{Limit: defaultMeasurementsLimit}, false},
+		{
+			"station_eui=70b3d57ed0000001&start=2025-05-01T00:00:00Z&end=2025-05-02T00:00:00Z&sensor_type=1,%202&limit=50",
+			MeasurementFilter{StationEUI: "70b3d57ed0000001", StartTime: start, EndTime: start.Add(24 * time.Hour), SensorTypes: []int{1, 2}, Limit: 50},
+			false,
+		},
+		{"station_eui=station-1", MeasurementFilter{}, true},
+		{"start=yesterday", MeasurementFilter{}, true},
+		{"end=2025-05-02", MeasurementFilter{}, true},
+		{"sensor_type=1,x", MeasurementFilter{}, true},
+		{"limit=0", MeasurementFilter{}, true},
+		{"limit=10001", MeasurementFilter{}, true},
+	}
+	for _, tt := range tests {
+		q, err := url.ParseQuery(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := measurementFilterFromQuery(q)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%q: no error", tt.query)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.query, err)
+			continue
+		}
+		if got.StationEUI != tt.want.StationEUI || !got.StartTime.Equal(tt.want.StartTime) || !got.EndTime.Equal(tt.want.EndTime) ||
+			!slices.Equal(got.SensorTypes, tt.want.SensorTypes) || got.Limit != tt.want.Limit {
+			t.Errorf("%q: got %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+//--- Per-IP rate limiting ---//
+
+// Limiters for clients not seen for this long are dropped.
+const rateLimitIdle = 10 * time.Minute
+
+type ipLimiter struct {
+	lim      *rate.Limiter
+	lastSeen time.Time
+}
+
+// Token bucket per client IP, keyed on the connection's remote address (not
+// X-Forwarded-For, which the client controls).
+type ipRateLimiter struct {
+	mu    sync.Mutex
+	ips   map[string]*ipLimiter
+	rps   rate.Limit
+	burst int
+}
+
+func newIPRateLimiter(ctx context.Context, rps float64, burst int) *ipRateLimiter {
+	rl := &ipRateLimiter{ips: map[string]*ipLimiter{}, rps: rate.Limit(rps), burst: burst}
+	go rl.evictLoop(ctx)
+	return rl
+}
+
+func (rl *ipRateLimiter) limiter(ip string) *rate.Limiter {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	l, ok := rl.ips[ip]
+	if !ok {
+		l = &ipLimiter{lim: rate.NewLimiter(rl.rps, rl.burst)}
+		rl.ips[ip] = l
+	}
+	l.lastSeen = time.Now()
+	return l.lim
+}
+
+func (rl *ipRateLimiter) evictLoop(ctx context.Context) {
+	t := time.NewTicker(time.Minute)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			rl.mu.Lock()
+			for ip, l := range rl.ips {
+				if now.Sub(l.lastSeen) > rateLimitIdle {
+					delete(rl.ips, ip)
+				}
+			}
+			rl.mu.Unlock()
+		}
+	}
+}
+
+// Wraps next, answering 429 with a Retry-After header once a client IP runs
+// out of tokens.
+func (rl *ipRateLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		if allowRequest(w, rl.limiter(ip)) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// Takes a token from lim, or answers 429 with a Retry-After header (seconds
+// until the next token) and returns false.
+func allowRequest(w http.ResponseWriter, lim *rate.Limiter) bool {
+	res := lim.Reserve()
+	if delay := res.Delay(); delay > 0 {
+		res.Cancel()
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+		http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+//--- Raw payload decoders ---//
+
+// RawDecoder turns a binary frm_payload into the structure the TTN payload
+// formatter would have produced. Used when no formatter is configured in TTN.
+type RawDecoder func(b []byte) (DecodedPayload, error)
+
+// Built-in decoders, selectable by name in RAW_DECODERS.
+var builtinRawDecoders = map[string]RawDecoder{
+	"weatherbus":    decodeWeatherBus,
+	"temp-humidity": decodeTempHumidity,
+}
+
+// Decoders registered per FPort.
+var rawDecoders = map[int]RawDecoder{}
+
+func RegisterRawDecoder(fport int, d RawDecoder) {
+	rawDecoders[fport] = d
+}
+
+// Registers decoders from a RAW_DECODERS list such as "1=weatherbus,2=temp-humidity".
+func registerRawDecoders(list string) error {
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		portStr, name, ok := strings.Cut(entry, "=")
+		if !ok {
+			return fmt.Errorf("invalid entry %q (expecting fport=decoder)", entry)
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			return fmt.Errorf("invalid fport in %q", entry)
+		}
+		d, ok := builtinRawDecoders[name]
+		if !ok {
+			return fmt.Errorf("unknown decoder %q", name)
+		}
+		RegisterRawDecoder(port, d)
+	}
+	return nil
+}
+
+// Decodes msg.FrmPayload with the decoder registered for its FPort, if any,
+// when the uplink arrived without decoded slaves.
+func applyRawDecoder(lg Logger, msg *UplinkMsg, devEUI string) {
+	if len(msg.DecodedPayload.Slaves) > 0 || msg.FrmPayload == "" {
+		return
+	}
+	d, ok := rawDecoders[msg.FPort]
+	if !ok {
+		return
+	}
+	b, err := base64.StdEncoding.DecodeString(msg.FrmPayload)
+	if err != nil {
+		lg.Warn("frm_payload from %s is not base64: %v", devEUI, err)
+		return
+	}
+	dp, err := d(b)
+	if err != nil {
+		lg.Warn("raw decode of fport %d from %s failed: %v", msg.FPort, devEUI, err)
+		return
+	}
+	lg.Debug("raw decoded fport %d from %s: %d slaves", msg.FPort, devEUI, len(dp.Slaves))
+	msg.DecodedPayload = dp
+}
+
+// Go port of payload-formatter.js: repeated [slave id u16 BE][count u8]
+// followed by count x [type u8][format<<5 | index u8][value].
+func decodeWeatherBus(b []byte) (DecodedPayload, error) {
+	var dp DecodedPayload
+	pos := 0
+	for pos+3 <= len(b) {
+		sid := int(binary.BigEndian.Uint16(b[pos:]))
+		count := int(b[pos+2])
+		pos += 3
+
+		slave := slaveReadings{ID: sid}
+		for i := 0; i < count; i++ {
+			if pos+2 > len(b) {
+				return dp, fmt.Errorf("truncated sensor header")
+			}
+			typ, hdr := int(b[pos]), b[pos+1]
+			pos += 2
+			format, index := int(hdr>>5), int(hdr&0x1f)
+
+			n, ok := weatherBusFormatLen[format]
+			if !ok {
+				return dp, fmt.Errorf("unknown format %d", format)
+			}
+			if pos+n > len(b) {
+				return dp, fmt.Errorf("truncated sensor value")
+			}
+			raw := b[pos : pos+n]
+			pos += n
+
+			var v float64
+			switch format {
+			case 0:
+				v = float64(raw[0])
+			case 1:
+				v = float64(binary.LittleEndian.Uint16(raw))
+			case 2:
+				v = float64(math.Float32frombits(binary.LittleEndian.Uint32(raw)))
+			case 3:
+				v = math.Float64frombits(binary.LittleEndian.Uint64(raw))
+			case 4:
+				v = float64(int16(binary.LittleEndian.Uint16(raw))) / 100
+			case 5:
+				v = float64(binary.LittleEndian.Uint16(raw)) / 10
+			}
+			slave.Sensors = append(slave.Sensors, sensorReading{Format: format, Index: index, Type: typ, Value: v})
+		}
+		dp.Slaves = append(dp.Slaves, slave)
+	}
+	return dp, nil
+}
+
+// Value length in bytes per WeatherBus format code.
+var weatherBusFormatLen = map[int]int{0: 1, 1: 2, 2: 4, 3: 8, 4: 2, 5: 2}
+
+// Simple 4 byte layout used by many off-the-shelf sensors: temperature and
+// relative humidity as big-endian int16 in hundredths (°C, %RH).
+func decodeTempHumidity(b []byte) (DecodedPayload, error) {
+	if len(b) < 4 {
+		return DecodedPayload{}, fmt.Errorf("need 4 bytes, got %d", len(b))
+	}
+	temp := float64(int16(binary.BigEndian.Uint16(b[0:]))) / 100
+	hum := float64(int16(binary.BigEndian.Uint16(b[2:]))) / 100
+	return DecodedPayload{Slaves: []slaveReadings{{
+		ID: 0,
+		Sensors: []sensorReading{
+			{Format: 4, Type: 1, Value: temp},
+			{Format: 4, Type: 2, Value: hum},
+		},
+	}}}, nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//--- Replay protection ---//
+
+// How long a cached FCnt is trusted before re-reading it from the DB, so that
+// several instances sharing the table converge quickly.
+const frameStateCacheTTL = time.Minute
+
+const selectLastFCntSQL = `
+SELECT last_fcnt, coalesce(session_key_id, '') FROM device_frame_state WHERE station_eui = $1;
+`
+
+// Only ever moves last_fcnt forward, so an instance with a stale cache can't
+// rewind what another one stored. An empty session key ID ($3) keeps the
+// stored one.
+const upsertLastFCntSQL = `
+INSERT INTO device_frame_state(station_eui, last_fcnt, session_key_id, updated_at)
+VALUES ($1, $2, nullif($3::text, ''), now())
+ON CONFLICT (station_eui) DO UPDATE
+SET last_fcnt = EXCLUDED.last_fcnt,
+    session_key_id = coalesce(EXCLUDED.session_key_id, device_frame_state.session_key_id),
+    updated_at = now()
+WHERE device_frame_state.last_fcnt < EXCLUDED.last_fcnt;
+`
+
+// Starts a new sequence after a counter reset (see fcntAccept).
+const resetLastFCntSQL = `
+INSERT INTO device_frame_state(station_eui, last_fcnt, session_key_id, updated_at)
+VALUES ($1, $2, nullif($3::text, ''), now())
+ON CONFLICT (station_eui) DO UPDATE
+SET last_fcnt = EXCLUDED.last_fcnt,
+    session_key_id = coalesce(EXCLUDED.session_key_id, device_frame_state.session_key_id),
+    updated_at = now();
+`
+
+// A 16-bit counter within this many frames of 0xFFFF may wrap around to a
+// FCnt below this.
+const fcntReplayWindow = 1 << 10
+
+// replayGuard wraps a Sink and drops uplinks whose FCnt is not newer than the
+// last one accepted for the device, persisting the last FCnt in
+// device_frame_state. Each device has its own lock, and no lock is held
+// across a database call.
+type replayGuard struct {
+	log   Logger
+	pool  *pgxpool.Pool
+	inner Sink
+
+	mu      sync.Mutex
+	devices map[string]*deviceFCnt
+	swept   time.Time
+}
+
+// Last accepted FCnt of one device.
+type deviceFCnt struct {
+	mu       sync.Mutex
+	loaded   bool // fcnt/known/session were read from the DB at at
+	known    bool
+	fcnt     uint32
+	session  string // session_key_id, if the network server sends one
+	at       time.Time
+	lastSeen time.Time
+}
+
+func newReplayGuard(lg Logger, pool *pgxpool.Pool, inner Sink) *replayGuard {
+	return &replayGuard{log: lg, pool: pool, inner: inner, devices: make(map[string]*deviceFCnt), swept: time.Now()}
+}
+
+func (g *replayGuard) InsertMeasurements(ctx context.Context, p *Parsed) error {
+	fcnt := p.Msg.FCnt
+	d := g.device(p.StationEUI)
+
+	d.mu.Lock()
+	d.lastSeen = time.Now()
+	stale := !d.loaded || time.Since(d.at) >= frameStateCacheTTL
+	d.mu.Unlock()
+	if stale {
+		last, session, known, err := g.loadFCnt(ctx, p.StationEUI)
+		if err != nil {
+			stats.DBErrors.Add(1)
+			g.log.Error("frame state lookup error: %v (eui: %s)", err, p.StationEUI)
+			// Fail open: losing dedup is better than losing data.
+			return g.inner.InsertMeasurements(ctx, p)
+		}
+		d.mu.Lock()
+		if !d.loaded || time.Since(d.at) >= frameStateCacheTTL {
+			d.loaded, d.known, d.fcnt, d.session, d.at = true, known, last, session, time.Now()
+		}
+		d.mu.Unlock()
+	}
+
+	// The check and the update happen under one lock so two copies of the
+	// same frame can't both pass.
+	d.mu.Lock()
+	last, known := d.fcnt, d.known
+	session := p.Msg.SessionKeyID
+	newSession := session != "" && d.session != "" && session != d.session
+	accept, reset := fcntAccept(fcnt, last, newSession)
+	if known && !accept {
+		d.mu.Unlock()
+		if p.lateFrame {
+			// frameOrder already told it apart from a duplicate; last
+			// stays where it is.
+			g.log.Debug("accepting late frame from %s: fcnt %d, last %d", p.StationEUI, fcnt, last)
+			return g.inner.InsertMeasurements(ctx, p)
+		}
+		g.log.Debug("rejecting replayed frame from %s: fcnt %d, last %d", p.StationEUI, fcnt, last)
+		return nil
+	}
+	d.known, d.fcnt = true, fcnt
+	if session != "" {
+		d.session = session
+	}
+	d.mu.Unlock()
+	sql := upsertLastFCntSQL
+	if known && reset {
+		g.log.Info("frame counter of %s reset: fcnt %d, last %d", p.StationEUI, fcnt, last)
+		sql = resetLastFCntSQL
+	}
+
+	if _, err := g.pool.Exec(ctx, sql, p.StationEUI, int64(fcnt), session); err != nil {
+		stats.DBErrors.Add(1)
+		g.log.Error("frame state update error: %v (eui: %s)", err, p.StationEUI)
+	}
+	return g.inner.InsertMeasurements(ctx, p)
+}
+
+func (g *replayGuard) Close() error {
+	closeSink(g.inner)
+	return nil
+}
+
+// Returns the state of eui, creating it on first use. Devices idle for longer
+// than frameStateCacheTTL are dropped now and then; their next uplink reads
+// the DB again anyway.
+func (g *replayGuard) device(eui string) *deviceFCnt {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if now := time.Now(); now.Sub(g.swept) >= frameStateCacheTTL {
+		for k, d := range g.devices {
+			d.mu.Lock()
+			idle := now.Sub(d.lastSeen) >= frameStateCacheTTL
+			d.mu.Unlock()
+			if idle {
+				delete(g.devices, k)
+			}
+		}
+		g.swept = now
+	}
+	d, ok := g.devices[eui]
+	if !ok {
+		d = &deviceFCnt{lastSeen: time.Now()}
+		g.devices[eui] = d
+	}
+	return d
+}
+
+// Reads the last accepted FCnt and session key ID for eui from the DB.
+func (g *replayGuard) loadFCnt(ctx context.Context, eui string) (uint32, string, bool, error) {
+	var last int64
+	var session string
+	err := g.pool.QueryRow(ctx, selectLastFCntSQL, eui).Scan(&last, &session)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, "", false, nil
+	}
+	if err != nil {
+		return 0, "", false, err
+	}
+	return uint32(last), session, true, nil
+}
+
+// Reports whether a frame with fcnt may pass after last, and whether it
+// starts a new sequence. A frame at or behind last is a replay unless there
+// is evidence of a counter reset: a new session (newSession, the session key
+// ID changed with an OTAA rejoin) or a 16-bit counter wrapping around. FCnt
+// 0 after 0 passes too, since TTN omits f_cnt when it is 0 and uplinks of
+// devices without a counter all look like that.
+func fcntAccept(fcnt, last uint32, newSession bool) (accept, reset bool) {
+	switch {
+	case newSession:
+		return true, true
+	case fcnt > last:
+		return true, false
+	case fcnt == 0 && last == 0:
+		return true, false
+	case last <= 0xffff && 0xffff-last < fcntReplayWindow && fcnt < fcntReplayWindow:
+		return true, true
+	default:
+		return false, false
+	}
+}
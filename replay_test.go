@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestFCntAccept(t *testing.T) {
+	tests := []struct {
+		name          string
+		fcnt, last    uint32
+		newSession    bool
+		accept, reset bool
+	}{
+		{"next frame", 11, 10, false, true, false},
+		{"gap forward", 500, 10, false, true, false},
+		{"past 16 bits", 65536, 65535, false, true, false},
+		{"same frame", 10, 10, false, false, false},
+		{"retransmission", 9, 10, false, false, false},
+		{"edge of window", 10, 10 + fcntReplayWindow - 1, false, false, false},
+		{"far behind", 3000, 5000, false, false, false},
+		{"replayed frame 0", 0, 5000, false, false, false},
+		{"rejoin", 0, 5000, true, true, true},
+		{"new session", 12, 5000, true, true, true},
+		{"absent f_cnt", 0, 0, false, true, false},
+		{"lower counter, same session", 3, 40000, false, false, false},
+		{"16-bit wrap", 2, 65534, false, true, true},
+		{"far from a 16-bit wrap", 2, 60000, false, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			accept, reset := fcntAccept(tt.fcnt, tt.last, tt.newSession)
+			if accept != tt.accept || reset != tt.reset {
+				t.Errorf("fcntAccept(%d, %d, %v) = %v, %v; want %v, %v", tt.fcnt, tt.last, tt.newSession, accept, reset, tt.accept, tt.reset)
+			}
+		})
+	}
+}
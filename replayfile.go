@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+//--- File replay ---//
+
+// How often replay progress and lag are logged.
+const replayLogInterval = 30 * time.Second
+
+// Controls the pacing of a file replay. Speed 0 replays as fast as possible;
+// otherwise the gaps between the messages' received_at timestamps are
+// reproduced, divided by Speed (1 = real time, 10 = ten times faster).
+type ReplaySpeed struct {
+	Speed float64
+
+	startWall time.Time
+	startMsg  time.Time
+}
+
+// Sleeps until the message received at msgTime is due. Returns the replay lag
+// (how far the replay is behind the schedule) or ctx's error.
+func (r *ReplaySpeed) wait(ctx context.Context, msgTime time.Time) (time.Duration, error) {
+	if r.Speed <= 0 || msgTime.IsZero() {
+		return 0, ctx.Err()
+	}
+	if r.startWall.IsZero() {
+		r.startWall, r.startMsg = time.Now(), msgTime
+		return 0, nil
+	}
+	due := r.startWall.Add(time.Duration(float64(msgTime.Sub(r.startMsg)) / r.Speed))
+	if d := time.Until(due); d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-t.C:
+		}
+	}
+	return max(time.Since(due), 0), nil
+}
+
+// Feeds the uplinks in path (one TTN /up JSON object per line, e.g. captured
+// with mosquitto_sub) through ingestUplink, paced by speed.
+func runReplayFile(ctx context.Context, lg Logger, sink Sink, path string, speed float64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64<<10), maxDecompressedPayload)
+	pace := &ReplaySpeed{Speed: speed}
+	lastLog := time.Now()
+	n, line := 0, 0
+	var lag time.Duration
+
+	lg.Info("replaying %s at speed %v", path, speed)
+	start := time.Now()
+	for sc.Scan() {
+		line++
+		b := sc.Bytes()
+		if len(b) == 0 {
+			continue
+		}
+		if lag, err = pace.wait(ctx, replayMessageTime(b)); err != nil {
+			return err
+		}
+		if err := ingestUplink(ctx, lg, sink, b, "", time.Now()); err != nil {
+			if errors.Is(err, errIngestUnavailable) {
+				parkUplink(ctx, lg, err)
+			} else {
+				lg.Warn("replay: line %d skipped", line)
+			}
+		}
+		n++
+		if time.Since(lastLog) >= replayLogInterval {
+			lg.Info("replay: %d messages, lag %s", n, lag.Round(time.Millisecond))
+			lastLog = time.Now()
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("read %s line %d: %w", path, line+1, err)
+	}
+	lg.Info("replay done: %d messages in %s (final lag %s)", n, time.Since(start).Round(time.Millisecond), lag.Round(time.Millisecond))
+	return nil
+}
+
+// Returns the received_at of a raw uplink, as parseUplink would pick it, or
+// the zero time if it has none.
+func replayMessageTime(b []byte) time.Time {
+	var up struct {
+		ReceivedAt    time.Time `json:"received_at"`
+		UplinkMessage struct {
+			ReceivedAt time.Time `json:"received_at"`
+		} `json:"uplink_message"`
+	}
+	if json.Unmarshal(b, &up) != nil {
+		return time.Time{}
+	}
+	if !up.UplinkMessage.ReceivedAt.IsZero() {
+		return up.UplinkMessage.ReceivedAt
+	}
+	return up.ReceivedAt
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//--- Retry queue ---//
+
+// When set, measurements that fail to insert are parked in retry_queue, as
+// are uplinks that couldn't be calibrated (see parkUplink).
+var retryQueue *RetryQueue
+
+const (
+	retryPollInterval = 5 * time.Second
+	retryBatchSize    = 100
+	retryBaseDelay    = 10 * time.Second
+	retryMaxDelay     = 30 * time.Minute
+	retryClaimLease   = 5 * time.Minute
+)
+
+const enqueueRetrySQL = `
+INSERT INTO retry_queue(payload, last_error, next_attempt_at)
+VALUES ($1, $2, now() + $3::interval);
+`
+
+// Claims a batch of due entries by pushing their next_attempt_at out by the
+// lease ($3). SKIP LOCKED lets several replicas poll the queue at once
+// without waiting on, or claiming, each other's rows; the lease keeps a
+// claimed row away from other replicas until it is deleted or rescheduled,
+// and hands it back if this replica dies mid-batch.
+const claimRetryBatchSQL = `
+UPDATE retry_queue q
+SET next_attempt_at = now() + $3::interval
+FROM (
+  SELECT id
+  FROM retry_queue
+  WHERE attempt_count < $1 AND next_attempt_at <= now()
+  ORDER BY next_attempt_at
+  LIMIT $2
+  FOR UPDATE SKIP LOCKED
+) due
+WHERE q.id = due.id
+RETURNING q.id, q.payload, q.attempt_count;
+`
+
+// All retryable entries regardless of next_attempt_at, paged by id.
+const selectBackfillBatchSQL = `
+SELECT id, payload, attempt_count
+FROM retry_queue
+WHERE attempt_count < $1 AND id > $2
+ORDER BY id
+LIMIT $3;
+`
+
+const deleteRetrySQL = `DELETE FROM retry_queue WHERE id = $1;`
+
+const rescheduleRetrySQL = `
+UPDATE retry_queue
+SET attempt_count = attempt_count + 1,
+    last_error = $2,
+    next_attempt_at = now() + $3::interval
+WHERE id = $1;
+`
+
+// RetryQueue persists failed measurement inserts in PostgreSQL and retries
+// them with exponential backoff until maxAttempts is reached. Rows that run
+// out of attempts stay in the table for manual inspection.
+type RetryQueue struct {
+	log         Logger
+	pool        *pgxpool.Pool
+	maxAttempts int
+	// Ingests parked uplinks. Unset in -backfill, which leaves them to the
+	// running ingestor.
+	ingest func(context.Context, *Parsed) error
+}
+
+// A retry_queue payload: either a MeasurementRow or, under "uplink", a whole
+// uplink parked before it could be calibrated.
+type retryPayload struct {
+	MeasurementRow
+	Uplink *Parsed `json:"uplink,omitempty"`
+}
+
+// Payload of a parked uplink. The decoded payload is stored as ingested so
+// that payload transforms aren't applied twice.
+func uplinkRetryPayload(p *Parsed) ([]byte, error) {
+	q, err := effectiveParsed(p)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(struct {
+		Uplink *Parsed `json:"uplink"`
+	}{q})
+}
+
+func newRetryQueue(lg Logger, pool *pgxpool.Pool, maxAttempts int) *RetryQueue {
+	return &RetryQueue{log: lg, pool: pool, maxAttempts: maxAttempts}
+}
+
+// Parks a failed row. Errors are only logged: if the DB is down this will
+// fail too, and there is nowhere else to put the row.
+func (q *RetryQueue) Enqueue(ctx context.Context, r MeasurementRow, cause error) {
+	payload, err := json.Marshal(r)
+	if err != nil {
+		q.log.Error("retry enqueue error: %v", err)
+		return
+	}
+	if _, err := q.pool.Exec(ctx, enqueueRetrySQL, payload, cause.Error(), retryBackoff(0)); err != nil {
+		stats.DBErrors.Add(1)
+		q.log.Error("retry enqueue error: %v (eui: %s slave: %d type: %d idx: %d)", err, r.StationEUI, r.SlaveID, r.SensorType, r.SensorIndex)
+		return
+	}
+	q.log.Debug("queued measurement for retry (eui: %s slave: %d type: %d idx: %d)", r.StationEUI, r.SlaveID, r.SensorType, r.SensorIndex)
+}
+
+// Parks an uplink that couldn't be calibrated. Errors are only logged, as
+// for Enqueue.
+func (q *RetryQueue) EnqueueUplink(ctx context.Context, p *Parsed, cause error) {
+	payload, err := uplinkRetryPayload(p)
+	if err != nil {
+		q.log.Error("retry enqueue error: %v", err)
+		return
+	}
+	if _, err := q.pool.Exec(ctx, enqueueRetrySQL, payload, cause.Error(), retryBackoff(0)); err != nil {
+		stats.DBErrors.Add(1)
+		q.log.Error("retry enqueue error: %v (eui: %s f_cnt: %d)", err, p.StationEUI, p.Msg.FCnt)
+		return
+	}
+	q.log.Warn("parked uplink for retry (eui: %s f_cnt: %d)", p.StationEUI, p.Msg.FCnt)
+}
+
+// Polls the queue until ctx is cancelled.
+func (q *RetryQueue) Run(ctx context.Context) {
+	t := time.NewTicker(retryPollInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			q.retryDue(ctx)
+		}
+	}
+}
+
+type retryEntry struct {
+	ID       int64
+	Payload  []byte
+	Attempts int
+}
+
+// Claims up to batchSize due entries for this replica. Entries claimed by
+// another replica are skipped rather than waited for.
+func (q *RetryQueue) NextRetryBatch(ctx context.Context, batchSize int) ([]retryEntry, error) {
+	return q.queryBatch(ctx, claimRetryBatchSQL, q.maxAttempts, batchSize, retryClaimLease)
+}
+
+func (q *RetryQueue) retryDue(ctx context.Context) {
+	batch, err := q.NextRetryBatch(ctx, retryBatchSize)
+	if err != nil {
+		q.log.Debug("retry queue poll error: %v", err)
+		return
+	}
+	for _, e := range batch {
+		q.retry(ctx, e)
+	}
+}
+
+// Retries every entry that still has attempts left, ignoring the backoff
+// schedule. Used by -backfill after a prolonged DB outage.
+func (q *RetryQueue) Backfill(ctx context.Context) (ok, failed int, err error) {
+	var lastID int64
+	for {
+		batch, err := q.queryBatch(ctx, selectBackfillBatchSQL, q.maxAttempts, lastID, retryBatchSize)
+		if err != nil {
+			return ok, failed, err
+		}
+		if len(batch) == 0 {
+			return ok, failed, nil
+		}
+		for _, e := range batch {
+			if q.retry(ctx, e) {
+				ok++
+			} else {
+				failed++
+			}
+			lastID = e.ID
+		}
+	}
+}
+
+func (q *RetryQueue) queryBatch(ctx context.Context, sql string, args ...any) ([]retryEntry, error) {
+	rows, err := q.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batch []retryEntry
+	for rows.Next() {
+		var e retryEntry
+		if err := rows.Scan(&e.ID, &e.Payload, &e.Attempts); err != nil {
+			return nil, err
+		}
+		batch = append(batch, e)
+	}
+	return batch, rows.Err()
+}
+
+// Re-inserts one entry, deleting it on success and rescheduling it on
+// failure. Reports whether the measurement (or uplink) was written.
+func (q *RetryQueue) retry(ctx context.Context, e retryEntry) bool {
+	var r retryPayload
+	if err := json.Unmarshal(e.Payload, &r); err != nil {
+		q.log.Warn("retry queue: dropping undecodable entry %d: %v", e.ID, err)
+		_, _ = q.pool.Exec(ctx, deleteRetrySQL, e.ID)
+		return false
+	}
+	if r.Uplink != nil {
+		return q.retryUplink(ctx, e, r.Uplink)
+	}
+
+	if err := insertMeasurement(ctx, q.pool, r.MeasurementRow); err != nil {
+		q.reschedule(ctx, e, err)
+		return false
+	}
+
+	q.delete(ctx, e)
+	stats.Measurements.Add(1)
+	writerStats.recordInserts(1)
+	q.log.Debug("retried measurement %d (eui: %s slave: %d type: %d idx: %d)", e.ID, r.StationEUI, r.SlaveID, r.SensorType, r.SensorIndex)
+	return true
+}
+
+func (q *RetryQueue) retryUplink(ctx context.Context, e retryEntry, p *Parsed) bool {
+	if q.ingest == nil {
+		return false
+	}
+	decodeDecodedPayload(q.log, &p.Msg, p.StationEUI)
+	if err := q.ingest(ctx, p); err != nil {
+		q.reschedule(ctx, e, err)
+		return false
+	}
+	q.delete(ctx, e)
+	q.log.Debug("retried uplink %d (eui: %s f_cnt: %d)", e.ID, p.StationEUI, p.Msg.FCnt)
+	return true
+}
+
+func (q *RetryQueue) reschedule(ctx context.Context, e retryEntry, cause error) {
+	attempts := e.Attempts + 1
+	if attempts >= q.maxAttempts {
+		q.log.Warn("retry queue: giving up on entry %d after %d attempts: %v", e.ID, attempts, cause)
+	}
+	if _, err := q.pool.Exec(ctx, rescheduleRetrySQL, e.ID, cause.Error(), retryBackoff(attempts)); err != nil {
+		q.log.Error("retry queue reschedule error: %v", err)
+	}
+}
+
+func (q *RetryQueue) delete(ctx context.Context, e retryEntry) {
+	if _, err := q.pool.Exec(ctx, deleteRetrySQL, e.ID); err != nil {
+		q.log.Error("retry queue delete error: %v", err)
+	}
+}
+
+// Delay before the next attempt: retryBaseDelay doubled per attempt, capped.
+func retryBackoff(attempts int) time.Duration {
+	d := retryBaseDelay
+	for i := 0; i < attempts && d < retryMaxDelay; i++ {
+		d *= 2
+	}
+	return min(d, retryMaxDelay)
+}
@@ -0,0 +1,107 @@
+//go:build integration
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Connects to PG_DSN with db/schema.sql applied in a schema of its own,
+// dropped again when the test ends.
+func integrationPool(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	dsn := os.Getenv("PG_DSN")
+	if dsn == "" {
+		t.Skip("PG_DSN is not set")
+	}
+	ctx := context.Background()
+	schema := fmt.Sprintf("ingestor_test_%d", time.Now().UnixNano())
+
+	admin, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := admin.Exec(ctx, "CREATE SCHEMA "+schema); err != nil {
+		admin.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_, _ = admin.Exec(context.Background(), "DROP SCHEMA "+schema+" CASCADE")
+		admin.Close()
+	})
+
+	cfg, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.ConnConfig.RuntimeParams["search_path"] = schema + ",public"
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+	if _, err := pool.Exec(ctx, expectedSchemaSQL); err != nil {
+		t.Fatalf("applying db/schema.sql: %v", err)
+	}
+	return pool
+}
+
+func TestRetryQueueConcurrentConsumers(t *testing.T) {
+	pool := integrationPool(t)
+	ctx := context.Background()
+
+	const entries = 500
+	if _, err := pool.Exec(ctx, `
+INSERT INTO retry_queue(payload, last_error)
+SELECT convert_to('{}', 'UTF8'), 'test' FROM generate_series(1, $1)`, entries); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two replicas polling at once must each get their own rows: every
+	// entry claimed exactly once, and none left behind.
+	var (
+		mu      sync.Mutex
+		claimed = make(map[int64]int)
+		wg      sync.WaitGroup
+	)
+	for c := range 2 {
+		q := newRetryQueue(NewLogger(io.Discard, false), pool, 5)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				batch, err := q.NextRetryBatch(ctx, 20)
+				if err != nil {
+					t.Errorf("consumer %d: %v", c, err)
+					return
+				}
+				if len(batch) == 0 {
+					return
+				}
+				mu.Lock()
+				for _, e := range batch {
+					claimed[e.ID]++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(claimed) != entries {
+		t.Errorf("%d entries claimed, want %d", len(claimed), entries)
+	}
+	for id, n := range claimed {
+		if n != 1 {
+			t.Errorf("entry %d claimed %d times", id, n)
+		}
+	}
+}
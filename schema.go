@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//--- Schema tooling ---//
+
+const latestSchemaVersionSQL = `
+SELECT version, applied_at, coalesce(description, '')
+FROM schema_migrations
+ORDER BY version DESC
+LIMIT 1;
+`
+
+// Prints the latest version recorded in schema_migrations.
+func printSchemaVersion(ctx context.Context, pool *pgxpool.Pool) error {
+	var (
+		version   int
+		appliedAt time.Time
+		desc      string
+	)
+	err := pool.QueryRow(ctx, latestSchemaVersionSQL).Scan(&version, &appliedAt, &desc)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return errors.New("schema_migrations is empty; has db/schema.sql been applied?")
+	}
+	if err != nil {
+		return fmt.Errorf("query schema_migrations: %w", err)
+	}
+
+	fmt.Printf("schema version %d applied at %s", version, appliedAt.UTC().Format(time.RFC3339))
+	if desc != "" {
+		fmt.Printf(" (%s)", desc)
+	}
+	fmt.Println()
+	return nil
+}
+
+// Indexes behind the common measurements queries (latest reading per station,
+// time series per sensor type, gateway lookups). db/schema.sql creates them
+// too, which is fine on an empty table but blocks writes while they build on
+// a live one; -migrate-indexes builds them without that.
+var measurementIndexes = []struct{ name, columns string }{
+	{"ix_measurements_station_time", "station_eui, time DESC"},
+	{"ix_measurements_sensor", "sensor_type, time DESC"},
+	{"ix_measurements_gateway", "gateway_id, station_eui"},
+}
+
+const selectIndexValidSQL = `
+SELECT indisvalid FROM pg_index WHERE indexrelid = to_regclass($1);
+`
+
+const measurementsIsHypertableSQL = `
+SELECT to_regclass('timescaledb_information.hypertables') IS NOT NULL
+   AND EXISTS (SELECT 1 FROM timescaledb_information.hypertables WHERE hypertable_name = 'measurements');
+`
+
+// Creates the measurementIndexes that are missing, each in its own statement
+// outside any transaction. On a plain table that is CREATE INDEX
+// CONCURRENTLY, which doesn't block writes but can't run in a transaction
+// block; TimescaleDB doesn't support CONCURRENTLY on hypertables, so there
+// each chunk is indexed in its own transaction instead
+// (timescaledb.transaction_per_chunk), locking one chunk at a time. A failed
+// or interrupted build leaves an invalid index behind, which IF NOT EXISTS
+// would skip, so invalid indexes are dropped and rebuilt.
+func migrateIndexes(ctx context.Context, lg Logger, pool *pgxpool.Pool) error {
+	var hypertable bool
+	if err := pool.QueryRow(ctx, measurementsIsHypertableSQL).Scan(&hypertable); err != nil {
+		return fmt.Errorf("check for hypertable: %w", err)
+	}
+
+	for _, ix := range measurementIndexes {
+		var valid bool
+		err := pool.QueryRow(ctx, selectIndexValidSQL, ix.name).Scan(&valid)
+		switch {
+		case err == nil && valid:
+			lg.Info("index %s already exists", ix.name)
+			continue
+		case err == nil:
+			lg.Warn("index %s is invalid (interrupted build?), rebuilding", ix.name)
+			drop := "DROP INDEX CONCURRENTLY IF EXISTS " + ix.name
+			if hypertable {
+				drop = "DROP INDEX IF EXISTS " + ix.name
+			}
+			if _, err := pool.Exec(ctx, drop); err != nil {
+				return fmt.Errorf("drop invalid index %s: %w", ix.name, err)
+			}
+		case !errors.Is(err, pgx.ErrNoRows):
+			return fmt.Errorf("check index %s: %w", ix.name, err)
+		}
+
+		create := fmt.Sprintf("CREATE INDEX CONCURRENTLY IF NOT EXISTS %s ON measurements (%s)", ix.name, ix.columns)
+		if hypertable {
+			create = fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON measurements (%s) WITH (timescaledb.transaction_per_chunk)", ix.name, ix.columns)
+		}
+		start := time.Now()
+		lg.Info("creating index %s", ix.name)
+		if _, err := pool.Exec(ctx, create); err != nil {
+			return fmt.Errorf("create index %s: %w", ix.name, err)
+		}
+		lg.Info("created index %s in %s", ix.name, time.Since(start).Round(time.Millisecond))
+	}
+	return nil
+}
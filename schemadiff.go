@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//--- Schema diff (-schema-diff) ---//
+
+// The schema this binary expects, as applied by `make migrate`.
+//
+//go:embed db/schema.sql
+var expectedSchemaSQL string
+
+const selectSchemaColumnsSQL = `
+SELECT table_name, column_name FROM information_schema.columns
+WHERE table_schema = current_schema();
+`
+
+const selectSchemaIndexesSQL = `
+SELECT indexname FROM pg_indexes WHERE schemaname = current_schema();
+`
+
+var (
+	// CREATE TABLE bodies up to the closing paren on a line of its own.
+	schemaTableRe  = regexp.MustCompile(`(?ms)^CREATE TABLE IF NOT EXISTS (\w+) \((.*?)^\);`)
+	schemaColumnRe = regexp.MustCompile(`(?m)^ALTER TABLE (\w+) ADD COLUMN IF NOT EXISTS (\w+) ([^;]+);`)
+	schemaIndexRe  = regexp.MustCompile(`(?ms)^CREATE (?:UNIQUE )?INDEX IF NOT EXISTS (\w+)\s+ON [^;]*;`)
+	sqlCommentRe   = regexp.MustCompile(`--[^\n]*`)
+)
+
+type expectedColumn struct {
+	table, name, def string
+}
+
+type expectedTable struct {
+	name    string
+	create  string // the whole CREATE TABLE statement
+	columns []expectedColumn
+}
+
+type expectedIndex struct {
+	name, create string
+}
+
+// Extracts the tables, columns and indexes db/schema.sql creates. Views,
+// policies and TimescaleDB objects are not compared.
+func parseExpectedSchema(schema string) ([]expectedTable, []expectedIndex) {
+	var tables []expectedTable
+	byName := map[string]int{}
+	for _, m := range schemaTableRe.FindAllStringSubmatch(schema, -1) {
+		t := expectedTable{name: m[1], create: m[0]}
+		for _, def := range splitTopLevel(sqlCommentRe.ReplaceAllString(m[2], "")) {
+			name, rest, _ := strings.Cut(strings.TrimSpace(def), " ")
+			switch strings.ToUpper(name) {
+			case "", "PRIMARY", "UNIQUE", "CHECK", "FOREIGN", "CONSTRAINT", "EXCLUDE":
+				continue
+			}
+			t.columns = append(t.columns, expectedColumn{t.name, name, strings.TrimSpace(rest)})
+		}
+		byName[t.name] = len(tables)
+		tables = append(tables, t)
+	}
+	for _, m := range schemaColumnRe.FindAllStringSubmatch(schema, -1) {
+		if i, ok := byName[m[1]]; ok {
+			tables[i].columns = append(tables[i].columns, expectedColumn{m[1], m[2], strings.TrimSpace(m[3])})
+		}
+	}
+
+	var indexes []expectedIndex
+	for _, m := range schemaIndexRe.FindAllStringSubmatch(schema, -1) {
+		indexes = append(indexes, expectedIndex{m[1], m[0]})
+	}
+	return tables, indexes
+}
+
+// Splits s at commas outside parentheses.
+func splitTopLevel(s string) []string {
+	var out []string
+	depth, start := 0, 0
+	for i, r := range s {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				out = append(out, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(out, s[start:])
+}
+
+// Prints the DDL that would bring the connected database up to the embedded
+// schema: CREATE TABLE for missing tables, ALTER TABLE ADD COLUMN for
+// missing columns and CREATE INDEX for missing indexes. Nothing is executed.
+func printSchemaDiff(ctx context.Context, w io.Writer, pool *pgxpool.Pool) error {
+	have := map[string]map[string]bool{}
+	rows, err := pool.Query(ctx, selectSchemaColumnsSQL)
+	if err != nil {
+		return fmt.Errorf("query columns: %w", err)
+	}
+	var table, column string
+	_, err = pgx.ForEachRow(rows, []any{&table, &column}, func() error {
+		if have[table] == nil {
+			have[table] = map[string]bool{}
+		}
+		have[table][column] = true
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("query columns: %w", err)
+	}
+
+	haveIndexes := map[string]bool{}
+	rows, err = pool.Query(ctx, selectSchemaIndexesSQL)
+	if err != nil {
+		return fmt.Errorf("query indexes: %w", err)
+	}
+	var index string
+	_, err = pgx.ForEachRow(rows, []any{&index}, func() error {
+		haveIndexes[index] = true
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("query indexes: %w", err)
+	}
+
+	tables, indexes := parseExpectedSchema(expectedSchemaSQL)
+	changes := 0
+	for _, t := range tables {
+		cols, ok := have[t.name]
+		if !ok {
+			fmt.Fprintf(w, "%s\n\n", t.create)
+			changes++
+			continue
+		}
+		for _, c := range t.columns {
+			// Quoted names (e.g. "offset") are stored unquoted.
+			if !cols[strings.Trim(c.name, `"`)] {
+				fmt.Fprintf(w, "ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s;\n", t.name, c.name, c.def)
+				changes++
+			}
+		}
+	}
+	for _, ix := range indexes {
+		if haveIndexes[ix.name] {
+			continue
+		}
+		if slices.ContainsFunc(measurementIndexes, func(m struct{ name, columns string }) bool { return m.name == ix.name }) {
+			fmt.Fprintln(w, "-- blocks writes while it builds; -migrate-indexes builds it without that")
+		}
+		fmt.Fprintln(w, ix.create)
+		changes++
+	}
+	if changes == 0 {
+		fmt.Fprintln(w, "-- schema is up to date")
+	}
+	return nil
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//--- Semtech UDP packet forwarder (MODE=udp) ---//
+
+// Semtech UDP protocol packet identifiers.
+const (
+	semtechPushData = 0x00
+	semtechPushAck  = 0x01
+	semtechPullData = 0x02
+	semtechPullAck  = 0x04
+)
+
+// Session keys of every device sharing a DevAddr, with the last accepted
+// frame counter to recover the upper 16 bits of FCnt.
+const selectDeviceKeysSQL = `
+SELECT k.station_eui, k.nwk_s_key, k.app_s_key, coalesce(k.application_id, ''), f.last_fcnt
+FROM device_keys k
+LEFT JOIN device_frame_state f ON f.station_eui = k.station_eui
+WHERE upper(k.dev_addr) = $1;
+`
+
+// One received packet of a PUSH_DATA rxpk array. Only the fields the
+// ingestor stores are decoded.
+type semtechRxPacket struct {
+	Time *time.Time `json:"time"`
+	Freq float64    `json:"freq"` // MHz
+	Stat int        `json:"stat"` // CRC status: 1 OK, -1 failed, 0 no CRC
+	RSSI int        `json:"rssi"`
+	LSNR *float64   `json:"lsnr"`
+	Data string     `json:"data"` // base64 PHYPayload
+}
+
+// UDPListener receives uplinks straight from gateways running the Semtech
+// UDP packet forwarder, for deployments without a network server. Only
+// LoRaWAN 1.0 data uplinks of ABP devices in device_keys are ingested:
+// the MIC is checked with the device's NwkSKey and FRMPayload decrypted
+// with its AppSKey, then decoded by the RAW_DECODERS decoder of its FPort.
+//
+// The full FCnt of every ingested frame is kept in device_frame_state, as
+// verifyMIC needs it once the 16 bit counter on air wraps. With
+// FCNT_REPLAY_CHECK the replay guard in the sink writes it; otherwise, with
+// saveFCnt set, the listener does.
+type UDPListener struct {
+	log      Logger
+	pool     *pgxpool.Pool
+	sink     Sink
+	saveFCnt bool
+}
+
+func NewUDPListener(lg Logger, pool *pgxpool.Pool, sink Sink, saveFCnt bool) *UDPListener {
+	return &UDPListener{log: lg, pool: pool, sink: sink, saveFCnt: saveFCnt}
+}
+
+// Listens on addr until ctx is cancelled.
+func (l *UDPListener) Run(ctx context.Context, addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+
+	l.log.Info("udp packet forwarder listener on %s", addr)
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		l.handlePacket(ctx, conn, from, buf[:n])
+	}
+}
+
+// Packets are [version][token (2)][identifier][gateway EUI (8)][JSON]. PUSH_DATA
+// and PULL_DATA are acknowledged with the same version and token; downlinks
+// (PULL_RESP) are never sent.
+func (l *UDPListener) handlePacket(ctx context.Context, conn net.PacketConn, from net.Addr, b []byte) {
+	if len(b) < 12 {
+		l.log.Debug("udp: short packet (%d bytes) from %s", len(b), from)
+		return
+	}
+	version, token, id := b[0], b[1:3], b[3]
+	gatewayEUI := strings.ToUpper(hex.EncodeToString(b[4:12]))
+
+	switch id {
+	case semtechPullData:
+		_, _ = conn.WriteTo([]byte{version, token[0], token[1], semtechPullAck}, from)
+		return
+	case semtechPushData:
+		_, _ = conn.WriteTo([]byte{version, token[0], token[1], semtechPushAck}, from)
+	default:
+		return
+	}
+
+	var push struct {
+		RxPk []semtechRxPacket `json:"rxpk"`
+	}
+	if err := json.Unmarshal(b[12:], &push); err != nil {
+		l.log.Warn("udp: invalid PUSH_DATA JSON from gateway %s: %v", gatewayEUI, err)
+		return
+	}
+	for _, rx := range push.RxPk {
+		l.handleRxPacket(ctx, gatewayEUI, rx)
+	}
+}
+
+func (l *UDPListener) handleRxPacket(ctx context.Context, gatewayEUI string, rx semtechRxPacket) {
+	start := time.Now()
+	if rx.Stat != 1 {
+		return
+	}
+	stats.Messages.Add(1)
+
+	p, err := l.parse(ctx, gatewayEUI, rx)
+	if err != nil {
+		stats.ParseErrors.Add(1)
+		l.log.Warn("udp: %v (gateway %s)", err, gatewayEUI)
+		notifyError("parse", "", err)
+		return
+	}
+	if p == nil {
+		return
+	}
+	raw, _ := json.Marshal(rx)
+	if err := ingestParsed(ctx, l.log, l.sink, p, raw, "", start); err != nil {
+		parkUplink(ctx, l.log, err)
+		return
+	}
+	if !l.saveFCnt {
+		return
+	}
+	if _, err := l.pool.Exec(ctx, upsertLastFCntSQL, p.StationEUI, int64(p.Msg.FCnt), ""); err != nil {
+		stats.DBErrors.Add(1)
+		l.log.Error("udp: frame state update error: %v (eui: %s)", err, p.StationEUI)
+	}
+}
+
+// Returns nil without an error for frames that aren't data uplinks with an
+// application payload (joins, MAC-only frames).
+func (l *UDPListener) parse(ctx context.Context, gatewayEUI string, rx semtechRxPacket) (*Parsed, error) {
+	phy, err := base64.StdEncoding.DecodeString(rx.Data)
+	if err != nil {
+		return nil, &ParseError{Reason: "rxpk data is not base64", Value: rx.Data}
+	}
+	f, err := parseDataUplink(phy)
+	if err != nil || f == nil {
+		return nil, err
+	}
+	if f.fport == 0 {
+		return nil, nil
+	}
+
+	rows, err := l.pool.Query(ctx, selectDeviceKeysSQL, f.devAddrHex())
+	if err != nil {
+		stats.DBErrors.Add(1)
+		return nil, fmt.Errorf("device key lookup: %w", err)
+	}
+	var (
+		eui, nwkHex, appHex, appID string
+		lastFCnt                   *int64
+		match                      *deviceSession
+	)
+	_, err = pgx.ForEachRow(rows, []any{&eui, &nwkHex, &appHex, &appID, &lastFCnt}, func() error {
+		if match != nil {
+			return nil
+		}
+		s, err := newDeviceSession(eui, nwkHex, appHex, appID)
+		if err != nil {
+			l.log.Warn("udp: device_keys of %s: %v", eui, err)
+			return nil
+		}
+		if fcnt, ok := s.verifyMIC(f, lastFCnt); ok {
+			s.fcnt = fcnt
+			match = s
+		}
+		return nil
+	})
+	if err != nil {
+		stats.DBErrors.Add(1)
+		return nil, fmt.Errorf("device key lookup: %w", err)
+	}
+	if match == nil {
+		return nil, &ParseError{Reason: "no device_keys entry with a matching MIC for DevAddr", Value: f.devAddrHex()}
+	}
+
+	when := time.Now().UTC()
+	if rx.Time != nil {
+		when = rx.Time.UTC()
+	}
+	rm := RxMetadata{RSSI: &rx.RSSI, SNR: rx.LSNR, Time: rx.Time}
+	rm.GatewayIDs.GatewayID = "eui-" + strings.ToLower(gatewayEUI)
+	rm.GatewayIDs.EUI = gatewayEUI
+	msg := UplinkMsg{
+		FPort:      int(f.fport),
+		FCnt:       match.fcnt,
+		FrmPayload: base64.StdEncoding.EncodeToString(match.decrypt(f)),
+		ReceivedAt: when,
+		RxMetadata: []RxMetadata{rm},
+	}
+	msg.Settings.Frequency = fmt.Sprintf("%.0f", rx.Freq*1e6)
+
+	applyRawDecoder(l.log, &msg, match.eui)
+	dropInvalidReadings(l.log, &msg, match.eui)
+	l.log.Debug("parsed udp uplink for DevEUI: %s (DevAddr %s, fcnt %d)", match.eui, f.devAddrHex(), match.fcnt)
+	return &Parsed{
+		When:       when,
+		StationEUI: match.eui,
+		AppID:      match.appID,
+		Msg:        msg,
+	}, nil
+}
+
+//--- LoRaWAN 1.0 frames ---//
+
+// A data uplink PHYPayload: MHDR | DevAddr | FCtrl | FCnt | FOpts | FPort |
+// FRMPayload | MIC, multi-byte fields little endian.
+type dataUplink struct {
+	phy     []byte // whole PHYPayload, MIC included
+	devAddr uint32
+	fcnt16  uint16
+	fport   byte
+	payload []byte // encrypted FRMPayload
+}
+
+func (f *dataUplink) devAddrHex() string {
+	return fmt.Sprintf("%08X", f.devAddr)
+}
+
+// Returns nil without an error for anything but an unconfirmed or confirmed
+// data uplink, and for frames without FPort.
+func parseDataUplink(phy []byte) (*dataUplink, error) {
+	if len(phy) < 12 {
+		return nil, &ParseError{Reason: "PHYPayload too short", Value: hex.EncodeToString(phy)}
+	}
+	switch phy[0] >> 5 {
+	case 0b010, 0b100: // unconfirmed, confirmed data up
+	default:
+		return nil, nil
+	}
+	f := &dataUplink{
+		phy:     phy,
+		devAddr: binary.LittleEndian.Uint32(phy[1:5]),
+		fcnt16:  binary.LittleEndian.Uint16(phy[6:8]),
+	}
+	i := 8 + int(phy[5]&0x0f) // FOpts
+	macEnd := len(phy) - 4
+	if i > macEnd {
+		return nil, &ParseError{Reason: "FOpts longer than frame", Value: hex.EncodeToString(phy)}
+	}
+	if i == macEnd {
+		return nil, nil
+	}
+	f.fport = phy[i]
+	f.payload = phy[i+1 : macEnd]
+	return f, nil
+}
+
+type deviceSession struct {
+	eui, appID string
+	nwkSKey    cipher.Block
+	appSKey    cipher.Block
+	fcnt       uint32
+}
+
+func newDeviceSession(eui, nwkHex, appHex, appID string) (*deviceSession, error) {
+	nwk, err := aesKeyFromHex(nwkHex)
+	if err != nil {
+		return nil, fmt.Errorf("nwk_s_key: %w", err)
+	}
+	app, err := aesKeyFromHex(appHex)
+	if err != nil {
+		return nil, fmt.Errorf("app_s_key: %w", err)
+	}
+	return &deviceSession{eui: strings.ToUpper(eui), appID: appID, nwkSKey: nwk, appSKey: app}, nil
+}
+
+func aesKeyFromHex(s string) (cipher.Block, error) {
+	k, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(k) != 16 {
+		return nil, errors.New("not a 128 bit key")
+	}
+	return aes.NewCipher(k)
+}
+
+// Checks the MIC and returns the full 32 bit FCnt it was computed over. The
+// upper 16 bits are taken from the last accepted FCnt (rolling over when
+// the 16 bit counter wrapped); a counter restarting from 0 is tried too.
+func (s *deviceSession) verifyMIC(f *dataUplink, lastFCnt *int64) (uint32, bool) {
+	candidates := []uint32{uint32(f.fcnt16)}
+	if lastFCnt != nil {
+		last := uint32(*lastFCnt)
+		fcnt := last&0xffff0000 | uint32(f.fcnt16)
+		if fcnt < last {
+			fcnt += 0x10000
+		}
+		candidates = append([]uint32{fcnt}, candidates...)
+	}
+	msg := f.phy[:len(f.phy)-4]
+	for _, fcnt := range candidates {
+		b0 := loraBlock(0x49, f.devAddr, fcnt, byte(len(msg)))
+		mac := aesCMAC(s.nwkSKey, append(b0[:], msg...))
+		if subtle.ConstantTimeCompare(mac[:4], f.phy[len(f.phy)-4:]) == 1 {
+			return fcnt, true
+		}
+	}
+	return 0, false
+}
+
+// Decrypts FRMPayload with the AppSKey (AES-CTR style keystream of A_i
+// blocks, LoRaWAN 1.0 section 4.3.3).
+func (s *deviceSession) decrypt(f *dataUplink) []byte {
+	out := make([]byte, len(f.payload))
+	var ks [16]byte
+	for i := 0; i < len(out); i += 16 {
+		a := loraBlock(0x01, f.devAddr, s.fcnt, byte(i/16+1))
+		s.appSKey.Encrypt(ks[:], a[:])
+		for j := i; j < len(out) && j < i+16; j++ {
+			out[j] = f.payload[j] ^ ks[j-i]
+		}
+	}
+	return out
+}
+
+// The B0 / A_i block of an uplink: first | 4 x 0x00 | Dir (0 = up) |
+// DevAddr | FCnt | 0x00 | last.
+func loraBlock(first byte, devAddr, fcnt uint32, last byte) [16]byte {
+	var b [16]byte
+	b[0] = first
+	binary.LittleEndian.PutUint32(b[6:10], devAddr)
+	binary.LittleEndian.PutUint32(b[10:14], fcnt)
+	b[15] = last
+	return b
+}
+
+// AES-CMAC (RFC 4493).
+func aesCMAC(c cipher.Block, msg []byte) [16]byte {
+	var l, k1, k2 [16]byte
+	c.Encrypt(l[:], l[:])
+	cmacSubkey(&k1, &l)
+	cmacSubkey(&k2, &k1)
+
+	n := (len(msg) + 15) / 16
+	complete := n > 0 && len(msg)%16 == 0
+	if n == 0 {
+		n = 1
+	}
+	var last [16]byte
+	rest := msg[(n-1)*16:]
+	if complete {
+		for i := range last {
+			last[i] = rest[i] ^ k1[i]
+		}
+	} else {
+		copy(last[:], rest)
+		last[len(rest)] = 0x80
+		for i := range last {
+			last[i] ^= k2[i]
+		}
+	}
+
+	var x [16]byte
+	for i := 0; i < n-1; i++ {
+		for j := range x {
+			x[j] ^= msg[i*16+j]
+		}
+		c.Encrypt(x[:], x[:])
+	}
+	for j := range x {
+		x[j] ^= last[j]
+	}
+	c.Encrypt(x[:], x[:])
+	return x
+}
+
+// dst = src << 1, xor 0x87 when the top bit was set.
+func cmacSubkey(dst, src *[16]byte) {
+	msb := src[0] >> 7
+	for i := 0; i < 15; i++ {
+		dst[i] = src[i]<<1 | src[i+1]>>7
+	}
+	dst[15] = src[15]<<1 ^ 0x87*msb
+}
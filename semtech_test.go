@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/aes"
+	"encoding/hex"
+	"testing"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// RFC 4493 section 4.
+func TestAESCMAC(t *testing.T) {
+	c, err := aes.NewCipher(mustHex(t, "2b7e151628aed2a6abf7158809cf4f3c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := "6bc1bee22e409f96e93d7e117393172a" +
+		"ae2d8a571e03ac9c9eb76fac45af8e51" +
+		"30c81c46a35ce411e5fbc1191a0a52ef" +
+		"f69f2445df4f9b17ad2b417be66c3710"
+	tests := []struct {
+		len  int
+		want string
+	}{
+		{0, "bb1d6929e95937287fa37d129b756746"},
+		{16, "070a16b46b4d4144f79bdd9dd04a287c"},
+		{40, "dfa66747de9ae63030ca32611497c827"},
+		{64, "51f0bebf7e3b9d92fc49741779363cfe"},
+	}
+	for _, tt := range tests {
+		mac := aesCMAC(c, mustHex(t, msg)[:tt.len])
+		if got := hex.EncodeToString(mac[:]); got != tt.want {
+			t.Errorf("AES-CMAC of %d bytes = %s, want %s", tt.len, got, tt.want)
+		}
+	}
+}
+
+func TestDataUplinkMICAndDecrypt(t *testing.T) {
+	// Unconfirmed data up, DevAddr 49BE7DF1, FCnt 2, FPort 1, payload "test".
+	f, err := parseDataUplink(mustHex(t, "40F17DBE4900020001954378762B11FF0D"))
+	if err != nil || f == nil {
+		t.Fatalf("parseDataUplink = %v, %v", f, err)
+	}
+	if f.devAddrHex() != "49BE7DF1" || f.fcnt16 != 2 || f.fport != 1 {
+		t.Fatalf("DevAddr %s, FCnt %d, FPort %d", f.devAddrHex(), f.fcnt16, f.fport)
+	}
+
+	s, err := newDeviceSession("0004A30B001C0530", "44024241ed4ce9a68c6a8bc055233fd3", "ec925802ae430ca77fd3dd73cb2cc588", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fcnt, ok := s.verifyMIC(f, nil)
+	if !ok || fcnt != 2 {
+		t.Fatalf("verifyMIC = %d, %v; want 2, true", fcnt, ok)
+	}
+	s.fcnt = fcnt
+	if got := string(s.decrypt(f)); got != "test" {
+		t.Fatalf("decrypt = %q, want %q", got, "test")
+	}
+
+	// With a last FCnt of 0x1ffff the frame is first tried as FCnt 0x20002;
+	// that MIC doesn't match, but a counter restarted from 0 is still found.
+	last := int64(0x1ffff)
+	if fcnt, ok := s.verifyMIC(f, &last); !ok || fcnt != 2 {
+		t.Fatalf("verifyMIC after a counter restart = %d, %v; want 2, true", fcnt, ok)
+	}
+	f.phy[len(f.phy)-1] ^= 1
+	if _, ok := s.verifyMIC(f, nil); ok {
+		t.Fatal("verifyMIC accepted a corrupted MIC")
+	}
+}
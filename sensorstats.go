@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//--- Per sensor type statistics ---//
+
+// Scans all of measurements, so expect it to take a while on a large table.
+const selectSensorTypeStatsSQL = `
+SELECT m.sensor_type, coalesce(st.name, ''), count(*), count(DISTINCT m.station_eui),
+       min(m.value), max(m.value), avg(m.value), coalesce(stddev_samp(m.value), 0), max(m.time)
+FROM measurements m
+LEFT JOIN sensor_types st ON st.type_id = m.sensor_type
+GROUP BY m.sensor_type, st.name
+ORDER BY m.sensor_type;
+`
+
+// Prints count, station count, value range, mean, stddev and newest reading
+// of every sensor type in measurements as a table.
+func printSensorTypeStats(ctx context.Context, w io.Writer, pool *pgxpool.Pool) error {
+	rows, err := pool.Query(ctx, selectSensorTypeStatsSQL)
+	if err != nil {
+		return fmt.Errorf("query sensor type stats: %w", err)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TYPE\tNAME\tCOUNT\tSTATIONS\tMIN\tMAX\tMEAN\tSTDDEV\tLATEST")
+	var (
+		sensorType           int16
+		name                 string
+		count, stations      int64
+		lo, hi, mean, stddev float64
+		latest               time.Time
+	)
+	_, err = pgx.ForEachRow(rows, []any{&sensorType, &name, &count, &stations, &lo, &hi, &mean, &stddev, &latest}, func() error {
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%d\t%.2f\t%.2f\t%.2f\t%.2f\t%s\n",
+			sensorType, name, count, stations, lo, hi, mean, stddev, latest.UTC().Format(time.RFC3339))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("query sensor type stats: %w", err)
+	}
+	return tw.Flush()
+}
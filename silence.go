@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//--- Silent station monitor ---//
+
+const selectSilentStationsSQL = `
+SELECT station_eui, last_uplink_at
+FROM stations
+WHERE last_uplink_at < now() - $1::interval
+ORDER BY last_uplink_at;
+`
+
+// Every interval, logs a warning for each station that hasn't sent an uplink
+// for longer than threshold.
+func runSilenceMonitor(ctx context.Context, lg Logger, pool *pgxpool.Pool, interval, threshold time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			checkSilentStations(ctx, lg, pool, threshold)
+		}
+	}
+}
+
+func checkSilentStations(ctx context.Context, lg Logger, pool *pgxpool.Pool, threshold time.Duration) {
+	rows, err := pool.Query(ctx, selectSilentStationsSQL, threshold)
+	if err != nil {
+		lg.Error("silent station query error: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var eui string
+		var last time.Time
+		if err := rows.Scan(&eui, &last); err != nil {
+			lg.Error("silent station scan error: %v", err)
+			return
+		}
+		lg.Warn("station silent: station_eui=%s last_uplink_at=%s silent_for=%s",
+			eui, last.UTC().Format(time.RFC3339), time.Since(last).Round(time.Minute))
+	}
+	if err := rows.Err(); err != nil {
+		lg.Error("silent station query error: %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+//--- Synthetic uplinks ---//
+
+// Number of distinct fake stations the simulator spreads messages across.
+const simulatedStations = 10
+
+type simStation struct {
+	eui  string
+	fcnt uint32
+}
+
+// Feeds n synthetic TTN uplinks through ingestUplink at rate messages per
+// second (0 = as fast as possible), for load testing the write path.
+func runSimulation(ctx context.Context, lg Logger, sink Sink, n int, rate float64) {
+	stations := make([]*simStation, simulatedStations)
+	for i := range stations {
+		stations[i] = &simStation{eui: fmt.Sprintf("%016X", rand.Uint64())}
+	}
+
+	var tick <-chan time.Time
+	if rate > 0 {
+		t := time.NewTicker(time.Duration(float64(time.Second) / rate))
+		defer t.Stop()
+		tick = t.C
+	}
+
+	lg.Info("simulating %d uplinks from %d stations at %v msg/s", n, len(stations), rate)
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		if tick != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick:
+			}
+		} else if ctx.Err() != nil {
+			return
+		}
+
+		st := stations[rand.IntN(len(stations))]
+		st.fcnt++
+		b, err := syntheticUplink(st)
+		if err != nil {
+			lg.Error("simulate: %v", err)
+			return
+		}
+		_ = ingestUplink(ctx, lg, sink, b, "", time.Now())
+	}
+	lg.Info("simulation done: %d uplinks in %s", n, time.Since(start).Round(time.Millisecond))
+}
+
+// Builds a direct /up payload with a plausible reading for the first few
+// sensor types on two slaves.
+func syntheticUplink(st *simStation) ([]byte, error) {
+	type sensor struct {
+		Format int     `json:"format"`
+		Index  int     `json:"index"`
+		Type   int     `json:"type"`
+		Value  float64 `json:"value"`
+	}
+	type slave struct {
+		ID      int      `json:"id"`
+		Sensors []sensor `json:"sensors"`
+	}
+
+	slaves := make([]slave, 2)
+	for i := range slaves {
+		slaves[i] = slave{ID: i + 1, Sensors: []sensor{
+			{Format: 4, Type: 1, Value: 10 + rand.Float64()*25},       // air temperature, °C
+			{Format: 5, Type: 2, Value: 20 + rand.Float64()*80},       // humidity, %RH
+			{Format: 2, Type: 3, Value: 95000 + rand.Float64()*10000}, // pressure, Pa
+			{Format: 5, Type: 4, Value: rand.Float64() * 20},          // wind speed, m/s
+			{Format: 1, Type: 5, Value: float64(rand.IntN(360))},      // wind direction, deg
+		}}
+	}
+
+	now := time.Now().UTC()
+	rssi := -120 + rand.IntN(90)
+	snr := -10 + rand.Float64()*20
+	up := map[string]any{
+		"end_device_ids": map[string]any{
+			"device_id":       "sim-" + st.eui[12:],
+			"dev_eui":         st.eui,
+			"application_ids": map[string]any{"application_id": "simulated"},
+		},
+		"received_at": now,
+		"simulated":   true,
+		"uplink_message": map[string]any{
+			"f_port":          1,
+			"f_cnt":           st.fcnt,
+			"received_at":     now,
+			"decoded_payload": map[string]any{"slaves": slaves},
+			"rx_metadata": []map[string]any{{
+				"gateway_ids": map[string]any{"gateway_id": "sim-gateway"},
+				"rssi":        rssi,
+				"snr":         snr,
+			}},
+		},
+	}
+	return json.Marshal(up)
+}
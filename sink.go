@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+//--- Sinks ---//
+
+// Sink receives every parsed uplink. Implementations log their own failures
+// and return an error so callers can tell a write went wrong.
+type Sink interface {
+	InsertMeasurements(ctx context.Context, p *Parsed) error
+}
+
+// FanOutSink writes each uplink to all of its sinks concurrently.
+type FanOutSink struct {
+	sinks []Sink
+}
+
+func NewFanOutSink(sinks ...Sink) *FanOutSink {
+	return &FanOutSink{sinks: sinks}
+}
+
+// Returns the errors of all failing sinks joined together.
+func (f *FanOutSink) InsertMeasurements(ctx context.Context, p *Parsed) error {
+	errs := make([]error, len(f.sinks))
+	var wg sync.WaitGroup
+	for i, s := range f.sinks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = s.InsertMeasurements(ctx, p)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// Closes the sinks that hold resources (e.g. the Kafka writer).
+func (f *FanOutSink) Close() error {
+	var errs []error
+	for _, s := range f.sinks {
+		if c, ok := s.(io.Closer); ok {
+			errs = append(errs, c.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func closeSink(s Sink) {
+	if c, ok := s.(io.Closer); ok {
+		_ = c.Close()
+	}
+}
+
+// Builds the sink for a SINK_FANOUT list such as "postgres,kafka". newSink
+// constructs a single named sink.
+func buildSink(list string, newSink func(name string) (Sink, error)) (Sink, error) {
+	var sinks []Sink
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		s, err := newSink(name)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	switch len(sinks) {
+	case 0:
+		return nil, fmt.Errorf("no sinks configured in %q", list)
+	case 1:
+		return sinks[0], nil
+	default:
+		return NewFanOutSink(sinks...), nil
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//--- EMA smoothing ---//
+
+// When set, values of the configured sensor types are replaced by their
+// exponential moving average before insert; the reading is kept in raw_value.
+var smoother *emaSmoother
+
+type emaKey struct {
+	eui         string
+	slave       int
+	sensorType  int
+	sensorIndex int
+}
+
+type emaSmoother struct {
+	alpha float64
+	types map[int]bool
+
+	mu  sync.Mutex
+	ema map[emaKey]float64
+}
+
+// Builds a smoother for the comma separated sensor type IDs in list. Returns
+// nil when list is empty.
+func newEMASmoother(list string, alpha float64) (*emaSmoother, error) {
+	if alpha <= 0 || alpha > 1 {
+		return nil, fmt.Errorf("alpha %v out of range (0, 1]", alpha)
+	}
+	types := map[int]bool{}
+	for _, f := range strings.Split(list, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		t, err := strconv.Atoi(f)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sensor type %q", f)
+		}
+		types[t] = true
+	}
+	if len(types) == 0 {
+		return nil, nil
+	}
+	return &emaSmoother{alpha: alpha, types: types, ema: map[emaKey]float64{}}, nil
+}
+
+// Returns the smoothed value for the reading and whether its type is
+// smoothed at all. The first reading of a series seeds the average.
+func (s *emaSmoother) smooth(eui string, slave, sensorType, sensorIndex int, v float64) (float64, bool) {
+	if !s.types[sensorType] {
+		return v, false
+	}
+	k := emaKey{eui, slave, sensorType, sensorIndex}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev, ok := s.ema[k]
+	if ok {
+		v = s.alpha*v + (1-s.alpha)*prev
+	}
+	s.ema[k] = v
+	return v, true
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+//--- Ingestion statistics ---//
+
+// Process-wide counters, updated from handleMessage.
+var stats struct {
+	Messages     atomic.Uint64
+	Measurements atomic.Uint64
+	ParseErrors  atomic.Uint64
+	DBErrors     atomic.Uint64
+}
+
+// Prints per-second rates derived from the counters, and the measurement
+// rows waiting in the batcher, to stdout every interval until ctx is
+// cancelled.
+func runStatsPrinter(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	prevMsgs, prevMeas, prevDBErr := stats.Messages.Load(), stats.Measurements.Load(), stats.DBErrors.Load()
+	prevAt := time.Now()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			msgs, meas, dbErr := stats.Messages.Load(), stats.Measurements.Load(), stats.DBErrors.Load()
+			secs := now.Sub(prevAt).Seconds()
+			fmt.Printf("%s stats: %.2f msg/s, %.2f measurements/s, %.2f db errors/s, queue depth %d (totals: %d msgs, %d measurements, %d parse errors, %d db errors)\n",
+				now.UTC().Format(time.RFC3339),
+				float64(msgs-prevMsgs)/secs, float64(meas-prevMeas)/secs, float64(dbErr-prevDBErr)/secs, batcher.pending(),
+				msgs, meas, stats.ParseErrors.Load(), dbErr)
+			prevMsgs, prevMeas, prevDBErr, prevAt = msgs, meas, dbErr, now
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"strings"
+	"time"
+)
+
+//--- Actility ThingPark uplinks ---//
+
+// Set from NETWORK_SERVER: "ttn" (default) or "thingpark".
+var networkServer = "ttn"
+
+// ThingPark wraps every uplink in a DevEUI_uplink object. Only the fields
+// the ingestor stores are decoded.
+type thingParkUplink struct {
+	DevEUIUplink *struct {
+		Time       time.Time `json:"Time"`
+		DevEUI     string    `json:"DevEUI"`
+		FPort      int       `json:"FPort"`
+		FCntUp     uint32    `json:"FCntUp"`
+		PayloadHex string    `json:"payload_hex"`
+		CustomerID string    `json:"CustomerID"`
+		// Best receiving base station (LRR) and its location.
+		Lrrid  string   `json:"Lrrid"`
+		LrrLAT *float64 `json:"LrrLAT"`
+		LrrLON *float64 `json:"LrrLON"`
+		Lrrs   struct {
+			Lrr []struct {
+				Lrrid   string   `json:"Lrrid"`
+				LrrRSSI *float64 `json:"LrrRSSI"`
+				LrrSNR  *float64 `json:"LrrSNR"`
+			} `json:"Lrr"`
+		} `json:"Lrrs"`
+	} `json:"DevEUI_uplink"`
+}
+
+// Parses a ThingPark DevEUI_uplink into the same Parsed shape as a TTN
+// uplink. payload_hex is decoded by the RAW_DECODERS decoder registered for
+// its FPort; base stations become rx_metadata with the best one first, and
+// CustomerID stands in for the application ID.
+func parseThingParkUplink(lg Logger, b []byte) (*Parsed, error) {
+	var tp thingParkUplink
+	if err := json.Unmarshal(b, &tp); err != nil || tp.DevEUIUplink == nil || tp.DevEUIUplink.DevEUI == "" {
+		return nil, &ParseError{Reason: "unknown ThingPark uplink shape (expecting DevEUI_uplink)"}
+	}
+	up := tp.DevEUIUplink
+	eui := strings.ToUpper(up.DevEUI)
+	if !validateEUI64(eui) {
+		return nil, &ParseError{Reason: "invalid DevEUI", Value: up.DevEUI}
+	}
+	payload, err := hex.DecodeString(up.PayloadHex)
+	if err != nil {
+		return nil, &ParseError{Reason: "invalid payload_hex", Value: up.PayloadHex}
+	}
+
+	when := up.Time
+	if when.IsZero() {
+		when = time.Now()
+	}
+	msg := UplinkMsg{
+		FPort:      up.FPort,
+		FCnt:       up.FCntUp,
+		FrmPayload: base64.StdEncoding.EncodeToString(payload),
+		ReceivedAt: when,
+	}
+	for _, lrr := range up.Lrrs.Lrr {
+		var rm RxMetadata
+		rm.GatewayIDs.GatewayID = lrr.Lrrid
+		rm.SNR = lrr.LrrSNR
+		if lrr.LrrRSSI != nil {
+			rssi := int(math.Round(*lrr.LrrRSSI))
+			rm.RSSI = &rssi
+		}
+		if lrr.Lrrid == up.Lrrid {
+			if up.LrrLAT != nil && up.LrrLON != nil {
+				rm.Location = &struct {
+					Latitude  float64 `json:"latitude"`
+					Longitude float64 `json:"longitude"`
+				}{*up.LrrLAT, *up.LrrLON}
+			}
+			msg.RxMetadata = append([]RxMetadata{rm}, msg.RxMetadata...)
+			continue
+		}
+		msg.RxMetadata = append(msg.RxMetadata, rm)
+	}
+
+	applyRawDecoder(lg, &msg, eui)
+	dropInvalidReadings(lg, &msg, eui)
+	lg.Debug("parsed ThingPark uplink for DevEUI: %s", eui)
+	return &Parsed{
+		When:       when.UTC(),
+		StationEUI: eui,
+		AppID:      up.CustomerID,
+		Msg:        msg,
+	}, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseThingParkUplink(t *testing.T) {
+	RegisterRawDecoder(10, decodeWeatherBus)
+	t.Cleanup(func() { delete(rawDecoders, 10) })
+
+	// Slave 1 with one reading: type 1, format 0 (u8), index 0, value 21.
+	const payload = "000101010015"
+	tests := []struct {
+		name    string
+		in      string
+		wantErr bool
+		check   func(t *testing.T, p *Parsed)
+	}{
+		{
+			name: "full uplink",
+			in: `{"DevEUI_uplink":{"Time":"2025-05-01T12:00:00.000+02:00","DevEUI":"70b3d57ed0000001",
+				"FPort":10,"FCntUp":42,"payload_hex":"` + payload + `","CustomerID":"100000507",
+				"Lrrid":"LRR-B","LrrLAT":48.85,"LrrLON":2.35,
+				"Lrrs":{"Lrr":[{"Lrrid":"LRR-A","LrrRSSI":-112.4,"LrrSNR":-3.5},{"Lrrid":"LRR-B","LrrRSSI":-98.6,"LrrSNR":7.25}]}}}`,
+			check: func(t *testing.T, p *Parsed) {
+				if p.StationEUI != "70B3D57ED0000001" || p.AppID != "100000507" || p.Msg.FCnt != 42 || p.Msg.FPort != 10 {
+					t.Errorf("EUI %s, app %s, fcnt %d, fport %d", p.StationEUI, p.AppID, p.Msg.FCnt, p.Msg.FPort)
+				}
+				if want := time.Date(2025, 5, 1, 10, 0, 0, 0, time.UTC); !p.When.Equal(want) || p.When.Location() != time.UTC {
+					t.Errorf("time %v, want %v", p.When, want)
+				}
+				if p.Msg.FrmPayload != "AAEBAQAV" {
+					t.Errorf("frm_payload %q", p.Msg.FrmPayload)
+				}
+				s := p.Msg.DecodedPayload.Slaves
+				if len(s) != 1 || s[0].ID != 1 || len(s[0].Sensors) != 1 || s[0].Sensors[0].Value != 21 {
+					t.Errorf("decoded %+v", p.Msg.DecodedPayload)
+				}
+				rx := p.Msg.RxMetadata
+				if len(rx) != 2 || rx[0].GatewayIDs.GatewayID != "LRR-B" || rx[1].GatewayIDs.GatewayID != "LRR-A" {
+					t.Fatalf("rx_metadata %+v, want the best LRR first", rx)
+				}
+				if *rx[0].RSSI != -99 || *rx[0].SNR != 7.25 || rx[0].Location == nil || rx[0].Location.Latitude != 48.85 {
+					t.Errorf("best LRR %+v", rx[0])
+				}
+				if *rx[1].RSSI != -112 || rx[1].Location != nil {
+					t.Errorf("other LRR %+v", rx[1])
+				}
+			},
+		},
+		{
+			name: "no decoder for fport",
+			in:   `{"DevEUI_uplink":{"DevEUI":"70B3D57ED0000001","FPort":11,"payload_hex":"` + payload + `"}}`,
+			check: func(t *testing.T, p *Parsed) {
+				if len(p.Msg.DecodedPayload.Slaves) != 0 || p.Msg.FrmPayload == "" {
+					t.Errorf("decoded %+v, frm_payload %q", p.Msg.DecodedPayload, p.Msg.FrmPayload)
+				}
+				if p.When.IsZero() {
+					t.Error("time not defaulted")
+				}
+			},
+		},
+		{name: "TTN uplink", in: `{"end_device_ids":{"dev_eui":"70B3D57ED0000001"}}`, wantErr: true},
+		{name: "not JSON", in: `DevEUI_uplink`, wantErr: true},
+		{name: "invalid DevEUI", in: `{"DevEUI_uplink":{"DevEUI":"70B3D57ED00001"}}`, wantErr: true},
+		{name: "invalid payload_hex", in: `{"DevEUI_uplink":{"DevEUI":"70B3D57ED0000001","payload_hex":"0g"}}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := parseThingParkUplink(&TestLogger{}, []byte(tt.in))
+			if tt.wantErr {
+				var pe *ParseError
+				if !errors.As(err, &pe) {
+					t.Fatalf("error %v, want a *ParseError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, p)
+		})
+	}
+}
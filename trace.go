@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+//--- Per-device tracing ---//
+
+// Set from -trace-eui: uplinks of this device are traced at every step
+// regardless of the log level.
+var (
+	traceEUI string
+	traceLog Logger
+)
+
+func tracing(eui string) bool {
+	return traceEUI != "" && strings.EqualFold(eui, traceEUI)
+}
+
+// Logs a trace line for eui if it is the traced device.
+func trace(eui, format string, args ...any) {
+	if tracing(eui) {
+		traceLog.Info("[TRACE %s] "+format, append([]any{eui}, args...)...)
+	}
+}
+
+// Like trace, with v appended as JSON.
+func traceJSON(eui, what string, v any) {
+	if !tracing(eui) {
+		return
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		trace(eui, "%s: <%v>", what, err)
+		return
+	}
+	trace(eui, "%s: %s", what, b)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+//--- External payload transform ---//
+
+// When set (TRANSFORM_ENDPOINT_URL), every parsed uplink is passed through
+// an external HTTP endpoint before it reaches the sinks.
+var payloadTransform *payloadTransformer
+
+// Posts the parsed uplink as JSON to url and replaces its decoded payload
+// with the response, which must be a decoded_payload object
+// ({"slaves": [...]}). Each call is bounded by timeout.
+type payloadTransformer struct {
+	log     Logger
+	url     string
+	timeout time.Duration
+	client  *http.Client
+}
+
+func newPayloadTransformer(lg Logger, url string, timeout time.Duration) *payloadTransformer {
+	return &payloadTransformer{log: lg, url: url, timeout: timeout, client: &http.Client{}}
+}
+
+// Transforms p in place. On any failure p is left untouched and a warning is
+// logged, so a slow or broken endpoint never drops an uplink.
+func (t *payloadTransformer) apply(ctx context.Context, p *Parsed) {
+	raw, dp, err := t.call(ctx, p)
+	if err != nil {
+		t.log.Warn("transform of uplink from %s failed, keeping original payload: %v", p.StationEUI, err)
+		return
+	}
+	p.Msg.RawDecodedPayload = raw
+	p.Msg.DecodedPayload = dp
+	dropInvalidReadings(t.log, &p.Msg, p.StationEUI)
+	trace(p.StationEUI, "transformed decoded payload: %s", raw)
+}
+
+func (t *payloadTransformer) call(ctx context.Context, p *Parsed) (json.RawMessage, DecodedPayload, error) {
+	var dp DecodedPayload
+	q, err := effectiveParsed(p)
+	if err != nil {
+		return nil, dp, err
+	}
+	body, err := json.Marshal(q)
+	if err != nil {
+		return nil, dp, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, dp, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, dp, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return nil, dp, fmt.Errorf("status %s", resp.Status)
+	}
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxWebhookBody))
+	if err != nil {
+		return nil, dp, err
+	}
+	if err := json.Unmarshal(raw, &dp); err != nil {
+		return nil, dp, fmt.Errorf("decode response: %w", err)
+	}
+	return raw, dp, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+//--- TTN cluster detection ---//
+
+// Returns the cluster host (e.g. "au1.cloud.thethings.network") that the
+// application's devices use, based on the network server address of its
+// first device. is is the identity server, which is shared by all public TTN
+// clusters. Requires an API key that can read the application's devices.
+func detectTTNHost(ctx context.Context, is, appID, apiKey string) (string, error) {
+	u := fmt.Sprintf("https://%s/api/v3/applications/%s/devices?field_mask=network_server_address&limit=1",
+		is, url.PathEscape(appID))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("identity server returned %s", resp.Status)
+	}
+
+	var body struct {
+		EndDevices []struct {
+			NetworkServerAddress string `json:"network_server_address"`
+		} `json:"end_devices"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("decode identity server response: %w", err)
+	}
+	if len(body.EndDevices) == 0 || body.EndDevices[0].NetworkServerAddress == "" {
+		return "", fmt.Errorf("application %s has no devices with a network server address", appID)
+	}
+	host, _, _ := strings.Cut(body.EndDevices[0].NetworkServerAddress, ":")
+	return host, nil
+}
+
+// Warns when the configured broker host doesn't match the cluster the TTN
+// application lives on. Only runs when TTN_APP_ID and TTN_API_KEY are set and
+// the host looks like a TTN cloud host; failures are logged at debug level.
+func checkTTNHost(lg Logger, cfg *Config) {
+	configured, appID, apiKey := cfg.MQTTHost, cfg.TTNAppID, cfg.TTNAPIKey
+	if appID == "" || apiKey == "" || !strings.HasSuffix(configured, ".cloud.thethings.network") {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	detected, err := detectTTNHost(ctx, cfg.TTNIdentityServer, appID, apiKey)
+	if err != nil {
+		lg.Debug("ttn cluster detection failed: %v", err)
+		return
+	}
+	if !strings.EqualFold(detected, configured) {
+		lg.Warn("MQTT host %s does not match the cluster of application %s (%s); check TTN_REGION_HOST/MQTT_HOST", configured, appID, detected)
+	}
+}
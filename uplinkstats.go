@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//--- Per device uplink statistics ---//
+
+// Messages are counted as distinct measurement times per station (all
+// readings of an uplink share its time), so uplinks without readings don't
+// count. Like selectSensorTypeStatsSQL this scans all of measurements.
+const selectUplinkStatsSQL = `
+SELECT s.station_eui, coalesce(s.station_devid, ''), s.application_id,
+       coalesce(m.messages, 0), coalesce(m.measurements, 0), s.last_uplink_at, coalesce(g.gateway_id, '')
+FROM stations s
+LEFT JOIN (
+  SELECT station_eui, count(DISTINCT time) AS messages, count(*) AS measurements
+  FROM measurements
+  GROUP BY station_eui
+) m ON m.station_eui = s.station_eui
+LEFT JOIN LATERAL (
+  SELECT gateway_id FROM measurements
+  WHERE station_eui = s.station_eui AND gateway_id IS NOT NULL
+  ORDER BY time DESC
+  LIMIT 1
+) g ON true
+WHERE $1 = '' OR s.application_id = $1
+ORDER BY s.last_uplink_at DESC NULLS LAST, s.station_eui;
+`
+
+// Prints message and measurement counts, last uplink time and last gateway
+// of every station (of application appID, unless empty), most recently
+// heard first.
+func printUplinkStats(ctx context.Context, w io.Writer, pool *pgxpool.Pool, appID string) error {
+	rows, err := pool.Query(ctx, selectUplinkStatsSQL, appID)
+	if err != nil {
+		return fmt.Errorf("query uplink stats: %w", err)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATION EUI\tDEVICE ID\tAPP ID\tMESSAGES\tMEASUREMENTS\tLAST UPLINK\tLAST GATEWAY")
+	var (
+		eui, devID, app        string
+		messages, measurements int64
+		lastUplink             *time.Time
+		gateway                string
+	)
+	_, err = pgx.ForEachRow(rows, []any{&eui, &devID, &app, &messages, &measurements, &lastUplink, &gateway}, func() error {
+		last := "-"
+		if lastUplink != nil {
+			last = lastUplink.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%s\t%s\n", eui, devID, app, messages, measurements, last, gateway)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("query uplink stats: %w", err)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+//--- Payload validation ---//
+
+type validateResult struct {
+	OK            bool            `json:"ok"`
+	Error         string          `json:"error,omitempty"`
+	Value         string          `json:"value,omitempty"`
+	Time          time.Time       `json:"time,omitzero"`
+	StationEUI    string          `json:"station_eui,omitempty"`
+	StationDevID  string          `json:"station_devid,omitempty"`
+	ApplicationID string          `json:"application_id,omitempty"`
+	FPort         int             `json:"f_port,omitempty"`
+	FCnt          uint32          `json:"f_cnt,omitempty"`
+	Slaves        []slaveReadings `json:"slaves,omitempty"`
+	// Sensor types that would be skipped on insert.
+	UnknownTypes []int `json:"unknown_sensor_types,omitempty"`
+}
+
+// Reads one uplink JSON document from r, runs it through parseUplink and
+// writes the result to w as indented JSON. Returns the process exit code:
+// 0 if the payload parsed, 1 otherwise.
+func runValidate(lg Logger, r io.Reader, w io.Writer) int {
+	var res validateResult
+	defer func() {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(res)
+	}()
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		res.Error = fmt.Sprintf("read stdin: %v", err)
+		return 1
+	}
+
+	p, err := parseUplink(lg, b)
+	if err != nil {
+		res.Error = err.Error()
+		var pe *ParseError
+		if errors.As(err, &pe) {
+			res.Error, res.Value = pe.Reason, pe.Value
+		}
+		return 1
+	}
+
+	res = validateResult{
+		OK:            true,
+		Time:          p.When,
+		StationEUI:    p.StationEUI,
+		StationDevID:  p.StationDevID,
+		ApplicationID: p.AppID,
+		FPort:         p.Msg.FPort,
+		FCnt:          p.Msg.FCnt,
+		Slaves:        p.Msg.DecodedPayload.Slaves,
+	}
+	seen := map[int]bool{}
+	for _, s := range p.Msg.DecodedPayload.Slaves {
+		for _, m := range s.Sensors {
+			if _, ok := validSensorTypes[m.Type]; !ok && !seen[m.Type] {
+				seen[m.Type] = true
+				res.UnknownTypes = append(res.UnknownTypes, m.Type)
+			}
+		}
+	}
+	return 0
+}
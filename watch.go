@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+//--- Station watch ---//
+
+// Number of RSSI/SNR samples kept for the sparklines.
+const watchHistory = 30
+
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+type watchKey struct {
+	Slave, Type, Index int
+}
+
+type watchReading struct {
+	Value, Prev float64
+	HasPrev     bool
+	At          time.Time
+}
+
+type stationWatch struct {
+	mu       sync.Mutex
+	eui      string
+	devID    string
+	lastSeen time.Time
+	gateway  string
+	frames   int
+	rssi     []float64
+	snr      []float64
+	readings map[watchKey]*watchReading
+}
+
+// Subscribes to MQTT_TOPIC and redraws a dashboard in the terminal for every
+// uplink of the given station until ctx is cancelled. Nothing is written to
+// the DB, so this can run alongside the real ingestor.
+func runWatchStation(ctx context.Context, lg Logger, cfg *Config, eui string) {
+	w := &stationWatch{
+		eui:      strings.ToUpper(eui),
+		readings: make(map[watchKey]*watchReading),
+	}
+
+	client := connectMQTT(lg, cfg, func(msg mqtt.Message) {
+		p, err := parseUplink(lg, msg.Payload())
+		if err != nil || p.StationEUI != w.eui {
+			return
+		}
+		w.update(p)
+		w.draw()
+	})
+	defer client.Disconnect(250)
+
+	w.draw()
+	<-ctx.Done()
+}
+
+func (w *stationWatch) update(p *Parsed) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.devID = p.StationDevID
+	w.lastSeen = p.When
+	w.frames++
+
+	if len(p.Msg.RxMetadata) > 0 {
+		rm := p.Msg.RxMetadata[0]
+		w.gateway = rm.GatewayIDs.GatewayID
+		if rm.RSSI != nil {
+			w.rssi = appendHistory(w.rssi, float64(*rm.RSSI))
+		}
+		if rm.SNR != nil {
+			w.snr = appendHistory(w.snr, *rm.SNR)
+		}
+	}
+
+	for _, s := range p.Msg.DecodedPayload.Slaves {
+		for _, m := range s.Sensors {
+			k := watchKey{Slave: s.ID, Type: m.Type, Index: m.Index}
+			r, ok := w.readings[k]
+			if !ok {
+				w.readings[k] = &watchReading{Value: m.Value, At: p.When}
+				continue
+			}
+			r.Prev, r.HasPrev = r.Value, true
+			r.Value, r.At = m.Value, p.When
+		}
+	}
+}
+
+func (w *stationWatch) draw() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var b strings.Builder
+	b.WriteString("\033[H\033[2J") // home + clear screen
+	fmt.Fprintf(&b, "Station %s", w.eui)
+	if w.devID != "" {
+		fmt.Fprintf(&b, " (%s)", w.devID)
+	}
+	b.WriteString("\n\n")
+
+	if w.lastSeen.IsZero() {
+		b.WriteString("waiting for uplinks...\n")
+		fmt.Print(b.String())
+		return
+	}
+
+	fmt.Fprintf(&b, "Last seen: %s (%s ago)\n", w.lastSeen.Local().Format(time.DateTime), time.Since(w.lastSeen).Round(time.Second))
+	fmt.Fprintf(&b, "Frames:    %d\n", w.frames)
+	fmt.Fprintf(&b, "Gateway:   %s\n", w.gateway)
+	if len(w.rssi) > 0 {
+		fmt.Fprintf(&b, "RSSI:      %s %6.0f dBm\n", sparkline(w.rssi), w.rssi[len(w.rssi)-1])
+	}
+	if len(w.snr) > 0 {
+		fmt.Fprintf(&b, "SNR:       %s %6.1f dB\n", sparkline(w.snr), w.snr[len(w.snr)-1])
+	}
+
+	keys := make([]watchKey, 0, len(w.readings))
+	for k := range w.readings {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, c := keys[i], keys[j]
+		if a.Slave != c.Slave {
+			return a.Slave < c.Slave
+		}
+		if a.Type != c.Type {
+			return a.Type < c.Type
+		}
+		return a.Index < c.Index
+	})
+
+	b.WriteString("\nSLAVE  TYPE  IDX         VALUE\n")
+	for _, k := range keys {
+		r := w.readings[k]
+		fmt.Fprintf(&b, "%5d  %4d  %3d  %12.3f %s\n", k.Slave, k.Type, k.Index, r.Value, trendArrow(r))
+	}
+	fmt.Print(b.String())
+}
+
+func appendHistory(h []float64, v float64) []float64 {
+	h = append(h, v)
+	if len(h) > watchHistory {
+		h = h[len(h)-watchHistory:]
+	}
+	return h
+}
+
+func sparkline(vals []float64) string {
+	lo, hi := vals[0], vals[0]
+	for _, v := range vals {
+		lo, hi = min(lo, v), max(hi, v)
+	}
+	var b strings.Builder
+	for _, v := range vals {
+		i := 0
+		if hi > lo {
+			i = int((v - lo) / (hi - lo) * float64(len(sparkBlocks)-1))
+		}
+		b.WriteRune(sparkBlocks[i])
+	}
+	return fmt.Sprintf("%-*s", watchHistory, b.String())
+}
+
+func trendArrow(r *watchReading) string {
+	switch {
+	case !r.HasPrev || r.Value == r.Prev:
+		return "→"
+	case r.Value > r.Prev:
+		return "↑"
+	default:
+		return "↓"
+	}
+}
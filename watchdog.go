@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+//--- MQTT watchdog ---//
+
+// Unix nanoseconds of the last message received on any subscription.
+var lastMessageAt atomic.Int64
+
+func markMessageReceived() { lastMessageAt.Store(time.Now().UnixNano()) }
+
+// Forces a reconnect of client when no message has arrived for timeout. This
+// catches connections that still look up (keep-alives answered) but no longer
+// deliver anything, which auto-reconnect never notices.
+func runMQTTWatchdog(ctx context.Context, lg Logger, client mqtt.Client, timeout time.Duration) {
+	markMessageReceived()
+	t := time.NewTicker(min(timeout/4, time.Minute))
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			silent := time.Since(time.Unix(0, lastMessageAt.Load()))
+			if silent <= timeout {
+				continue
+			}
+			lg.Warn("mqtt watchdog: no message for %s, reconnecting", silent.Round(time.Second))
+			client.Disconnect(250)
+			if err := reconnectMQTT(client); err != nil {
+				lg.Error("mqtt watchdog reconnect error: %v", err)
+			}
+			// Give the new connection a full window before triggering again.
+			markMessageReceived()
+		}
+	}
+}
+
+func reconnectMQTT(client mqtt.Client) error {
+	if apiKeys != nil {
+		return apiKeys.connect(client)
+	}
+	token := client.Connect()
+	token.Wait()
+	return token.Error()
+}
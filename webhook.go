@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//--- Webhook server ---//
+
+// Max accepted uplink body; TTN uplinks are a few KB at most.
+const maxWebhookBody = 1 << 20
+
+// Checks the X-Downlink-Apikey header against secret (if set), answering 401
+// when it doesn't match.
+func webhookAuthorized(w http.ResponseWriter, r *http.Request, secret string) bool {
+	if secret == "" {
+		return true
+	}
+	got := r.Header.Get("X-Downlink-Apikey")
+	if subtle.ConstantTimeCompare([]byte(got), []byte(secret)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// Reads the request body and, when signingKey is set, checks the
+// X-TTN-Signature header: the hex HMAC-SHA256 of the raw body under
+// signingKey, optionally prefixed with "sha256=". Answers 400/401 and returns
+// false on failure.
+func readWebhookBody(w http.ResponseWriter, r *http.Request, signingKey string) ([]byte, bool) {
+	b, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
+	if err != nil {
+		http.Error(w, "read body: "+err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	if signingKey == "" {
+		return b, true
+	}
+	got, err := hex.DecodeString(strings.TrimPrefix(r.Header.Get("X-TTN-Signature"), "sha256="))
+	mac := hmac.New(sha256.New, []byte(signingKey))
+	mac.Write(b)
+	if err != nil || !hmac.Equal(got, mac.Sum(nil)) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return nil, false
+	}
+	return b, true
+}
+
+// Accepts TTN webhook uplinks on POST /webhook/up and feeds them through the
+// same pipeline as MQTT messages. When secret is non-empty, requests must
+// carry it in the X-Downlink-Apikey header, and when signingKey is set they
+// must be signed (see readWebhookBody). Each client IP is limited to rps
+// requests per second with the given burst.
+func startWebhookServer(ctx context.Context, lg Logger, addr string, sink Sink, secret, signingKey string, rps float64, burst int) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /webhook/up", func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		if !webhookAuthorized(w, r, secret) {
+			return
+		}
+
+		b, ok := readWebhookBody(w, r, signingKey)
+		if !ok {
+			return
+		}
+
+		if err := ingestUplink(r.Context(), lg, sink, b, "", start); err != nil {
+			http.Error(w, err.Error(), ingestErrorStatus(err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// Downlink queued/sent events, for webhooks with those messages enabled.
+	mux.HandleFunc("POST /webhook/down", func(w http.ResponseWriter, r *http.Request) {
+		if !webhookAuthorized(w, r, secret) {
+			return
+		}
+
+		b, ok := readWebhookBody(w, r, signingKey)
+		if !ok {
+			return
+		}
+
+		if downlinks != nil {
+			if err := downlinks.ingest(r.Context(), b); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           newIPRateLimiter(ctx, rps, burst).middleware(mux),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	go func() {
+		lg.Info("webhook server listening on %s", addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("webhook server: %v", err)
+		}
+	}()
+}
+
+// HTTP status for an ingestUplink error: 503 when retrying may succeed, 400
+// for an uplink that will never parse.
+func ingestErrorStatus(err error) int {
+	if errors.Is(err, errIngestUnavailable) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+//--- Measurement writer statistics ---//
+
+// Length of the sliding window behind the insertion and error rates.
+const writerStatsWindow = 60 * time.Second
+
+// Tracks measurement inserts and insert errors from every write path
+// (single, batched and retried) for GET /api/v1/stats.
+var writerStats = newWriterStats(time.Now())
+
+func init() {
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "ingestor_measurement_insert_rate",
+		Help: "Measurements inserted per second over the last minute.",
+	}, func() float64 {
+		return writerStats.snapshot(time.Now()).InsertionsPerSecond
+	}))
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "ingestor_measurement_insert_error_rate",
+		Help: "Share of measurement inserts that failed over the last minute.",
+	}, func() float64 {
+		return writerStats.snapshot(time.Now()).ErrorRate
+	}))
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "ingestor_measurement_queue_depth",
+		Help: "Measurement rows waiting in the batcher.",
+	}, func() float64 {
+		return float64(batcher.pending())
+	}))
+}
+
+// Counts of one second of the window.
+type writerStatsBucket struct {
+	sec      int64 // unix second the counts belong to
+	inserts  uint64
+	failures uint64
+}
+
+// WriterStats keeps one bucket per second of the last writerStatsWindow in a
+// ring; a bucket is reset when its slot is reused for a newer second.
+type WriterStats struct {
+	startedAt time.Time
+
+	mu      sync.Mutex
+	buckets [int(writerStatsWindow / time.Second)]writerStatsBucket
+	inserts uint64
+	errors  uint64
+}
+
+func newWriterStats(now time.Time) *WriterStats {
+	return &WriterStats{startedAt: now}
+}
+
+// Records n measurements written to the database.
+func (s *WriterStats) recordInserts(n int) {
+	s.record(time.Now(), uint64(n), 0)
+}
+
+// Records n measurements that failed to be written.
+func (s *WriterStats) recordErrors(n int) {
+	s.record(time.Now(), 0, uint64(n))
+}
+
+func (s *WriterStats) record(now time.Time, inserts, failures uint64) {
+	sec := now.Unix()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := &s.buckets[sec%int64(len(s.buckets))]
+	if b.sec != sec {
+		*b = writerStatsBucket{sec: sec}
+	}
+	b.inserts += inserts
+	b.failures += failures
+	s.inserts += inserts
+	s.errors += failures
+}
+
+type writerStatsJSON struct {
+	InsertionsPerSecond float64   `json:"insertions_per_second"`
+	ErrorsPerSecond     float64   `json:"errors_per_second"`
+	ErrorRate           float64   `json:"error_rate"`
+	InsertionsTotal     uint64    `json:"insertions_total"`
+	ErrorsTotal         uint64    `json:"errors_total"`
+	QueueDepth          int       `json:"queue_depth"`
+	WindowSeconds       int       `json:"window_seconds"`
+	StartedAt           time.Time `json:"started_at"`
+}
+
+// Rates over the window ending at now. Right after startup the window is
+// shortened to the uptime so the rates are not understated.
+func (s *WriterStats) snapshot(now time.Time) writerStatsJSON {
+	sec := now.Unix()
+	window := int64(len(s.buckets))
+
+	s.mu.Lock()
+	var inserts, failures uint64
+	for _, b := range s.buckets {
+		if b.sec > sec-window && b.sec <= sec {
+			inserts += b.inserts
+			failures += b.failures
+		}
+	}
+	out := writerStatsJSON{
+		InsertionsTotal: s.inserts,
+		ErrorsTotal:     s.errors,
+		QueueDepth:      batcher.pending(),
+		WindowSeconds:   int(window),
+		StartedAt:       s.startedAt.UTC(),
+	}
+	s.mu.Unlock()
+
+	secs := min(now.Sub(s.startedAt).Seconds(), float64(window))
+	if secs < 1 {
+		secs = 1
+	}
+	out.InsertionsPerSecond = float64(inserts) / secs
+	out.ErrorsPerSecond = float64(failures) / secs
+	if inserts+failures > 0 {
+		out.ErrorRate = float64(failures) / float64(inserts+failures)
+	}
+	return out
+}